@@ -325,3 +325,57 @@ func HslToRgb(h, s, l float64) (r, g, b float64) {
 	}
 	return r, g, b
 }
+
+//================================================================================
+// OKLAB
+
+// Oklab is a perceptual color space: equal steps look about equally different, and
+// turning the hue (the angle of a and b) keeps the lightness L the same.
+// See https://bottosson.github.io/posts/oklab/
+
+// Convert a gamma-encoded sRGB value to linear light.  Negative values stay negative.
+func SrgbToLinear(x float64) float64 {
+	if x < 0 {
+		return -SrgbToLinear(-x)
+	}
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
+
+// Convert linear light to a gamma-encoded sRGB value.  Negative values stay negative.
+func LinearToSrgb(x float64) float64 {
+	if x < 0 {
+		return -LinearToSrgb(-x)
+	}
+	if x <= 0.0031308 {
+		return x * 12.92
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// Inputs are sRGB in the range 0-1.
+// L is from 0 to 1; a and b are roughly from -0.4 to 0.4.
+func RgbToOklab(r, g, b float64) (L, A, B float64) {
+	r, g, b = SrgbToLinear(r), SrgbToLinear(g), SrgbToLinear(b)
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	L = 0.2104542553*l + 0.7936177850*m - 0.0040720468*s
+	A = 1.9779984951*l - 2.4285922050*m + 0.4505937099*s
+	B = 0.0259040371*l + 0.7827717662*m - 0.8086757660*s
+	return L, A, B
+}
+
+// The reverse of RgbToOklab.  Colors outside the sRGB gamut come back outside the range 0-1.
+func OklabToRgb(L, A, B float64) (r, g, b float64) {
+	l := L + 0.3963377774*A + 0.2158037573*B
+	m := L - 0.1055613458*A - 0.0638541728*B
+	s := L - 0.0894841775*A - 1.2914855480*B
+	l, m, s = l*l*l, m*m*m, s*s*s
+	r = 4.0767416621*l - 3.3077115913*m + 0.2309699292*s
+	g = -1.2684380046*l + 2.6097574011*m - 0.3413193965*s
+	b = -0.0041960863*l - 0.7034186147*m + 1.7076147010*s
+	return LinearToSrgb(r), LinearToSrgb(g), LinearToSrgb(b)
+}
@@ -495,3 +495,27 @@ func BenchmarkHslToRgb(b *testing.B) {
 		HslToRgb(0.1, 0.2, 0.3)
 	}
 }
+
+//================================================================================
+func TestOklab(t *testing.T) {
+	// white is L=1 with no color, and red is a known value
+	if L, a, b := RgbToOklab(1, 1, 1); math.Abs(L-1) > 1e-4 || math.Abs(a) > 1e-4 || math.Abs(b) > 1e-4 {
+		t.Errorf("RgbToOklab(1,1,1) = %f,%f,%f, want 1,0,0", L, a, b)
+	}
+	if L, a, b := RgbToOklab(1, 0, 0); math.Abs(L-0.6279) > 1e-3 || math.Abs(a-0.2249) > 1e-3 || math.Abs(b-0.1258) > 1e-3 {
+		t.Errorf("RgbToOklab(1,0,0) = %f,%f,%f, want 0.6279,0.2249,0.1258", L, a, b)
+	}
+	// round trips
+	for _, rgb := range [][3]float64{{0, 0, 0}, {1, 0, 0}, {0.2, 0.7, 0.3}, {0.05, 0.01, 0.9}, {0.5, 0.5, 0.5}} {
+		L, a, b := RgbToOklab(rgb[0], rgb[1], rgb[2])
+		r, g, bb := OklabToRgb(L, a, b)
+		if math.Abs(r-rgb[0]) > 1e-6 || math.Abs(g-rgb[1]) > 1e-6 || math.Abs(bb-rgb[2]) > 1e-6 {
+			t.Errorf("Oklab round trip of %v = %f,%f,%f", rgb, r, g, bb)
+		}
+	}
+}
+func BenchmarkOklab(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		OklabToRgb(RgbToOklab(0.1, 0.2, 0.3))
+	}
+}
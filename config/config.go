@@ -4,41 +4,68 @@ import (
 	"github.com/longears/pixelslinger/midi"
 )
 
+// These are the logical controls that patterns and effects listen to.
+// The defaults suit an AKAI LPD8.  A controller profile (see profiles.go) moves them
+// to suit other controllers, and a mapping file (see mapping.go) can move them
+// to other notes, controllers, and channels without recompiling.
+
 // midi pads
-const (
-	FLASH_PAD         = midi.LPD8_PAD1
-	TWINKLE_PAD       = midi.LPD8_PAD2
-	FLUSH_PAD         = midi.LPD8_PAD3 // todo
-	SLOWMO_PAD        = midi.LPD8_PAD4
-	BLINK_CIRCLE_PAD  = midi.LPD8_PAD5
-	BLINK_ARCH_PAD    = midi.LPD8_PAD6
-	BLINK_BACK_PAD    = midi.LPD8_PAD7
-	FADE_TO_BLACK_PAD = midi.LPD8_PAD8
+var (
+	FLASH_PAD         = &Control{Name: "flash", Kind: PAD, Number: midi.LPD8_PAD1}
+	TWINKLE_PAD       = &Control{Name: "twinkle", Kind: PAD, Number: midi.LPD8_PAD2}
+	FLUSH_PAD         = &Control{Name: "flush", Kind: PAD, Number: midi.LPD8_PAD3} // todo
+	SLOWMO_PAD        = &Control{Name: "slowmo", Kind: PAD, Number: midi.LPD8_PAD4}
+	BLINK_CIRCLE_PAD  = &Control{Name: "blink-circle", Kind: PAD, Number: midi.LPD8_PAD5}
+	BLINK_ARCH_PAD    = &Control{Name: "blink-arch", Kind: PAD, Number: midi.LPD8_PAD6}
+	BLINK_BACK_PAD    = &Control{Name: "blink-back", Kind: PAD, Number: midi.LPD8_PAD7}
+	FADE_TO_BLACK_PAD = &Control{Name: "fade-to-black", Kind: PAD, Number: midi.LPD8_PAD8}
+
+	// taps the tempo when there's no midi clock.
+	// all of the LPD8's pads are taken, so this is off unless you have a spare.
+	TAP_TEMPO_PAD = &Control{Name: "tap-tempo", Kind: PAD, Off: true}
+
+	// wash the frame in one color channel, or send a ripple through it.
+	// off on the LPD8 for the same reason.
+	BLINK_RED_PAD   = &Control{Name: "blink-red", Kind: PAD, Off: true}
+	BLINK_GREEN_PAD = &Control{Name: "blink-green", Kind: PAD, Off: true}
+	BLINK_BLUE_PAD  = &Control{Name: "blink-blue", Kind: PAD, Off: true}
+	RIPPLE_PAD      = &Control{Name: "ripple", Kind: PAD, Off: true}
 )
 
-// midi knobs
-const (
-	GAIN_KNOB   = midi.LPD8_KNOB1 // effect
-	EYELID_KNOB = midi.LPD8_KNOB2 // effect
-	SPEED_KNOB  = midi.LPD8_KNOB3 //   pattern (diamond, fire, raver-plaid, shield, sunset)
-	SWITCH_KNOB = midi.LPD8_KNOB4 //     midi-switcher
-	MORPH_KNOB  = midi.LPD8_KNOB5 //   pattern (diamond, white)
-	HUE_KNOB    = midi.LPD8_KNOB6 //   pattern (diamond, fire, white)
-	DESAT_KNOB  = midi.LPD8_KNOB7 // effect
+// midi knobs.
+// Default is the starting value before the knob has been moved,
+// because the midi hardware only sends us values when the knobs move.
+var (
+	GAIN_KNOB    = &Control{Name: "gain", Kind: KNOB, Number: midi.LPD8_KNOB1, Default: 127, Slew: 0.1, Pickup: true} // effect
+	EYELID_KNOB  = &Control{Name: "eyelid", Kind: KNOB, Number: midi.LPD8_KNOB2, Default: 127, Pickup: true}          // effect
+	SPEED_KNOB   = &Control{Name: "speed", Kind: KNOB, Number: midi.LPD8_KNOB3, Default: 63, Pickup: true}            //   pattern (diamond, fire, raver-plaid, shield, sunset)
+	SWITCH_KNOB  = &Control{Name: "switch", Kind: KNOB, Number: midi.LPD8_KNOB4}                                      //     midi-switcher
+	MORPH_KNOB   = &Control{Name: "morph", Kind: KNOB, Number: midi.LPD8_KNOB5, Slew: 0.1}                            //   pattern (diamond, white)
+	HUE_KNOB     = &Control{Name: "hue", Kind: KNOB, Number: midi.LPD8_KNOB6, Slew: 0.1}                              //   pattern (diamond, fire, white), or every pattern with the hue effect
+	DESAT_KNOB   = &Control{Name: "desat", Kind: KNOB, Number: midi.LPD8_KNOB7, Slew: 0.1}                            // effect
+	EXTRACT_KNOB = &Control{Name: "extract", Kind: KNOB, Number: midi.LPD8_KNOB8, Slew: 0.1}                          // effect
 )
 
-// knob starting values before they have been moved
-//  (because the midi hardware only sends us values when the knobs move)
+// Every logical control, in the order midi learn asks for them.
+var CONTROLS = []*Control{
+	GAIN_KNOB, EYELID_KNOB, SPEED_KNOB, SWITCH_KNOB, MORPH_KNOB, HUE_KNOB, DESAT_KNOB, EXTRACT_KNOB,
+	FLASH_PAD, TWINKLE_PAD, FLUSH_PAD, SLOWMO_PAD, BLINK_CIRCLE_PAD, BLINK_ARCH_PAD, BLINK_BACK_PAD, FADE_TO_BLACK_PAD,
+	TAP_TEMPO_PAD, BLINK_RED_PAD, BLINK_GREEN_PAD, BLINK_BLUE_PAD, RIPPLE_PAD,
+}
+
+// knob starting values before they have been moved, by controller number.
+// This is rebuilt from CONTROLS whenever a mapping file is read.
 var DEFAULT_KNOB_VALUES map[byte]byte
 
 func init() {
-	DEFAULT_KNOB_VALUES = map[byte]byte{
-		GAIN_KNOB:   127,
-		EYELID_KNOB: 127,
-		SPEED_KNOB:  63,
-		SWITCH_KNOB: 0,
-		MORPH_KNOB:  0,
-		HUE_KNOB:    0,
-		DESAT_KNOB:  0,
+	updateDefaultKnobValues()
+}
+
+func updateDefaultKnobValues() {
+	DEFAULT_KNOB_VALUES = make(map[byte]byte)
+	for _, control := range CONTROLS {
+		if control.Kind == KNOB && !control.Off {
+			DEFAULT_KNOB_VALUES[control.Number] = control.Default
+		}
 	}
 }
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// A knob using pickup takes over once it comes this close to the value it's supposed to be at,
+// as a fraction of its travel, even if it doesn't pass it.
+const PICKUP_WINDOW = 2.0 / 127
+
+// Where one control is on its way to, and whether its knob has been picked up.
+type controlState struct {
+	position float64 // smoothed position from 0 to 1, before the curve and range
+	target   float64 // where position is heading
+	lastRaw  byte
+	moved    bool // has lastRaw come from the controller, rather than the knob's default?
+	pickedUp bool
+}
+
+// Sits between the midi state and the patterns, and works out every control's value
+// once per frame with its slew, pickup, dead zones and curve.  Patterns get the values
+// through Control.Value as usual.
+//
+// Slew smooths out the steps between a knob's 128 positions.  Pickup (also called soft
+// takeover) keeps a knob from jumping the value to wherever the knob happens to be:
+// the value stays put until the knob is turned through it.
+type ControlLayer struct {
+	states     map[string]*controlState
+	lastUpdate time.Time
+}
+
+func NewControlLayer() *ControlLayer {
+	return &ControlLayer{states: make(map[string]*controlState)}
+}
+
+// Forget where the controls were, for when the mapping or the profile changes.
+// Knobs using pickup have to be picked up again.
+func (cl *ControlLayer) Reset() {
+	cl.states = make(map[string]*controlState)
+}
+
+// Work out the value of every control for a frame beginning at now, and store them in
+// midiState.ControlValues.  Call this once per frame after updating the midi state.
+func (cl *ControlLayer) Update(midiState *midi.MidiState, now time.Time) {
+	dt := 0.0
+	if !cl.lastUpdate.IsZero() {
+		dt = now.Sub(cl.lastUpdate).Seconds()
+	}
+	cl.lastUpdate = now
+
+	values := make(map[string]float64, len(CONTROLS))
+	for _, control := range CONTROLS {
+		raw := control.Raw(midiState)
+		x := control.position(raw)
+		state, ok := cl.states[control.Name]
+		if !ok {
+			state = &controlState{position: x, target: x, lastRaw: raw, pickedUp: !control.Pickup}
+			cl.states[control.Name] = state
+		}
+
+		if raw != state.lastRaw {
+			if !state.pickedUp {
+				// did the knob come close to the target, or pass it since last time?
+				// until it has moved once we don't know where it was, so it can't have passed anything.
+				last := control.position(state.lastRaw)
+				near := math.Abs(x-state.target) <= PICKUP_WINDOW
+				passed := state.moved && (last-state.target)*(x-state.target) <= 0
+				if near || passed {
+					fmt.Println("[config.ControlLayer] picked up the", control.Name, "knob")
+					state.pickedUp = true
+				}
+			}
+			state.lastRaw = raw
+			state.moved = true
+		}
+		if state.pickedUp || !control.Pickup {
+			state.target = x
+		}
+
+		if control.Slew > 0 && dt > 0 {
+			state.position += (state.target - state.position) * (1 - math.Exp(-dt/control.Slew))
+			if math.Abs(state.target-state.position) < 1e-4 {
+				state.position = state.target
+			}
+		} else {
+			state.position = state.target
+		}
+		values[control.Name] = control.shape(state.position)
+	}
+	midiState.ControlValues = values
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestControlLayer(t *testing.T) {
+	defer saveControls()()
+	type step struct {
+		raw  byte    // where the knob is
+		dt   float64 // seconds since the last step
+		want float64
+	}
+	tests := []struct {
+		name    string
+		control Control
+		steps   []step
+	}{
+		{"pickup on pass", Control{Pickup: true}, []step{
+			{64, 0, 64.0 / 127},
+			{20, 0.1, 64.0 / 127},
+			{30, 0.1, 64.0 / 127}, // hasn't reached the value yet
+			{100, 0.1, 100.0 / 127},
+			{90, 0.1, 90.0 / 127}, // picked up, so it follows
+		}},
+		{"pickup near target", Control{Pickup: true}, []step{
+			{64, 0, 64.0 / 127},
+			{20, 0.1, 64.0 / 127},
+			{66, 0.1, 66.0 / 127},
+		}},
+		{"pickup after the first move", Control{Pickup: true}, []step{
+			{64, 0, 64.0 / 127},
+			{100, 0.1, 64.0 / 127}, // the knob could have been anywhere before this
+			{63, 0.1, 63.0 / 127},
+		}},
+		{"no pickup", Control{}, []step{
+			{64, 0, 64.0 / 127},
+			{20, 0.1, 20.0 / 127},
+		}},
+		{"slew", Control{Slew: 0.1}, []step{
+			{0, 0, 0},
+			{127, 0.1, 1 - math.Exp(-1)},
+			{127, 0.1, 1 - math.Exp(-2)},
+			{127, 10, 1},
+		}},
+		{"dead zone", Control{DeadZone: 0.1}, []step{
+			{6, 0, 0},
+			{121, 0.1, 1},
+			{64, 0.1, (64.0/127 - 0.1) / 0.8},
+		}},
+		{"curve and range", Control{Min: 2, Max: 4, Curve: CURVE_EXP}, []step{
+			{127, 0, 4},
+			{64, 0.1, 2 + 2*math.Pow(64.0/127, 2)},
+		}},
+	}
+	for _, tt := range tests {
+		control := tt.control
+		control.Name, control.Kind, control.Number = "test", KNOB, 1
+		CONTROLS = []*Control{&control}
+
+		cl := NewControlLayer()
+		midiState := &midi.MidiState{}
+		now := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+		for ii, s := range tt.steps {
+			now = now.Add(time.Duration(s.dt * float64(time.Second)))
+			midiState.ControllerValues[1] = s.raw
+			cl.Update(midiState, now)
+			if got := control.Value(midiState); math.Abs(got-s.want) > 1e-6 {
+				t.Errorf("%s, step %d: got %v, want %v", tt.name, ii, got, s.want)
+			}
+		}
+	}
+}
+
+func TestControlLayerReset(t *testing.T) {
+	defer saveControls()()
+	control := Control{Name: "test", Kind: KNOB, Number: 1, Pickup: true}
+	CONTROLS = []*Control{&control}
+	cl := NewControlLayer()
+	midiState := &midi.MidiState{}
+	now := time.Now()
+
+	midiState.ControllerValues[1] = 64
+	cl.Update(midiState, now)
+	midiState.ControllerValues[1] = 0
+	cl.Update(midiState, now)
+	if v := control.Value(midiState); v != 64.0/127 {
+		t.Errorf("got %v before the knob was picked up, want %v", v, 64.0/127)
+	}
+	// after a reset the knob starts wherever it is
+	cl.Reset()
+	cl.Update(midiState, now)
+	if v := control.Value(midiState); v != 0 {
+		t.Errorf("got %v after a reset, want 0", v)
+	}
+}
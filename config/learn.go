@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// After a control is learned, wait until the controller has been quiet for this long
+// so the rest of the wiggle isn't mistaken for the next control.
+const LEARN_QUIET_TIME = 700 * time.Millisecond
+
+// MIDI learn: ask for each control in turn to be wiggled and remember which channel
+// and number it sends on.  A value on skip moves on to the next control without
+// changing the current one.  Each control keeps its range, curve, and default.
+func Learn(midiMessageChan chan *midi.MidiMessage, skip chan bool) {
+	for _, control := range CONTROLS {
+		verb, noun := "turn", KNOB
+		if control.IsPad() {
+			verb, noun = "hit", PAD
+		}
+		fmt.Printf("[config.Learn] %s the %s %s, or press enter to skip it\n", verb, control.Name, noun)
+
+	waitForControl:
+		for {
+			select {
+			case <-skip:
+				fmt.Println("[config.Learn]     skipped")
+				break waitForControl
+			case m, ok := <-midiMessageChan:
+				if !ok {
+					return
+				}
+				kind := ""
+				switch {
+				case control.Kind == KNOB && m.Kind == midi.CONTROLLER:
+					kind = KNOB
+				case control.IsPad() && m.Kind == midi.NOTE_ON && m.Value > 0:
+					kind = PAD
+				case control.IsPad() && m.Kind == midi.CONTROLLER && m.Value > 0:
+					// some controllers' buttons send controllers instead of notes
+					kind = BUTTON
+				}
+				if kind != "" {
+					control.Kind = kind
+					control.Off = false
+					control.Channel = int(m.Channel) + 1
+					control.Number = m.Key
+					fmt.Printf("[config.Learn]     %s is number %d on channel %d\n", control.Name, control.Number, control.Channel)
+					waitForQuiet(midiMessageChan)
+					break waitForControl
+				}
+			}
+		}
+	}
+	updateDefaultKnobValues()
+}
+
+// Throw away midi messages until no pads or knobs have moved for LEARN_QUIET_TIME.
+// Clock messages don't count since they never stop.
+func waitForQuiet(midiMessageChan chan *midi.MidiMessage) {
+	quiet := time.NewTimer(LEARN_QUIET_TIME)
+	defer quiet.Stop()
+	for {
+		select {
+		case m, ok := <-midiMessageChan:
+			if !ok {
+				return
+			}
+			if m.Kind != midi.SYSTEM {
+				quiet.Reset(LEARN_QUIET_TIME)
+			}
+		case <-quiet.C:
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Note velocities (or controller values, for buttons) which light a controller's pads.
+type Lights struct {
+	Off     byte
+	Down    byte // a pad which is held down
+	Pattern byte // the pattern pad of the pattern that's showing
+	Beat    byte // the tap-tempo pad and the showing pattern's pad flash this on the beat
+}
+
+// Fraction of each beat that the beat flash stays lit.
+const BEAT_FLASH = 0.15
+
+// Send every light again this often, in case the controller was unplugged and lost them.
+const LIGHTS_REFRESH = 5 * time.Second
+
+// Keeps the controller's pad lights in step with what the pads are doing.
+type PadLights struct {
+	sent        map[PhysicalControl]byte // what each light was last set to
+	profile     *Profile                 // profile the lights were sent for
+	lastRefresh time.Time
+}
+
+func NewPadLights() *PadLights {
+	return &PadLights{sent: make(map[PhysicalControl]byte)}
+}
+
+// Return the midi messages which bring the pad lights up to date: pads light while
+// they're held down, the pad for the selected pattern stays lit, and both it and the
+// tap-tempo pad flash on the beat.
+// selectedPattern is the index of the midi-switcher's pattern in PATTERN_PADS, or -1 for none.
+// Only lights which changed are sent, except every LIGHTS_REFRESH when they all are.
+// Call Sent for each message which gets to the controller; the others are sent again next time.
+func (pl *PadLights) Update(midiState *midi.MidiState, selectedPattern int, now time.Time) []*midi.MidiMessage {
+	lights := PROFILE.Lights
+	if lights == nil {
+		return nil
+	}
+	if pl.profile != PROFILE || now.Sub(pl.lastRefresh) > LIGHTS_REFRESH {
+		pl.sent = make(map[PhysicalControl]byte)
+		pl.profile = PROFILE
+		pl.lastRefresh = now
+	}
+	onBeat := midiState.Clock.BPM > 0 && midiState.Clock.BeatPhase(now) < BEAT_FLASH
+
+	want := make(map[PhysicalControl]byte)
+	channels := make(map[PhysicalControl]int)
+	for ii, pad := range PATTERN_PADS {
+		light := lights.Off
+		if ii == selectedPattern {
+			light = lights.Pattern
+			if onBeat {
+				light = lights.Beat
+			}
+		}
+		physical := PhysicalControl{pad.Kind, pad.Number}
+		want[physical] = light
+		channels[physical] = pad.Channel
+	}
+	for _, control := range CONTROLS {
+		if !control.IsPad() || control.Off {
+			continue
+		}
+		light := lights.Off
+		if control.Down(midiState) {
+			light = lights.Down
+		}
+		if control == TAP_TEMPO_PAD && onBeat {
+			light = lights.Beat
+		}
+		physical := PhysicalControl{control.Kind, control.Number}
+		// a pad which is also a pattern pad only covers up the pattern light while it's lit
+		if _, ok := want[physical]; !ok || light != lights.Off {
+			want[physical] = light
+		}
+		channels[physical] = control.Channel
+	}
+
+	messages := []*midi.MidiMessage{}
+	for physical, light := range want {
+		if sent, ok := pl.sent[physical]; ok && sent == light {
+			continue
+		}
+		m := &midi.MidiMessage{Kind: midi.NOTE_ON, Key: physical.Number, Value: light}
+		if physical.Kind == BUTTON {
+			m.Kind = midi.CONTROLLER
+		}
+		if channel := channels[physical]; channel >= 1 && channel <= 16 {
+			m.Channel = byte(channel - 1)
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// Remember that a message from Update got to the controller.
+func (pl *PadLights) Sent(m *midi.MidiMessage) {
+	kind := PAD
+	if m.Kind == midi.CONTROLLER {
+		kind = BUTTON
+	}
+	pl.sent[PhysicalControl{kind, m.Key}] = m.Value
+}
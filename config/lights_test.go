@@ -0,0 +1,87 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Return the lights in a batch of messages by note or controller number.
+func lightValues(messages []*midi.MidiMessage) map[byte]byte {
+	values := make(map[byte]byte)
+	for _, m := range messages {
+		values[m.Key] = m.Value
+	}
+	return values
+}
+
+func TestPadLights(t *testing.T) {
+	defer saveControls()()
+	if err := ApplyProfile(LookupProfile("apc-mini")); err != nil {
+		t.Fatal(err)
+	}
+	lights := PROFILE.Lights
+	pl := NewPadLights()
+	midiState := &midi.MidiState{}
+	now := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+
+	// the first time, every light is sent
+	messages := pl.Update(midiState, 2, now)
+	values := lightValues(messages)
+	if len(values) != 64+13 { // the grid, and the scene and track pads with controls on them
+		t.Errorf("got %d lights, want 77", len(values))
+	}
+	if values[2] != lights.Pattern || values[3] != lights.Off || values[82] != lights.Off {
+		t.Errorf("got %v, want the selected pattern's pad lit", values)
+	}
+
+	// nothing is recorded until it's sent
+	if again := pl.Update(midiState, 2, now); len(again) != len(messages) {
+		t.Errorf("got %d lights after none were sent, want %d again", len(again), len(messages))
+	}
+	for _, m := range messages {
+		pl.Sent(m)
+	}
+	if again := pl.Update(midiState, 2, now); len(again) != 0 {
+		t.Errorf("got %v when nothing changed", again)
+	}
+
+	// holding the flash pad lights it, and picking another pattern moves the pattern light
+	midiState.KeyVolumes[FLASH_PAD.Number] = 100
+	messages = pl.Update(midiState, 5, now)
+	values = lightValues(messages)
+	if len(values) != 3 || values[FLASH_PAD.Number] != lights.Down || values[2] != lights.Off || values[5] != lights.Pattern {
+		t.Errorf("got %v, want flash down and the pattern light moved from 2 to 5", values)
+	}
+	for _, m := range messages {
+		if m.Kind != midi.NOTE_ON {
+			t.Errorf("got %v, want notes for the apc mini's pads", m)
+		}
+		pl.Sent(m)
+	}
+
+	// everything is sent again every LIGHTS_REFRESH
+	if again := pl.Update(midiState, 5, now.Add(LIGHTS_REFRESH+time.Second)); len(again) != 77 {
+		t.Errorf("got %d lights after the refresh time, want 77", len(again))
+	}
+
+	// buttons light with controllers
+	if err := ApplyProfile(LookupProfile("nanokontrol2")); err != nil {
+		t.Fatal(err)
+	}
+	midiState.ControllerValues[FLASH_PAD.Number] = 127
+	for _, m := range pl.Update(midiState, -1, now) {
+		if m.Key == FLASH_PAD.Number && (m.Kind != midi.CONTROLLER || m.Value != PROFILE.Lights.Down) {
+			t.Errorf("got %v for the flash button, want a controller at %v", m, PROFILE.Lights.Down)
+		}
+	}
+
+	// and the lpd8 can't be lit
+	if err := ApplyProfile(LookupProfile("lpd8")); err != nil {
+		t.Fatal(err)
+	}
+	if messages := pl.Update(midiState, 0, now); messages != nil {
+		t.Errorf("got %v for the lpd8", messages)
+	}
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// kinds of controls
+const (
+	KNOB   = "knob"   // a midi controller
+	PAD    = "pad"    // a midi note
+	BUTTON = "button" // a midi controller which acts like a pad: non-zero while held down
+)
+
+// response curves
+const (
+	CURVE_LINEAR = "linear"
+	CURVE_EXP    = "exp" // fine control near the bottom of the knob's travel
+	CURVE_LOG    = "log" // fine control near the top
+)
+
+// A logical control like the gain knob or the flash pad, and the midi message which moves it.
+type Control struct {
+	Name    string  `json:"-"`
+	Kind    string  `json:"kind"`          // KNOB, PAD, or BUTTON
+	Channel int     `json:"channel"`       // midi channel from 1 to 16, or 0 for any channel
+	Number  byte    `json:"number"`        // controller or note number
+	Min     float64 `json:"min"`           // Value at the bottom of the knob or for the lightest pad hit
+	Max     float64 `json:"max"`           // Value at the top, or 0 along with Min to mean 1
+	Curve   string  `json:"curve"`         // one of the CURVE_* constants, linear if empty
+	Default byte    `json:"default"`       // knob position to assume before it moves, from 0 to 127
+	Off     bool    `json:"off,omitempty"` // nothing moves this control, so knobs stay at Default and pads stay up
+
+	// see ControlLayer
+	Slew     float64 `json:"slew,omitempty"`     // seconds to move most of the way to a new value, or 0 to jump
+	Pickup   bool    `json:"pickup,omitempty"`   // a knob does nothing until it passes the value it's supposed to be at
+	DeadZone float64 `json:"deadzone,omitempty"` // fraction of the travel at each end which counts as all the way, below 0.5
+}
+
+// Is this one of the pads, whether the controller sends notes or controllers for it?
+func (c *Control) IsPad() bool {
+	return c.Kind == PAD || c.Kind == BUTTON
+}
+
+// Return the control's raw value from 0 to 127: the knob's position or the pad's velocity.
+func (c *Control) Raw(midiState *midi.MidiState) byte {
+	if c.Off {
+		if c.Kind == KNOB {
+			return c.Default
+		}
+		return 0
+	}
+	if c.Channel >= 1 && c.Channel <= 16 {
+		channel := &midiState.Channels[c.Channel-1]
+		if c.Kind == PAD {
+			return channel.KeyVolumes[c.Number]
+		}
+		return channel.ControllerValues[c.Number]
+	}
+	if c.Kind == PAD {
+		return midiState.KeyVolumes[c.Number]
+	}
+	return midiState.ControllerValues[c.Number]
+}
+
+// Return the control's value after its curve and range, normally from 0 to 1.
+// If a ControlLayer has worked out the value for this frame, that's used instead of the raw value.
+func (c *Control) Value(midiState *midi.MidiState) float64 {
+	if value, ok := midiState.ControlValues[c.Name]; ok {
+		return value
+	}
+	return c.shape(c.position(c.Raw(midiState)))
+}
+
+// Return where a raw value from 0 to 127 sits in the control's travel, from 0 to 1,
+// after taking off the dead zones.
+func (c *Control) position(raw byte) float64 {
+	x := float64(raw) / 127.0
+	if c.DeadZone > 0 {
+		x = math.Max(0, math.Min(1, (x-c.DeadZone)/(1-2*c.DeadZone)))
+	}
+	return x
+}
+
+// Return the control's value at a position from 0 to 1, after its curve and range.
+func (c *Control) shape(x float64) float64 {
+	switch c.Curve {
+	case CURVE_EXP:
+		x = x * x
+	case CURVE_LOG:
+		x = math.Sqrt(x)
+	}
+	minn, maxx := c.Min, c.Max
+	if minn == 0 && maxx == 0 {
+		maxx = 1
+	}
+	return minn + (maxx-minn)*x
+}
+
+// Is the pad being held down?
+func (c *Control) Down(midiState *midi.MidiState) bool {
+	return c.Raw(midiState) > 0
+}
+
+// Did the pad go down since the last midi update?
+func (c *Control) Pressed(midiState *midi.MidiState) bool {
+	if c.Off {
+		return false
+	}
+	if c.Kind == BUTTON {
+		// only leaving 0 counts, so a button which sends more than one value per press only presses once
+		if c.Channel >= 1 && c.Channel <= 16 {
+			return midiState.Channels[c.Channel-1].ControllersPressed[c.Number]
+		}
+		return midiState.ControllersPressed[c.Number]
+	}
+	if c.Channel == 0 {
+		return midiState.KeysPressed[c.Number]
+	}
+	// KeysPressed merges the channels, so a pad on one channel looks for its own notes
+	for _, m := range midiState.RecentMidiMessages {
+		if m.Kind == midi.NOTE_ON && m.Key == c.Number && m.Value > 0 && int(m.Channel)+1 == c.Channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Return an error if the control's fields don't make sense.
+func (c *Control) check() error {
+	if c.Kind != KNOB && c.Kind != PAD && c.Kind != BUTTON {
+		return fmt.Errorf("control %q: kind should be %q, %q, or %q", c.Name, KNOB, PAD, BUTTON)
+	}
+	if c.Channel < 0 || c.Channel > 16 {
+		return fmt.Errorf("control %q: channel should be from 1 to 16, or 0 for any", c.Name)
+	}
+	if c.Number > 127 || c.Default > 127 {
+		return fmt.Errorf("control %q: number and default should be from 0 to 127", c.Name)
+	}
+	switch c.Curve {
+	case "", CURVE_LINEAR, CURVE_EXP, CURVE_LOG:
+	default:
+		return fmt.Errorf("control %q: unknown curve %q", c.Name, c.Curve)
+	}
+	if c.Slew < 0 {
+		return fmt.Errorf("control %q: slew should be 0 or more seconds", c.Name)
+	}
+	if c.DeadZone < 0 || c.DeadZone >= 0.5 {
+		return fmt.Errorf("control %q: deadzone should be from 0 to less than 0.5", c.Name)
+	}
+	if c.Pickup && c.Kind != KNOB {
+		return fmt.Errorf("control %q: only knobs can use pickup", c.Name)
+	}
+	return nil
+}
+
+// Set the starting position of every knob in the midi state.
+func SetDefaultKnobValues(midiState *midi.MidiState) {
+	for _, control := range CONTROLS {
+		if control.Kind != KNOB || control.Off {
+			continue
+		}
+		midiState.ControllerValues[control.Number] = control.Default
+		if control.Channel >= 1 && control.Channel <= 16 {
+			midiState.Channels[control.Channel-1].ControllerValues[control.Number] = control.Default
+		}
+	}
+}
+
+// Return the control with the given name, or nil.
+func LookupControl(name string) *Control {
+	for _, control := range CONTROLS {
+		if control.Name == name {
+			return control
+		}
+	}
+	return nil
+}
+
+// Read a mapping file and move the controls it mentions.  For example:
+//
+//	{
+//	    "gain":  { "kind": "knob", "channel": 1, "number": 16, "default": 127, "curve": "exp", "slew": 0.1, "pickup": true },
+//	    "speed": { "kind": "knob", "number": 17, "min": 0.2, "max": 0.8 },
+//	    "flash": { "kind": "pad", "number": 60 }
+//	}
+//
+// Fields which are left out keep their current values, so a mapping only has to
+// mention what's different from the controller profile (see profiles.go).
+// Mentioning a control turns it on unless it says "off": true.
+// If any control in the file is wrong, none of them move.
+func ReadMapping(fn string) error {
+	file, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	raw := make(map[string]json.RawMessage)
+	if err := json.NewDecoder(file).Decode(&raw); err != nil {
+		return fmt.Errorf("%s: %v", fn, err)
+	}
+	updates := make(map[*Control]Control, len(raw))
+	for name, controlJson := range raw {
+		control := LookupControl(name)
+		if control == nil {
+			return fmt.Errorf("%s: unknown control %q", fn, name)
+		}
+		updated := *control
+		updated.Off = false
+		if err := json.Unmarshal(controlJson, &updated); err != nil {
+			return fmt.Errorf("%s: control %q: %v", fn, name, err)
+		}
+		if err := updated.check(); err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+		updates[control] = updated
+	}
+	for control, updated := range updates {
+		*control = updated
+	}
+	updateDefaultKnobValues()
+	return nil
+}
+
+// Write every control to a mapping file.
+func WriteMapping(fn string) error {
+	controls := make(map[string]*Control)
+	for _, control := range CONTROLS {
+		controls[control.Name] = control
+	}
+	bytes, err := json.MarshalIndent(controls, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, append(bytes, '\n'), 0644)
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Save every control, the profile, and the pattern pads, and return a func which puts them back.
+//
+//	defer saveControls()()
+func saveControls() func() {
+	controls := append([]*Control{}, CONTROLS...)
+	saved := make([]Control, len(CONTROLS))
+	for ii, control := range CONTROLS {
+		saved[ii] = *control
+	}
+	profile, patternPads := PROFILE, PATTERN_PADS
+	return func() {
+		CONTROLS = controls
+		for ii, control := range CONTROLS {
+			*control = saved[ii]
+		}
+		PROFILE, PATTERN_PADS = profile, patternPads
+		updateDefaultKnobValues()
+	}
+}
+
+func TestControlValue(t *testing.T) {
+	tests := []struct {
+		control Control
+		raw     byte
+		want    float64
+	}{
+		{Control{Kind: KNOB}, 0, 0},
+		{Control{Kind: KNOB}, 127, 1},
+		{Control{Kind: KNOB, Curve: CURVE_LINEAR}, 127, 1},
+		{Control{Kind: KNOB, Curve: CURVE_EXP}, 64, math.Pow(64.0/127, 2)},
+		{Control{Kind: KNOB, Curve: CURVE_LOG}, 64, math.Sqrt(64.0 / 127)},
+		{Control{Kind: KNOB, Min: 2, Max: 4}, 127, 4},
+		{Control{Kind: KNOB, Min: -1, Max: 1}, 0, -1},
+		{Control{Kind: KNOB, Min: 2, Max: 4, Curve: CURVE_EXP}, 64, 2 + 2*math.Pow(64.0/127, 2)},
+		{Control{Kind: KNOB, DeadZone: 0.1}, 6, 0},
+		{Control{Kind: KNOB, DeadZone: 0.1}, 121, 1},
+		{Control{Kind: KNOB, DeadZone: 0.1}, 64, (64.0/127 - 0.1) / 0.8},
+		{Control{Kind: KNOB, Off: true, Default: 127}, 0, 1}, // stays at its default
+		{Control{Kind: PAD}, 100, 100.0 / 127},
+		{Control{Kind: PAD, Off: true}, 100, 0},
+	}
+	for _, tt := range tests {
+		midiState := &midi.MidiState{}
+		midiState.ControllerValues[tt.control.Number] = tt.raw
+		midiState.KeyVolumes[tt.control.Number] = tt.raw
+		if got := tt.control.Value(midiState); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%+v at %v = %v, want %v", tt.control, tt.raw, got, tt.want)
+		}
+	}
+
+	// a control on one channel ignores the others
+	control := &Control{Kind: KNOB, Channel: 2, Number: 5}
+	midiState := &midi.MidiState{}
+	midiState.ControllerValues[5] = 127
+	if v := control.Value(midiState); v != 0 {
+		t.Errorf("got %v from the wrong channel", v)
+	}
+	midiState.Channels[1].ControllerValues[5] = 127
+	if v := control.Value(midiState); v != 1 {
+		t.Errorf("got %v from its own channel, want 1", v)
+	}
+
+	// a control layer's value wins
+	control.Name = "test"
+	midiState.ControlValues = map[string]float64{"test": 0.25}
+	if v := control.Value(midiState); v != 0.25 {
+		t.Errorf("got %v, want the control layer's 0.25", v)
+	}
+}
+
+func TestControlPressed(t *testing.T) {
+	noteOn := func(channel, key byte) *midi.MidiMessage {
+		return &midi.MidiMessage{Kind: midi.NOTE_ON, Channel: channel, Key: key, Value: 100}
+	}
+	cc := func(channel, key, value byte) *midi.MidiMessage {
+		return &midi.MidiMessage{Kind: midi.CONTROLLER, Channel: channel, Key: key, Value: value}
+	}
+	tests := []struct {
+		control  Control
+		messages []*midi.MidiMessage
+		want     bool
+	}{
+		{Control{Kind: PAD, Number: 36}, []*midi.MidiMessage{noteOn(0, 36)}, true},
+		{Control{Kind: PAD, Number: 36}, []*midi.MidiMessage{noteOn(5, 36)}, true},
+		{Control{Kind: PAD, Number: 36}, []*midi.MidiMessage{noteOn(0, 37)}, false},
+		{Control{Kind: PAD, Number: 36, Channel: 2}, []*midi.MidiMessage{noteOn(1, 36)}, true},
+		{Control{Kind: PAD, Number: 36, Channel: 2}, []*midi.MidiMessage{noteOn(0, 36)}, false},
+		{Control{Kind: PAD, Number: 36, Off: true}, []*midi.MidiMessage{noteOn(0, 36)}, false},
+		{Control{Kind: BUTTON, Number: 40}, []*midi.MidiMessage{cc(3, 40, 127)}, true},
+		{Control{Kind: BUTTON, Number: 40}, []*midi.MidiMessage{cc(3, 40, 0)}, false}, // letting go
+		{Control{Kind: BUTTON, Number: 40, Channel: 4}, []*midi.MidiMessage{cc(3, 40, 127)}, true},
+		{Control{Kind: BUTTON, Number: 40, Channel: 3}, []*midi.MidiMessage{cc(3, 40, 127)}, false},
+		{Control{Kind: BUTTON, Number: 40}, []*midi.MidiMessage{noteOn(0, 40)}, false},
+		{Control{Kind: BUTTON, Number: 40}, []*midi.MidiMessage{cc(3, 40, 64), cc(3, 40, 127)}, true},
+		{Control{Kind: BUTTON, Number: 40, Channel: 4}, []*midi.MidiMessage{cc(3, 40, 0), cc(3, 40, 127)}, true},
+	}
+	for _, tt := range tests {
+		midiState := &midi.MidiState{}
+		midiState.UpdateStateFromSlice(tt.messages)
+		if got := tt.control.Pressed(midiState); got != tt.want {
+			t.Errorf("%+v after %v: pressed = %v, want %v", tt.control, tt.messages, got, tt.want)
+		}
+	}
+
+	// a button which sends 127 then 64 for one press only presses once
+	button := Control{Kind: BUTTON, Number: 40}
+	channelButton := Control{Kind: BUTTON, Number: 40, Channel: 4}
+	midiState := &midi.MidiState{}
+	midiState.UpdateStateFromSlice([]*midi.MidiMessage{cc(3, 40, 127)})
+	midiState.UpdateStateFromSlice([]*midi.MidiMessage{cc(3, 40, 64)})
+	if button.Pressed(midiState) || channelButton.Pressed(midiState) {
+		t.Errorf("a button still held down was pressed again")
+	}
+	midiState.UpdateStateFromSlice([]*midi.MidiMessage{cc(3, 40, 0)})
+	midiState.UpdateStateFromSlice([]*midi.MidiMessage{cc(3, 40, 127)})
+	if !button.Pressed(midiState) || !channelButton.Pressed(midiState) {
+		t.Errorf("a button let go and pressed again wasn't pressed")
+	}
+}
+
+func TestReadMapping(t *testing.T) {
+	defer saveControls()()
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+
+	speed := *SPEED_KNOB
+	BLINK_RED_PAD.Off = true
+	err := ReadMapping(write("good.json", `{
+		"gain": {"channel": 2, "number": 20, "curve": "exp", "default": 100},
+		"blink-red": {"number": 60},
+		"desat": {"off": true}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GAIN_KNOB.Channel != 2 || GAIN_KNOB.Number != 20 || GAIN_KNOB.Curve != CURVE_EXP || GAIN_KNOB.Kind != KNOB || !GAIN_KNOB.Pickup {
+		t.Errorf("gain = %+v, want the mapping's fields on top of the old ones", *GAIN_KNOB)
+	}
+	if DEFAULT_KNOB_VALUES[20] != 100 {
+		t.Errorf("default knob values = %v, want controller 20 at 100", DEFAULT_KNOB_VALUES)
+	}
+	if BLINK_RED_PAD.Off || BLINK_RED_PAD.Number != 60 {
+		t.Errorf("blink-red = %+v, want it turned on at note 60", *BLINK_RED_PAD)
+	}
+	if !DESAT_KNOB.Off {
+		t.Error("desat is still on")
+	}
+	if *SPEED_KNOB != speed {
+		t.Errorf("speed = %+v, want it left alone", *SPEED_KNOB)
+	}
+
+	for name, text := range map[string]string{
+		"unknown.json": `{"volume": {"number": 3}}`,
+		"broken.json":  `{"gain": `,
+		"channel.json": `{"gain": {"channel": 17}}`,
+		"kind.json":    `{"gain": {"kind": "slider"}}`,
+		"curve.json":   `{"gain": {"curve": "wobbly"}}`,
+		"pickup.json":  `{"flash": {"pickup": true}}`,
+		"dead.json":    `{"gain": {"deadzone": 0.5}}`,
+		"late.json":    `{"gain": {"number": 30}, "speed": {"number": 300}}`,
+		"mixed.json":   `{"gain": {"number": 30}, "speed": {"channel": 2}, "volume": {"number": 3}}`,
+	} {
+		if err := ReadMapping(write(name, text)); err == nil {
+			t.Errorf("no error reading %s", text)
+		}
+	}
+	if GAIN_KNOB.Channel != 2 || GAIN_KNOB.Number != 20 || *SPEED_KNOB != speed {
+		t.Errorf("gain = %+v, speed = %+v, want bad mappings to leave every control alone", *GAIN_KNOB, *SPEED_KNOB)
+	}
+	if err := ReadMapping(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("no error reading a missing file")
+	}
+}
@@ -0,0 +1,293 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// A knob, fader, pad, or button on a midi controller and the message it sends.
+type PhysicalControl struct {
+	Kind   string // KNOB, PAD, or BUTTON
+	Number byte   // controller or note number
+}
+
+// Matches any device family from the manufacturer.
+const ANY_FAMILY = -1
+
+// A built-in description of a midi controller: what its controls are called
+// and which of them move pixelslinger's logical controls.
+type Profile struct {
+	Name         string
+	Description  string
+	Manufacturer []byte                     // manufacturer id from the SysEx identity reply
+	Family       int                        // device family from the identity reply, or ANY_FAMILY
+	Controls     map[string]PhysicalControl // physical controls by name
+	Mapping      map[string]string          // logical control name --> physical control name.  Logical controls left out are turned off.
+	PatternPads  []string                   // physical pads which pick the midi-switcher's patterns, in order
+	Lights       *Lights                    // how to light the pads, or nil if we can't
+}
+
+// Name of the profile to use until we learn which controller is plugged in.
+const DEFAULT_PROFILE = "lpd8"
+
+// The profile in use.
+var PROFILE *Profile
+
+// Pads which pick the midi-switcher's patterns, in order.  These come from the profile.
+var PATTERN_PADS []*Control
+
+var PROFILES = []*Profile{
+	makeLPD8Profile(),
+	makeNanoKontrol2Profile(),
+	makeAPCMiniProfile(),
+	makeLaunchpadProfile(),
+}
+
+func init() {
+	if err := ApplyProfile(LookupProfile(DEFAULT_PROFILE)); err != nil {
+		panic(err)
+	}
+}
+
+// Add count numbered controls to a profile's Controls, e.g. "pad1" through "pad8".
+// number(ii) gives the midi number of the control with index ii, counting from 0.
+func addControls(controls map[string]PhysicalControl, prefix string, count int, kind string, number func(ii int) int) {
+	for ii := 0; ii < count; ii++ {
+		controls[fmt.Sprintf("%s%d", prefix, ii+1)] = PhysicalControl{kind, byte(number(ii))}
+	}
+}
+
+// Return the names of count numbered controls, e.g. "pad1" through "pad64".
+func numberedNames(prefix string, count int) []string {
+	names := make([]string, count)
+	for ii := range names {
+		names[ii] = fmt.Sprintf("%s%d", prefix, ii+1)
+	}
+	return names
+}
+
+// AKAI LPD8: eight knobs and eight pads in its factory program.
+func makeLPD8Profile() *Profile {
+	controls := make(map[string]PhysicalControl)
+	addControls(controls, "knob", 8, KNOB, func(ii int) int { return int(midi.LPD8_KNOB1) + ii })
+	addControls(controls, "pad", 8, PAD, func(ii int) int { return int(midi.LPD8_PAD1) + ii })
+	return &Profile{
+		Name:         "lpd8",
+		Description:  "AKAI LPD8",
+		Manufacturer: []byte{0x47},
+		Family:       0x75,
+		Controls:     controls,
+		Mapping: map[string]string{
+			"gain": "knob1", "eyelid": "knob2", "speed": "knob3", "switch": "knob4",
+			"morph": "knob5", "hue": "knob6", "desat": "knob7", "extract": "knob8",
+			"flash": "pad1", "twinkle": "pad2", "flush": "pad3", "slowmo": "pad4",
+			"blink-circle": "pad5", "blink-arch": "pad6", "blink-back": "pad7", "fade-to-black": "pad8",
+		},
+	}
+}
+
+// Korg nanoKONTROL2 in CC mode: eight channel strips with a slider, a knob, and
+// S, M, and R buttons, plus the transport buttons.  Its buttons send controllers.
+// With its LED mode set to external (in the Korg Kontrol Editor) we can light them.
+func makeNanoKontrol2Profile() *Profile {
+	controls := map[string]PhysicalControl{
+		"track-prev":  {BUTTON, 58},
+		"track-next":  {BUTTON, 59},
+		"cycle":       {BUTTON, 46},
+		"marker-set":  {BUTTON, 60},
+		"marker-prev": {BUTTON, 61},
+		"marker-next": {BUTTON, 62},
+		"rewind":      {BUTTON, 43},
+		"forward":     {BUTTON, 44},
+		"stop":        {BUTTON, 42},
+		"play":        {BUTTON, 41},
+		"record":      {BUTTON, 45},
+	}
+	addControls(controls, "slider", 8, KNOB, func(ii int) int { return 0 + ii })
+	addControls(controls, "knob", 8, KNOB, func(ii int) int { return 16 + ii })
+	addControls(controls, "s", 8, BUTTON, func(ii int) int { return 32 + ii })
+	addControls(controls, "m", 8, BUTTON, func(ii int) int { return 48 + ii })
+	addControls(controls, "r", 8, BUTTON, func(ii int) int { return 64 + ii })
+	return &Profile{
+		Name:         "nanokontrol2",
+		Description:  "Korg nanoKONTROL2",
+		Manufacturer: []byte{0x42},
+		Family:       0x01<<7 | 0x13,
+		Controls:     controls,
+		Mapping: map[string]string{
+			"gain": "slider1", "eyelid": "slider2",
+			"speed": "knob1", "switch": "knob2", "morph": "knob3", "hue": "knob4", "desat": "knob5", "extract": "knob6",
+			"flash": "s1", "twinkle": "s2", "flush": "s3", "slowmo": "s4",
+			"blink-circle": "s5", "blink-arch": "s6", "blink-back": "s7", "fade-to-black": "s8",
+			"blink-red": "m1", "blink-green": "m2", "blink-blue": "m3", "ripple": "m4",
+		},
+		Lights: &Lights{Off: 0, Down: 127, Pattern: 127, Beat: 127},
+	}
+}
+
+// AKAI APC mini: an 8x8 grid of pads numbered from the bottom left, a column of
+// scene buttons on the right, a row of track buttons along the bottom, and nine faders.
+// The grid picks patterns.
+func makeAPCMiniProfile() *Profile {
+	controls := map[string]PhysicalControl{
+		"shift": {PAD, 98},
+	}
+	addControls(controls, "pad", 64, PAD, func(ii int) int { return ii })
+	addControls(controls, "track", 8, PAD, func(ii int) int { return 64 + ii })
+	addControls(controls, "scene", 8, PAD, func(ii int) int { return 82 + ii })
+	addControls(controls, "fader", 9, KNOB, func(ii int) int { return 48 + ii })
+	return &Profile{
+		Name:         "apc-mini",
+		Description:  "AKAI APC mini",
+		Manufacturer: []byte{0x47},
+		Family:       0x28,
+		Controls:     controls,
+		Mapping: map[string]string{
+			"gain": "fader9", "eyelid": "fader1", "speed": "fader2", "switch": "fader3",
+			"morph": "fader4", "hue": "fader5", "desat": "fader6", "extract": "fader7",
+			"flash": "scene1", "twinkle": "scene2", "flush": "scene3", "slowmo": "scene4",
+			"blink-circle": "scene5", "blink-arch": "scene6", "blink-back": "scene7", "fade-to-black": "scene8",
+			"tap-tempo": "track1", "blink-red": "track2", "blink-green": "track3", "blink-blue": "track4",
+			"ripple": "track5",
+		},
+		PatternPads: numberedNames("pad", 64),
+		Lights:      &Lights{Off: 0, Down: 5, Pattern: 1, Beat: 3}, // yellow, green, red
+	}
+}
+
+// Novation Launchpad (original, S, and Mini mk1/mk2) in its X-Y layout: an 8x8 grid
+// numbered from the top left, a column of scene buttons on the right, and a row of
+// buttons along the top which send controllers.  It has no knobs.
+// The grid picks patterns.
+func makeLaunchpadProfile() *Profile {
+	controls := make(map[string]PhysicalControl)
+	addControls(controls, "pad", 64, PAD, func(ii int) int { return ii/8*16 + ii%8 })
+	addControls(controls, "scene", 8, PAD, func(ii int) int { return ii*16 + 8 })
+	addControls(controls, "top", 8, BUTTON, func(ii int) int { return 104 + ii })
+	return &Profile{
+		Name:         "launchpad",
+		Description:  "Novation Launchpad",
+		Manufacturer: []byte{0x00, 0x20, 0x29},
+		Family:       ANY_FAMILY,
+		Controls:     controls,
+		Mapping: map[string]string{
+			"flash": "scene1", "twinkle": "scene2", "flush": "scene3", "slowmo": "scene4",
+			"blink-circle": "scene5", "blink-arch": "scene6", "blink-back": "scene7", "fade-to-black": "scene8",
+			"blink-red": "top1", "blink-green": "top2", "blink-blue": "top3", "ripple": "top4",
+		},
+		PatternPads: numberedNames("pad", 64),
+		// velocity is 16 * green + red + 12, with green and red from 0 to 3
+		Lights: &Lights{Off: 0x0c, Down: 0x3f, Pattern: 0x3c, Beat: 0x0f}, // amber, green, red
+	}
+}
+
+// Return the profile with the given name, or nil.
+func LookupProfile(name string) *Profile {
+	for _, profile := range PROFILES {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+// Return the names of the built-in profiles.
+func ProfileNames() []string {
+	names := make([]string, len(PROFILES))
+	for ii, profile := range PROFILES {
+		names[ii] = profile.Name
+	}
+	return names
+}
+
+// Return the profile for the device in a SysEx identity reply, or nil if we don't know it.
+// Profiles for a particular family win over ones which match the whole manufacturer.
+func MatchProfile(id *midi.Identity) *Profile {
+	var match *Profile
+	for _, profile := range PROFILES {
+		if !bytes.Equal(profile.Manufacturer, id.Manufacturer) {
+			continue
+		}
+		if profile.Family == id.Family {
+			return profile
+		}
+		if profile.Family == ANY_FAMILY && match == nil {
+			match = profile
+		}
+	}
+	return match
+}
+
+// Move the logical controls onto the profile's physical controls, turn off the
+// ones it leaves out, and set up its pattern pads.
+// Each control keeps its range, curve, and default.
+// The patterns read the controls while they render, so only call this between frames.
+func ApplyProfile(profile *Profile) error {
+	if profile == nil {
+		return fmt.Errorf("unknown controller profile")
+	}
+	for logical, name := range profile.Mapping {
+		control := LookupControl(logical)
+		if control == nil {
+			return fmt.Errorf("profile %q: unknown control %q", profile.Name, logical)
+		}
+		physical, ok := profile.Controls[name]
+		if !ok {
+			return fmt.Errorf("profile %q: unknown physical control %q", profile.Name, name)
+		}
+		if (control.Kind == KNOB) != (physical.Kind == KNOB) {
+			return fmt.Errorf("profile %q: %s %q can't move the %q control", profile.Name, physical.Kind, name, logical)
+		}
+	}
+	patternPads := make([]*Control, len(profile.PatternPads))
+	for ii, name := range profile.PatternPads {
+		physical, ok := profile.Controls[name]
+		if !ok {
+			return fmt.Errorf("profile %q: unknown physical control %q", profile.Name, name)
+		}
+		patternPads[ii] = &Control{Name: name, Kind: physical.Kind, Number: physical.Number}
+	}
+
+	for _, control := range CONTROLS {
+		name, ok := profile.Mapping[control.Name]
+		if !ok {
+			control.Off = true
+			continue
+		}
+		physical := profile.Controls[name]
+		control.Kind = physical.Kind
+		control.Number = physical.Number
+		control.Channel = 0
+		control.Off = false
+	}
+	PATTERN_PADS = patternPads
+	PROFILE = profile
+	updateDefaultKnobValues()
+	return nil
+}
+
+// Print the profile's physical controls and what they're mapped to.
+func PrintProfile(profile *Profile) {
+	logicalNames := make(map[string]string)
+	for logical, physical := range profile.Mapping {
+		logicalNames[physical] = logical
+	}
+	for ii, name := range profile.PatternPads {
+		if _, ok := logicalNames[name]; !ok {
+			logicalNames[name] = fmt.Sprintf("pattern %d", ii+1)
+		}
+	}
+	names := make([]string, 0, len(profile.Controls))
+	for name := range profile.Controls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("%s (%s)\n", profile.Name, profile.Description)
+	for _, name := range names {
+		physical := profile.Controls[name]
+		fmt.Printf("    %-12s %-6s %3d  %s\n", name, physical.Kind, physical.Number, logicalNames[name])
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestApplyProfile(t *testing.T) {
+	defer saveControls()()
+
+	if err := ApplyProfile(LookupProfile("apc-mini")); err != nil {
+		t.Fatal(err)
+	}
+	if PROFILE.Name != "apc-mini" || len(PATTERN_PADS) != 64 || PATTERN_PADS[9].Number != 9 {
+		t.Errorf("profile %v with %d pattern pads, want apc-mini's 64", PROFILE.Name, len(PATTERN_PADS))
+	}
+	// controls move, and keep their range, curve, and default
+	if GAIN_KNOB.Number != 56 || GAIN_KNOB.Kind != KNOB || GAIN_KNOB.Default != 127 || !GAIN_KNOB.Pickup {
+		t.Errorf("gain = %+v, want fader9 with its old settings", *GAIN_KNOB)
+	}
+	if TWINKLE_PAD.Number != 83 || TWINKLE_PAD.Kind != PAD {
+		t.Errorf("twinkle = %+v, want scene2", *TWINKLE_PAD)
+	}
+	if TAP_TEMPO_PAD.Off || TAP_TEMPO_PAD.Number != 64 {
+		t.Errorf("tap-tempo = %+v, want it turned on at track1", *TAP_TEMPO_PAD)
+	}
+	if DEFAULT_KNOB_VALUES[56] != 127 {
+		t.Errorf("default knob values = %v, want fader9 at 127", DEFAULT_KNOB_VALUES)
+	}
+
+	// the launchpad has no knobs, so they're all turned off, and its top row are buttons
+	if err := ApplyProfile(LookupProfile("launchpad")); err != nil {
+		t.Fatal(err)
+	}
+	if !GAIN_KNOB.Off || !SPEED_KNOB.Off {
+		t.Error("knobs are still on with the launchpad")
+	}
+	if BLINK_RED_PAD.Kind != BUTTON || BLINK_RED_PAD.Number != 104 {
+		t.Errorf("blink-red = %+v, want the top1 button", *BLINK_RED_PAD)
+	}
+
+	for _, profile := range []*Profile{
+		nil,
+		{Name: "bad", Mapping: map[string]string{"volume": "knob1"}, Controls: map[string]PhysicalControl{"knob1": {KNOB, 1}}},
+		{Name: "bad", Mapping: map[string]string{"gain": "knob9"}, Controls: map[string]PhysicalControl{"knob1": {KNOB, 1}}},
+		{Name: "bad", Mapping: map[string]string{"gain": "pad1"}, Controls: map[string]PhysicalControl{"pad1": {PAD, 36}}},
+		{Name: "bad", PatternPads: []string{"pad9"}, Controls: map[string]PhysicalControl{"pad1": {PAD, 36}}},
+	} {
+		if err := ApplyProfile(profile); err == nil {
+			t.Errorf("no error applying %+v", profile)
+		}
+	}
+	if PROFILE.Name != "launchpad" || BLINK_RED_PAD.Number != 104 {
+		t.Error("a bad profile changed the controls")
+	}
+}
+
+func TestMatchProfile(t *testing.T) {
+	tests := []struct {
+		manufacturer []byte
+		family       int
+		want         string
+	}{
+		{[]byte{0x47}, 0x75, "lpd8"},
+		{[]byte{0x47}, 0x28, "apc-mini"},
+		{[]byte{0x42}, 0x01<<7 | 0x13, "nanokontrol2"},
+		{[]byte{0x00, 0x20, 0x29}, 0x36, "launchpad"}, // any family
+		{[]byte{0x47}, 0x01, ""},
+		{[]byte{0x41}, 0x75, ""},
+	}
+	for _, tt := range tests {
+		profile := MatchProfile(&midi.Identity{Manufacturer: tt.manufacturer, Family: tt.family})
+		name := ""
+		if profile != nil {
+			name = profile.Name
+		}
+		if name != tt.want {
+			t.Errorf("MatchProfile(%x, %#x) = %q, want %q", tt.manufacturer, tt.family, name, tt.want)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	// every built-in profile applies cleanly
+	defer saveControls()()
+	for _, profile := range PROFILES {
+		if err := ApplyProfile(profile); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Addresses which let a remote control (like TouchOSC) move the logical controls:
+//
+//	/knob/<name> value          moves a knob, from 0 to 1
+//	/pad/<name> value           holds a pad down with a velocity from 0 to 1, or lets go at 0
+//	/pattern/select n           picks the midi-switcher's pattern n, counting from 0
+//	/pattern/select/<n> value   the same, from a row of buttons, when value isn't 0
+//
+// These become the midi messages that would have moved the control, so patterns
+// can't tell a remote control from the midi controller.
+const (
+	REMOTE_KNOB    = "/knob/"
+	REMOTE_PAD     = "/pad/"
+	REMOTE_PATTERN = "/pattern/select"
+)
+
+// Send all the remote control's values again this often, so new clients catch up.
+const REMOTE_REFRESH = 5 * time.Second
+
+// Return the midi message which does what a remote control message asks,
+// or nil if it doesn't ask for anything (like letting go of a pattern button).
+func RemoteMessage(address string, value float64) (*midi.MidiMessage, error) {
+	now := time.Now()
+	switch {
+	case address == REMOTE_PATTERN:
+		if value < 0 || value > 127 {
+			return nil, fmt.Errorf("%s: pattern %v is out of range", address, value)
+		}
+		return &midi.MidiMessage{Kind: midi.PROGRAM_CHANGE, Key: byte(value), Time: now}, nil
+
+	case strings.HasPrefix(address, REMOTE_PATTERN+"/"):
+		n, err := strconv.Atoi(strings.TrimPrefix(address, REMOTE_PATTERN+"/"))
+		if err != nil || n < 0 || n > 127 {
+			return nil, fmt.Errorf("%s: bad pattern number", address)
+		}
+		if value == 0 {
+			return nil, nil
+		}
+		return &midi.MidiMessage{Kind: midi.PROGRAM_CHANGE, Key: byte(n), Time: now}, nil
+
+	case strings.HasPrefix(address, REMOTE_KNOB), strings.HasPrefix(address, REMOTE_PAD):
+		name := address[strings.LastIndex(address, "/")+1:]
+		control := LookupControl(name)
+		if control == nil || (control.Kind == KNOB) != strings.HasPrefix(address, REMOTE_KNOB) {
+			return nil, fmt.Errorf("%s: no such control", address)
+		}
+		if control.Off {
+			return nil, fmt.Errorf("%s: the %s control is off in the %s profile.  give it a number in the mapping file to move it remotely", address, name, PROFILE.Name)
+		}
+		raw := byte(math.Round(math.Max(0, math.Min(1, value)) * 127))
+		m := &midi.MidiMessage{Kind: midi.CONTROLLER, Key: control.Number, Value: raw, Time: now}
+		if control.Kind == PAD {
+			m.Kind = midi.NOTE_ON
+			if value > 0 && raw == 0 {
+				raw = 1 // velocity 0 would let go of the pad
+			}
+			m.Value = raw
+		}
+		if control.Channel >= 1 && control.Channel <= 16 {
+			m.Channel = byte(control.Channel - 1)
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s: unknown address", address)
+}
+
+// Keeps a remote control's knobs, pads, and pattern buttons in step with the midi
+// controller and the patterns.
+type RemoteFeedback struct {
+	sent        map[string]float64 // what each address was last set to
+	lastRefresh time.Time
+}
+
+func NewRemoteFeedback() *RemoteFeedback {
+	return &RemoteFeedback{sent: make(map[string]float64)}
+}
+
+// Return the addresses whose values have changed, and their new values.
+// selectedPattern is the index of the midi-switcher's pattern, or -1 for none.
+// Every REMOTE_REFRESH all of them are returned.
+func (rf *RemoteFeedback) Update(midiState *midi.MidiState, selectedPattern int, now time.Time) map[string]float64 {
+	if now.Sub(rf.lastRefresh) > REMOTE_REFRESH {
+		rf.sent = make(map[string]float64)
+		rf.lastRefresh = now
+	}
+	want := make(map[string]float64)
+	for _, control := range CONTROLS {
+		prefix := REMOTE_KNOB
+		if control.IsPad() {
+			prefix = REMOTE_PAD
+		}
+		want[prefix+control.Name] = float64(control.Raw(midiState)) / 127
+	}
+	if selectedPattern >= 0 {
+		want[REMOTE_PATTERN] = float64(selectedPattern)
+	}
+
+	changed := make(map[string]float64)
+	for address, value := range want {
+		if sent, ok := rf.sent[address]; ok && sent == value {
+			continue
+		}
+		rf.sent[address] = value
+		changed[address] = value
+	}
+	return changed
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestRemoteMessage(t *testing.T) {
+	defer saveControls()()
+	if err := ApplyProfile(LookupProfile("lpd8")); err != nil {
+		t.Fatal(err)
+	}
+	SPEED_KNOB.Channel = 3
+
+	tests := []struct {
+		address string
+		value   float64
+		want    *midi.MidiMessage // nil for no message
+		wantErr bool
+	}{
+		{"/pattern/select", 3, &midi.MidiMessage{Kind: midi.PROGRAM_CHANGE, Key: 3}, false},
+		{"/pattern/select", 200, nil, true},
+		{"/pattern/select/5", 1, &midi.MidiMessage{Kind: midi.PROGRAM_CHANGE, Key: 5}, false},
+		{"/pattern/select/5", 0, nil, false}, // letting go of the button
+		{"/pattern/select/five", 1, nil, true},
+		{"/knob/gain", 0.5, &midi.MidiMessage{Kind: midi.CONTROLLER, Key: midi.LPD8_KNOB1, Value: 64}, false},
+		{"/knob/gain", 2, &midi.MidiMessage{Kind: midi.CONTROLLER, Key: midi.LPD8_KNOB1, Value: 127}, false},
+		{"/knob/speed", 0, &midi.MidiMessage{Kind: midi.CONTROLLER, Channel: 2, Key: midi.LPD8_KNOB3}, false},
+		{"/pad/flash", 1, &midi.MidiMessage{Kind: midi.NOTE_ON, Key: midi.LPD8_PAD1, Value: 127}, false},
+		{"/pad/flash", 0.001, &midi.MidiMessage{Kind: midi.NOTE_ON, Key: midi.LPD8_PAD1, Value: 1}, false},
+		{"/pad/flash", 0, &midi.MidiMessage{Kind: midi.NOTE_ON, Key: midi.LPD8_PAD1}, false},
+		{"/knob/flash", 1, nil, true},
+		{"/pad/gain", 1, nil, true},
+		{"/pad/volume", 1, nil, true},
+		{"/pad/tap-tempo", 1, nil, true}, // off on the lpd8
+		{"/fader/gain", 1, nil, true},
+	}
+	for _, tt := range tests {
+		m, err := RemoteMessage(tt.address, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("RemoteMessage(%s, %v) error = %v, want error %v", tt.address, tt.value, err, tt.wantErr)
+			continue
+		}
+		if (m == nil) != (tt.want == nil) {
+			t.Errorf("RemoteMessage(%s, %v) = %v, want %v", tt.address, tt.value, m, tt.want)
+			continue
+		}
+		if m != nil && (m.Kind != tt.want.Kind || m.Channel != tt.want.Channel || m.Key != tt.want.Key || m.Value != tt.want.Value) {
+			t.Errorf("RemoteMessage(%s, %v) = %v, want %v", tt.address, tt.value, m, tt.want)
+		}
+	}
+}
+
+func TestRemoteFeedback(t *testing.T) {
+	defer saveControls()()
+	if err := ApplyProfile(LookupProfile("lpd8")); err != nil {
+		t.Fatal(err)
+	}
+	rf := NewRemoteFeedback()
+	midiState := &midi.MidiState{}
+	now := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+
+	// the first time, every address is sent
+	changed := rf.Update(midiState, 1, now)
+	if len(changed) != len(CONTROLS)+1 || changed[REMOTE_PATTERN] != 1 || changed["/pad/flash"] != 0 {
+		t.Errorf("got %v, want every control and the pattern", changed)
+	}
+	if changed := rf.Update(midiState, 1, now); len(changed) != 0 {
+		t.Errorf("got %v when nothing changed", changed)
+	}
+
+	// only what changed after that
+	midiState.ControllerValues[GAIN_KNOB.Number] = 127
+	changed = rf.Update(midiState, 2, now)
+	if len(changed) != 2 || changed["/knob/gain"] != 1 || changed[REMOTE_PATTERN] != 2 {
+		t.Errorf("got %v, want the gain knob and the pattern", changed)
+	}
+	// no midi-switcher, no pattern
+	if changed := rf.Update(midiState, -1, now); len(changed) != 0 {
+		t.Errorf("got %v without a midi-switcher", changed)
+	}
+
+	if changed := rf.Update(midiState, 2, now.Add(REMOTE_REFRESH+time.Second)); len(changed) != len(CONTROLS)+1 {
+		t.Errorf("got %d addresses after the refresh time, want %d", len(changed), len(CONTROLS)+1)
+	}
+}
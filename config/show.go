@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Settings for one show, read from a JSON file given with --config.
+// Every section is optional.  For example:
+//
+//	{
+//	    "params":   { "fire.speed": 0.7, "basic-midi.sustain": false },
+//	    "bindings": { "fire.side-scale": 8 },
+//	    "switcher": { "patterns": ["fire", "sunset", "white"], "transition": "wipe-z" },
+//	    "effects":  ["gain", "flash", "ripple", "desat"],
+//	    "ripple":   { "origins": [[0, 0, 1], [0, 0, -1]] },
+//	    "power":    { "max_amps": 30, "supplies": [ { "group": "base", "amps": 10, "milliamps_per_channel": 20 } ] },
+//	    "playlists": {
+//	        "live":    [ { "pattern": "midi-switcher" } ],
+//	        "attract": [ { "pattern": "fire", "duration": 120, "params": { "fire.speed": 1.2 } },
+//	                     { "pattern": "sunset", "duration": 300, "transition": "black", "transition_time": 3 } ]
+//	    },
+//	    "playlist": { "main": "live", "attract": "attract", "idle_minutes": 10 },
+//	    "location": { "latitude": 37.77, "longitude": -122.42 },
+//	    "schedule": { "rules": [
+//	        { "start": "sunset-0:30", "end": "23:00", "playlist": "live" },
+//	        { "start": "23:00", "end": "2:00", "days": "fri,sat", "playlist": "attract", "brightness": 0.5 },
+//	        { "start": "2:00", "end": "sunset-0:30", "off": true }
+//	    ] },
+//	    "groups": { "base": ["0-159"] },
+//	    "compose": { "layers": [
+//	        { "pattern": "fire", "mask": { "group": "base" } },
+//	        { "pattern": "sunset", "mask": { "axis": "z", "min": 0.3, "max": 1, "softness": 0.1 } },
+//	        { "pattern": "basic-midi", "blend": "add" }
+//	    ] }
+//	}
+type ShowConfig struct {
+	Params   map[string]json.RawMessage `json:"params"`   // pattern parameter name --> value
+	Bindings map[string]byte            `json:"bindings"` // pattern parameter name --> midi controller number
+	Switcher SwitcherConfig             `json:"switcher"`
+	Effects  []string                   `json:"effects"` // effects to apply after the pattern, in order; opc.DEFAULT_EFFECTS if missing
+
+	Playlists map[string][]PlaylistEntry `json:"playlists"` // playlist name --> entries
+	Playlist  PlaylistConfig             `json:"playlist"`
+
+	Location LocationConfig `json:"location"`
+	Schedule ScheduleConfig `json:"schedule"`
+
+	Groups  map[string][]string `json:"groups"` // group name --> pixel index ranges like "0-159" or "200"
+	Compose ComposeConfig       `json:"compose"`
+
+	Ripple RippleConfig `json:"ripple"`
+	Power  PowerConfig  `json:"power"`
+}
+
+// Settings for the midi-switcher pattern.
+// The transition's duration is the midi-switcher.transition-time parameter
+// so that it can be bound to a knob.
+type SwitcherConfig struct {
+	Patterns   []string `json:"patterns"`   // the patterns that SWITCH_KNOB chooses between
+	Transition string   `json:"transition"` // one of the opc.TRANSITION_* kinds
+}
+
+// The show config in use.  Empty until it's replaced by the result of ReadShowConfig.
+var SHOW = &ShowConfig{}
+
+// One step of a playlist.
+type PlaylistEntry struct {
+	Pattern        string                     `json:"pattern"`
+	Duration       float64                    `json:"duration"`        // seconds; 0 plays forever
+	Params         map[string]json.RawMessage `json:"params"`          // parameters to set when this entry starts
+	Transition     string                     `json:"transition"`      // how to blend into this entry; one of the opc.TRANSITION_* kinds
+	TransitionTime float64                    `json:"transition_time"` // seconds
+}
+
+// Settings for the playlist pattern.
+// When no midi has arrived for IdleMinutes, it switches to the Attract playlist,
+// and it goes back to the Main playlist as soon as a pad or knob moves.
+type PlaylistConfig struct {
+	Main        string  `json:"main"`         // name of the playlist to play normally
+	Attract     string  `json:"attract"`      // name of the playlist to play when idle, or "" for none
+	IdleMinutes float64 `json:"idle_minutes"` // how long without midi before switching to Attract
+}
+
+// Where the installation is, for working out sunrise and sunset.
+// Longitude is positive to the east.
+type LocationConfig struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Settings for the schedule pattern.  The first rule which matches the current
+// time wins; if none match, the default playlist plays at full brightness.
+type ScheduleConfig struct {
+	Rules []ScheduleRule `json:"rules"`
+}
+
+// One window of time in a schedule.
+// Start and End are clock times like "18:30", or "sunrise" or "sunset" with an
+// optional offset like "sunset-0:30" or "sunrise+1:15".  A window whose End is
+// earlier than its Start runs past midnight.
+// Days is a cron-style day-of-week field: "*", "1-5", "sat,sun", "0,6" (0 is Sunday).
+// It refers to the day the window starts.
+type ScheduleRule struct {
+	Days       string  `json:"days"`
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	Playlist   string  `json:"playlist"`   // a playlist name, or...
+	Pattern    string  `json:"pattern"`    // ...a pattern name
+	Brightness float64 `json:"brightness"` // cap from 0 to 1; 0 or missing means no cap
+	Off        bool    `json:"off"`        // show black and stop writing to the LEDs
+}
+
+// Settings for the compose pattern.
+type ComposeConfig struct {
+	Layers []LayerConfig `json:"layers"` // bottom layer first
+}
+
+// One layer of the compose pattern.  Its opacity is the compose.layerN.opacity
+// parameter, where N counts from 1 at the bottom.
+type LayerConfig struct {
+	Pattern string      `json:"pattern"`
+	Blend   string      `json:"blend"` // normal, add, multiply, screen, max, or lighten; normal if missing
+	Mask    *MaskConfig `json:"mask"`  // show the layer everywhere if missing
+}
+
+// Where a layer shows.  Either a group of pixels, or a slice of the layout along
+// one axis where Min and Max go from 0 to 1 across the layout's bounding box.
+type MaskConfig struct {
+	Group    string  `json:"group"`
+	Axis     string  `json:"axis"` // x, y, or z
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Softness float64 `json:"softness"` // width of the fade at each end of the slice
+	Invert   bool    `json:"invert"`
+}
+
+// Settings for the ripple effect.
+type RippleConfig struct {
+	Origins [][3]float64 `json:"origins"` // [x, y, z] points in the layout where ripples start, taking turns; random pixels if empty
+}
+
+// Settings for the power limiter, which dims frames that would draw more current than
+// the power supplies can give.  Pixels in no supply's group still count towards MaxAmps.
+type PowerConfig struct {
+	Supplies            []SupplyConfig `json:"supplies"`
+	MaxAmps             float64        `json:"max_amps"`              // cap on the whole layout's current; 0 for none
+	MilliampsPerChannel float64        `json:"milliamps_per_channel"` // for pixels and supplies which don't say; 20 if missing
+}
+
+// One power supply and the pixels it feeds.
+type SupplyConfig struct {
+	Group               string  `json:"group"`                 // name of a group from the groups section; "" for every pixel
+	Amps                float64 `json:"amps"`                  // the most current the supply should be asked for
+	MilliampsPerChannel float64 `json:"milliamps_per_channel"` // current one color channel of one pixel draws at full brightness
+}
+
+// Read a show config from a JSON file.
+func ReadShowConfig(fn string) (*ShowConfig, error) {
+	file, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	show := &ShowConfig{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(show); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return show, nil
+}
@@ -0,0 +1,177 @@
+package midi
+
+import (
+	"time"
+)
+
+const (
+	CLOCKS_PER_BEAT = 24 // MIDI clock runs at 24 pulses per quarter note
+	BEATS_PER_BAR   = 4
+)
+
+const (
+	CLOCK_TIMEOUT   = 2 * time.Second // forget about the clock if no ticks arrive for this long
+	CLOCK_SMOOTHING = 0.05            // weight of each new tick interval in the tempo average
+	TAP_SMOOTHING   = 0.5             // weight of each new tap interval in the tempo average
+	TAP_MIN         = 200 * time.Millisecond
+	TAP_MAX         = 2 * time.Second // taps further apart than this start a new tempo
+)
+
+// Keeps track of the tempo and where we are in the beat, from MIDI clock messages
+// or, when there's no clock, from tapping a pad.
+type Clock struct {
+	Running bool    // true after START or CONTINUE, false after STOP
+	Ticks   int     // clock ticks received since the last START, not counting any while stopped
+	BPM     float64 // smoothed tempo in beats per minute, or 0 if unknown
+	Tapped  bool    // is the tempo coming from the tap pad?
+
+	stopped      bool // has STOP arrived without a START or CONTINUE since?
+	lastTick     time.Time
+	tickInterval time.Duration // smoothed time between ticks
+	lastTap      time.Time
+	tapBeats     float64 // beat position as of lastTap
+}
+
+// Is a MIDI clock arriving as of now?
+func (c *Clock) HasClock(now time.Time) bool {
+	return !c.lastTick.IsZero() && now.Sub(c.lastTick) < CLOCK_TIMEOUT
+}
+
+// Handle a CLOCK, START, CONTINUE, or STOP message.
+func (c *Clock) update(m *MidiMessage) {
+	switch m.Channel {
+	case START:
+		c.Running = true
+		c.stopped = false
+		c.Ticks = 0
+	case CONTINUE:
+		c.Running = true
+		c.stopped = false
+	case STOP:
+		c.Running = false
+		c.stopped = true
+	case SONG_POSITION:
+		// the position is in 16th notes; the next tick starts that 16th note
+		c.Ticks = (int(m.Value)<<7 | int(m.Key)) * CLOCKS_PER_BEAT / 4
+	case CLOCK:
+		if !c.lastTick.IsZero() {
+			interval := m.Time.Sub(c.lastTick)
+			switch {
+			case interval <= 0 || interval >= CLOCK_TIMEOUT:
+				// the clock went away and came back; start measuring over
+				c.tickInterval = 0
+			case c.tickInterval == 0:
+				c.tickInterval = interval
+			default:
+				c.tickInterval += time.Duration(float64(interval-c.tickInterval) * CLOCK_SMOOTHING)
+			}
+		}
+		if c.tickInterval > 0 {
+			c.BPM = 60 / (c.tickInterval.Seconds() * CLOCKS_PER_BEAT)
+			c.Tapped = false
+		}
+		c.lastTick = m.Time
+		// some controllers send clock without ever sending START, so count it unless we've been told to stop
+		if !c.stopped {
+			c.Ticks++
+		}
+	}
+}
+
+// Handle a press of the tap tempo pad.  Taps are ignored while a clock is arriving.
+func (c *Clock) tap(now time.Time) {
+	if c.HasClock(now) {
+		return
+	}
+	interval := now.Sub(c.lastTap)
+	if interval >= TAP_MIN && interval <= TAP_MAX {
+		bpm := 60 / interval.Seconds()
+		if c.Tapped && c.BPM > 0 {
+			bpm = c.BPM + (bpm-c.BPM)*TAP_SMOOTHING
+		}
+		c.BPM = bpm
+		c.Tapped = true
+	}
+	// each tap lands on a beat
+	if c.Tapped {
+		c.tapBeats = float64(int(c.Beats(now) + 0.5))
+	}
+	c.lastTap = now
+}
+
+// Return how many beats have gone by, including the fraction of the current beat.
+// Between clock ticks this moves smoothly, assuming the tempo stays the same.
+// It's 0 if there's no clock and no tapped tempo.
+func (c *Clock) Beats(now time.Time) float64 {
+	if c.HasClock(now) || c.Running {
+		if c.Ticks == 0 {
+			return 0
+		}
+		// the first tick after START is the start of beat 0
+		ticks := float64(c.Ticks - 1)
+		if !c.stopped && c.tickInterval > 0 {
+			frac := now.Sub(c.lastTick).Seconds() / c.tickInterval.Seconds()
+			if frac > 1 {
+				frac = 1
+			}
+			if frac > 0 {
+				ticks += frac
+			}
+		}
+		return ticks / CLOCKS_PER_BEAT
+	}
+	if c.Tapped && c.BPM > 0 {
+		return c.tapBeats + now.Sub(c.lastTap).Minutes()*c.BPM
+	}
+	return 0
+}
+
+// Return where we are in the current beat, from 0 up to 1.
+func (c *Clock) BeatPhase(now time.Time) float64 {
+	beats := c.Beats(now)
+	return beats - float64(int(beats))
+}
+
+// Return where we are in the current bar, from 0 up to 1.
+func (c *Clock) BarPhase(now time.Time) float64 {
+	bars := c.Beats(now) / BEATS_PER_BAR
+	return bars - float64(int(bars))
+}
+
+// MTC frame rates, indexed by the rate bits of the last quarter frame piece.
+var MTC_RATES = [4]float64{24, 25, 29.97, 30}
+
+// MIDI time code, put together from quarter frame messages.
+// A complete time code takes 8 quarter frames to arrive, so by then it's 2 frames old.
+type Timecode struct {
+	Hours   int
+	Minutes int
+	Seconds int
+	Frames  int
+	Rate    float64 // frames per second, or 0 if no time code has arrived
+
+	pieces [8]byte
+}
+
+// Handle the data byte of a quarter frame message.
+func (tc *Timecode) update(data byte) {
+	piece := data >> 4 & 0x07
+	tc.pieces[piece] = data & 0x0f
+	if piece != 7 {
+		return
+	}
+	p := tc.pieces
+	tc.Frames = int(p[0] | p[1]&0x01<<4)
+	tc.Seconds = int(p[2] | p[3]&0x03<<4)
+	tc.Minutes = int(p[4] | p[5]&0x03<<4)
+	tc.Hours = int(p[6] | p[7]&0x01<<4)
+	tc.Rate = MTC_RATES[p[7]>>1&0x03]
+}
+
+// Return the time code as a number of seconds.
+func (tc *Timecode) TotalSeconds() float64 {
+	if tc.Rate == 0 {
+		return 0
+	}
+	return float64(tc.Hours*3600+tc.Minutes*60+tc.Seconds) + float64(tc.Frames)/tc.Rate
+}
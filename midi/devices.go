@@ -0,0 +1,256 @@
+package midi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where to look for midi devices and their names.
+// ALSA makes a /dev/snd/midiCcDd file for device d of sound card c, and its OSS emulation
+// makes a /dev/midiN file for device 0 of card N.
+var (
+	MIDI_DEVICE_GLOBS = []string{"/dev/snd/midiC*D*", "/dev/midi*"}
+	PROC_ASOUND       = "/proc/asound"
+)
+
+// Shortest time between scans for devices, so a device which fails as soon as it's
+// opened doesn't keep us busy.
+const RESCAN_MIN_WAIT = 250 * time.Millisecond
+
+var (
+	alsaDevicePattern = regexp.MustCompile(`midiC(\d+)D(\d+)$`)
+	ossDevicePattern  = regexp.MustCompile(`midi(\d*)$`)
+)
+
+// A midi device file.
+type Device struct {
+	Path   string
+	Card   int    // ALSA sound card number
+	Number int    // device number on the card
+	Name   string // from /proc/asound, or "" if we don't know it
+}
+
+func (d Device) String() string {
+	if d.Name == "" {
+		return d.Path
+	}
+	return fmt.Sprintf("%s (%s)", d.Path, d.Name)
+}
+
+// Return the midi devices which are plugged in, sorted by path.
+// When ALSA and OSS files are both there for a device, only the ALSA one is returned.
+// If match isn't empty, only devices whose name contains it (ignoring case) are returned.
+func ScanDevices(match string) []Device {
+	devices := make(map[[2]int]Device) // card and number --> device
+	for _, glob := range MIDI_DEVICE_GLOBS {
+		paths, _ := filepath.Glob(glob)
+		for _, path := range paths {
+			device := Device{Path: path}
+			if groups := alsaDevicePattern.FindStringSubmatch(path); groups != nil {
+				device.Card, _ = strconv.Atoi(groups[1])
+				device.Number, _ = strconv.Atoi(groups[2])
+			} else if groups := ossDevicePattern.FindStringSubmatch(path); groups != nil {
+				device.Card, _ = strconv.Atoi(groups[1]) // "/dev/midi" is card 0
+			} else {
+				continue
+			}
+			key := [2]int{device.Card, device.Number}
+			if _, ok := devices[key]; ok {
+				continue
+			}
+			device.Name = deviceName(device.Card, device.Number)
+			devices[key] = device
+		}
+	}
+
+	result := []Device{}
+	for _, device := range devices {
+		if match != "" && !strings.Contains(strings.ToLower(device.Name), strings.ToLower(match)) {
+			continue
+		}
+		result = append(result, device)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// Return the name of a midi device from /proc/asound, or "" if it's not there.
+// The first line of cardC/midiD names the port; the card's id is a shorter name for the card.
+func deviceName(card, number int) string {
+	if bytes, err := os.ReadFile(filepath.Join(PROC_ASOUND, fmt.Sprintf("card%d", card), fmt.Sprintf("midi%d", number))); err == nil {
+		if line := strings.TrimSpace(strings.SplitN(string(bytes), "\n", 2)[0]); line != "" {
+			return line
+		}
+	}
+	if bytes, err := os.ReadFile(filepath.Join(PROC_ASOUND, fmt.Sprintf("card%d", card), "id")); err == nil {
+		return strings.TrimSpace(string(bytes))
+	}
+	return ""
+}
+
+//================================================================================
+// WATCH FOR DEVICES
+
+// Keeps every matching midi device open as they're plugged in and unplugged,
+// merges the messages from all of them into one stream, and sends messages to all of them.
+type DeviceWatcher struct {
+	Messages chan *MidiMessage // messages from every device
+	Out      chan *MidiMessage // messages to send to every device
+
+	paths  []string // device paths to use instead of scanning, or nil
+	match  string   // only use devices whose names contain this
+	rescan chan bool
+
+	mutex sync.Mutex
+	open  map[string]chan *MidiMessage // output channel of each open device by path, or nil for read-only devices
+}
+
+// Start some threads which will find midi devices and read and parse their messages
+// in the background, and write to them.
+// If paths is empty, scan for devices whose names contain match (or all of them, if match is
+// empty).  Otherwise use those paths and nothing else.
+// Missing devices are looked for again every RETRY_WAIT seconds, and straight away when one
+// is unplugged.  Each device is sent IdentityRequest when it's opened.
+func WatchDevices(paths []string, match string) *DeviceWatcher {
+	dw := &DeviceWatcher{
+		Messages: make(chan *MidiMessage, 500),
+		Out:      make(chan *MidiMessage, 500),
+		paths:    paths,
+		match:    match,
+		rescan:   make(chan bool, 1),
+		open:     make(map[string]chan *MidiMessage),
+	}
+	go dw.scanThread()
+	go dw.outputThread()
+	return dw
+}
+
+// Return the devices we'd like to have open.
+func (dw *DeviceWatcher) wanted() []Device {
+	if len(dw.paths) == 0 {
+		return ScanDevices(dw.match)
+	}
+	devices := []Device{}
+	for _, path := range dw.paths {
+		if _, err := os.Stat(path); err == nil {
+			devices = append(devices, Device{Path: path})
+		}
+	}
+	return devices
+}
+
+func (dw *DeviceWatcher) scanThread() {
+	lookingFor := "any midi device"
+	if len(dw.paths) > 0 {
+		lookingFor = strings.Join(dw.paths, ", ")
+	} else if dw.match != "" {
+		lookingFor = fmt.Sprintf("a midi device named %q", dw.match)
+	}
+	complained := false
+	for {
+		for _, device := range dw.wanted() {
+			dw.mutex.Lock()
+			_, isOpen := dw.open[device.Path]
+			dw.mutex.Unlock()
+			if !isOpen {
+				dw.openDevice(device)
+			}
+		}
+
+		dw.mutex.Lock()
+		nOpen := len(dw.open)
+		dw.mutex.Unlock()
+		if nOpen == 0 && !complained {
+			fmt.Println("[midi] couldn't find", lookingFor, "... still looking")
+		}
+		complained = nOpen == 0
+
+		time.Sleep(RESCAN_MIN_WAIT)
+		select {
+		case <-dw.rescan:
+		case <-time.After(RETRY_WAIT*time.Second - RESCAN_MIN_WAIT):
+		}
+	}
+}
+
+// Open a device for reading, and for writing if we can, and start its threads.
+func (dw *DeviceWatcher) openDevice(device Device) {
+	var outCh chan *MidiMessage
+	file, err := os.OpenFile(device.Path, os.O_RDWR, 0)
+	if err == nil {
+		outCh = make(chan *MidiMessage, 500)
+		go MidiOutputThread(outCh, file, MIDI_BYTES_PER_SECOND)
+		outCh <- IdentityRequest()
+	} else if file, err = os.Open(device.Path); err != nil {
+		return
+	}
+	fmt.Println("[midi] successfully opened midi device", device)
+
+	dw.mutex.Lock()
+	dw.open[device.Path] = outCh
+	dw.mutex.Unlock()
+
+	byteCh := make(chan byte, 3000)
+	messageCh := make(chan *MidiMessage, 500)
+	go deviceByteStreamerThread(file, byteCh)
+	go MidiStreamParserThread(byteCh, messageCh)
+	go func() {
+		for m := range messageCh {
+			dw.Messages <- m
+		}
+
+		// the device is gone
+		fmt.Println("[midi] lost midi device", device)
+		file.Close()
+		dw.mutex.Lock()
+		if outCh != nil {
+			close(outCh)
+		}
+		delete(dw.open, device.Path)
+		dw.mutex.Unlock()
+		select {
+		case dw.rescan <- true:
+		default:
+		}
+	}()
+}
+
+// Copy each outgoing message to every open device.
+// Devices which have fallen behind miss messages instead of holding up the others.
+func (dw *DeviceWatcher) outputThread() {
+	for m := range dw.Out {
+		dw.mutex.Lock()
+		for _, outCh := range dw.open {
+			if outCh == nil {
+				continue
+			}
+			select {
+			case outCh <- m:
+			default:
+			}
+		}
+		dw.mutex.Unlock()
+	}
+}
+
+// Stream the bytes from an open device until reading fails, then close outCh.
+func deviceByteStreamerThread(file *os.File, outCh chan byte) {
+	defer close(outCh)
+	buf := make([]byte, 1024)
+	for {
+		count, err := file.Read(buf)
+		for ii := 0; ii < count; ii++ {
+			outCh <- buf[ii]
+		}
+		if err != nil {
+			return
+		}
+	}
+}
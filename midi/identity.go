@@ -0,0 +1,51 @@
+package midi
+
+import (
+	"fmt"
+)
+
+// Return the universal SysEx message asking every device on the port to identify itself.
+func IdentityRequest() *MidiMessage {
+	return &MidiMessage{Kind: SYSTEM, Channel: SYSEX, Data: []byte{0x7e, 0x7f, 0x06, 0x01}}
+}
+
+// What a device says about itself in reply to IdentityRequest.
+type Identity struct {
+	Device       byte   // SysEx device id
+	Manufacturer []byte // one byte, or three bytes starting with 0 for newer manufacturers
+	Family       int    // 14-bit device family code
+	Member       int    // 14-bit model number within the family
+	Version      []byte // firmware version, usually four bytes
+}
+
+func (id *Identity) String() string {
+	return fmt.Sprintf("(manufacturer=% x family=%#x member=%#x version=% x)", id.Manufacturer, id.Family, id.Member, id.Version)
+}
+
+// If the message is a SysEx identity reply, return the identity it holds.
+// Otherwise return nil.
+// The reply looks like: f0 7e <device> 06 02 <manufacturer> <family lsb msb> <member lsb msb> <version> f7
+func ParseIdentityReply(m *MidiMessage) *Identity {
+	data := m.Data
+	if m.Kind != SYSTEM || m.Channel != SYSEX || len(data) < 5 {
+		return nil
+	}
+	if data[0] != 0x7e || data[2] != 0x06 || data[3] != 0x02 {
+		return nil
+	}
+	id := &Identity{Device: data[1]}
+	data = data[4:]
+	manufacturerLength := 1
+	if data[0] == 0 {
+		manufacturerLength = 3
+	}
+	if len(data) < manufacturerLength+4 {
+		return nil
+	}
+	id.Manufacturer = data[:manufacturerLength]
+	data = data[manufacturerLength:]
+	id.Family = int(data[0]) | int(data[1])<<7
+	id.Member = int(data[2]) | int(data[3])<<7
+	id.Version = data[4:]
+	return id
+}
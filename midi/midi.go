@@ -1,7 +1,8 @@
 /*
 Package midi allows you to listen to incoming MIDI messages.
 
-Warning!  This is not a feature-complete MIDI implementation.  MIDI system messages are ignored with the exception of CLOCK, START, and STOP messages.
+Warning!  This is not a feature-complete MIDI implementation.  Of the MIDI system messages, only CLOCK, START, CONTINUE, STOP,
+SysEx, song position, song select, tune request, and MTC quarter frames are understood.  The rest are ignored.
 
 Example
 
@@ -25,6 +26,15 @@ If you already have a main event loop, do this:
      if midiState.KeyVolumes[60] > 0 {
          fmt.Println("Key 60 is held down right now")
      }
+     if midiState.KeysPressed[60] {
+         fmt.Println("Key 60 went down since the last update")
+     }
+     fmt.Println("Pitch bend on channel 2 is", midiState.Channels[2].PitchBend)
+
+     // Follow the tempo of an incoming MIDI clock
+     if midiState.Clock.BPM > 0 && midiState.Clock.BeatPhase(time.Now()) < 0.1 {
+         fmt.Println("On the beat at", midiState.Clock.BPM, "bpm")
+     }
  }
 
 For more details on MIDI:
@@ -85,19 +95,29 @@ const (
 
 // special channel numbers for SYSTEM messages
 const (
-	CLOCK byte = 8
-	START byte = 10
-	STOP  byte = 12
+	SYSEX             byte = 0 // Data holds the bytes between 0xf0 and 0xf7
+	MTC_QUARTER_FRAME byte = 1 // Key holds the piece number and value
+	SONG_POSITION     byte = 2 // Key and Value hold the lsb and msb of the position in 16th notes
+	SONG_SELECT       byte = 3 // Key holds the song number
+	TUNE_REQUEST      byte = 6
+	SYSEX_END         byte = 7 // never sent as a message
+	CLOCK             byte = 8
+	START             byte = 10
+	CONTINUE          byte = 11
+	STOP              byte = 12
 )
 
 //================================================================================
 // MIDIMESSAGE TYPE
 
 type MidiMessage struct {
-	Kind    byte // one of the constants above
-	Channel byte // either a channel number of one of the special channel constants CLOCK, START, STOP
-	Key     byte // key, controller, instrument, or pitch bend lsb
-	Value   byte // velocity, touch, controller value, channel pressure, or pitch bend msb
+	Kind    byte   // one of the constants above
+	Channel byte   // either a channel number or, for SYSTEM messages, one of the special channel constants
+	Key     byte   // key, controller, instrument, or pitch bend lsb
+	Value   byte   // velocity, touch, controller value, channel pressure, or pitch bend msb
+	Data    []byte // SysEx payload
+
+	Time time.Time // when the message began to arrive
 }
 
 func debug(s string) {
@@ -116,6 +136,12 @@ func (m *MidiMessage) String() string {
 		kindStr = "AFTERTOUCH"
 	case CONTROLLER:
 		kindStr = "CONTROLLER"
+	case PROGRAM_CHANGE:
+		kindStr = "PROGRAM_CHANGE"
+	case CHANNEL_PRESSURE:
+		kindStr = "CHANNEL_PRESSURE"
+	case PITCH_BEND:
+		kindStr = "PITCH_BEND"
 	case SYSTEM:
 		kindStr = "SYSTEM"
 	}
@@ -129,67 +155,106 @@ func (m *MidiMessage) String() string {
 //================================================================================
 // PARSE MIDI BYTES INTO MESSAGE OBJECTS
 
+// Return how many data bytes follow a status byte, or -1 if we don't handle that kind of message.
+func dataLength(status byte) int {
+	switch status & 0xf0 {
+	case NOTE_OFF, NOTE_ON, AFTERTOUCH, CONTROLLER, PITCH_BEND:
+		return 2
+	case PROGRAM_CHANGE, CHANNEL_PRESSURE:
+		return 1
+	}
+	switch status {
+	case SYSTEM + SONG_POSITION:
+		return 2
+	case SYSTEM + MTC_QUARTER_FRAME, SYSTEM + SONG_SELECT:
+		return 1
+	case SYSTEM + TUNE_REQUEST:
+		return 0
+	}
+	return -1
+}
+
 // Read a stream of raw MIDI bytes on inCh, parse them into *MidiMessage structs,
 // and send over outCh.
-// It accepts CLOCK, START, and STOP system messages but other system messages
-// are ignored.
+// Channel messages may use running status, where the status byte is left out when it's
+// the same as the previous message's.  Real-time messages can arrive in the middle of
+// another message without disturbing it.
+// It accepts CLOCK, START, CONTINUE, and STOP real-time messages, SysEx, song position,
+// song select, tune request, and MTC quarter frames.  Other system messages are ignored.
 func MidiStreamParserThread(inCh chan byte, outCh chan *MidiMessage) {
 	debug("starting thread")
-	message := new(MidiMessage) // pointer
-	ii := 0
+	var status byte       // status of the message in progress, or 0 if we're waiting for one
+	data := []byte{}      // data bytes of the message in progress
+	var sysex []byte      // payload of the SysEx in progress, or nil if there isn't one
+	var started time.Time // when the message in progress began
+
 	for b := range inCh {
 		debug("")
-		debug(fmt.Sprintf("ii = %v, got byte %v", ii, b))
-		debug(fmt.Sprintf("current message = %v", message))
-
-		// special handling for control bytes which mark the start of messages
-		if b >= 128 {
-			debug("     this is a control byte")
-			message = new(MidiMessage)
-			message.Kind = b & 0xf0
-			message.Channel = b & 0x0f
-			ii = 1
-			if message.Kind == SYSTEM && (message.Channel == CLOCK || message.Channel == START || message.Channel == STOP) {
-				debug("sending")
-				outCh <- message
-				ii = 0
+		debug(fmt.Sprintf("got byte %v, status = %v, data = %v", b, status, data))
+
+		switch {
+		case b >= 0xf8:
+			// real-time messages are a single byte and can show up anywhere
+			channel := b & 0x0f
+			if channel == CLOCK || channel == START || channel == CONTINUE || channel == STOP {
+				debug("sending real-time message")
+				outCh <- &MidiMessage{Kind: SYSTEM, Channel: channel, Time: time.Now()}
 			}
-			continue
-		}
 
-		// handle data bytes differently depending on what number they are in a message
-		debug("     this is a data byte")
-		if ii == 0 {
-			// we should never get a data byte at ii = 0.
-			// if it happens it means we're not understanding this part of the stream,
-			// so drop the byte and do nothing until we get another control byte.
-			continue
-		} else if ii == 1 {
-			debug("     1")
-			message.Key = b
-			// these kinds expect 1 data byte, so we might be done:
-			if message.Kind == PROGRAM_CHANGE || message.Kind == CHANNEL_PRESSURE {
-				debug("sending")
-				outCh <- message
-				ii = 0
-				continue
+		case b == SYSTEM+SYSEX_END:
+			if sysex != nil {
+				debug("sending sysex")
+				outCh <- &MidiMessage{Kind: SYSTEM, Channel: SYSEX, Data: sysex, Time: started}
 			}
-		} else if ii == 2 {
-			debug("     2")
-			message.Value = b
-			// these kinds expect 2 data bytes, so we might be done:
-			if message.Kind == NOTE_OFF || message.Kind == NOTE_ON || message.Kind == AFTERTOUCH || message.Kind == CONTROLLER || message.Kind == PITCH_BEND {
+			sysex = nil
+			status = 0
+
+		case b >= 0x80:
+			// any other status byte ends a SysEx and cancels running status
+			sysex = nil
+			status = 0
+			data = data[:0]
+			started = time.Now()
+			if b == SYSTEM+SYSEX {
+				sysex = []byte{}
+			} else if dataLength(b) >= 0 {
+				status = b
+			}
+			if dataLength(status) == 0 {
 				debug("sending")
-				outCh <- message
-				ii = 0
+				outCh <- &MidiMessage{Kind: SYSTEM, Channel: status & 0x0f, Time: started}
+				status = 0
+			}
+
+		case sysex != nil:
+			sysex = append(sysex, b)
+
+		case status != 0:
+			if len(data) == 0 && status < SYSTEM {
+				// the start of another message with the same status
+				started = time.Now()
+			}
+			data = append(data, b)
+			if len(data) < dataLength(status) {
 				continue
 			}
-		} else {
-			// we're ignoring messages with more than 2 data bytes.
-			// do nothing, wait for another control byte
-		}
+			message := &MidiMessage{Kind: status & 0xf0, Channel: status & 0x0f, Key: data[0], Time: started}
+			if len(data) > 1 {
+				message.Value = data[1]
+			}
+			debug("sending")
+			outCh <- message
+			data = data[:0]
+			if status >= SYSTEM {
+				// only channel messages have running status
+				status = 0
+			}
 
-		ii += 1
+		default:
+			// a data byte without a status byte.
+			// it means we're not understanding this part of the stream,
+			// so drop the byte and do nothing until we get another status byte.
+		}
 	}
 
 	// if we get here, inCh has been closed
@@ -246,11 +311,80 @@ func tenaciousFileByteStreamerThread(path string, outCh chan byte) {
 //================================================================================
 // MIDISTATE TYPE
 
+// Controller numbers for selecting and setting NRPNs (non-registered parameter numbers)
+// and RPNs (registered parameter numbers).
+const (
+	CC_DATA_ENTRY_MSB byte = 6
+	CC_DATA_ENTRY_LSB byte = 38
+	CC_NRPN_LSB       byte = 98
+	CC_NRPN_MSB       byte = 99
+	CC_RPN_LSB        byte = 100
+	CC_RPN_MSB        byte = 101
+)
+
+// The state of one MIDI channel.
+type ChannelState struct {
+	KeyVolumes       [128]byte // values from 0 to 127
+	ControllerValues [128]byte // values from 0 to 127
+	Aftertouch       [128]byte // polyphonic key pressure from 0 to 127
+	Pressure         byte      // channel pressure from 0 to 127
+	PitchBend        int       // from -8192 to 8191, 0 is centered
+	Program          byte      // most recent program change
+
+	ControllersPressed [128]bool // controllers which went from 0 to non-zero during the most recent call to UpdateStateXXX()
+
+	nrpn         int  // currently selected NRPN
+	nrpnSelected bool // false when no NRPN is selected or an RPN is selected instead
+}
+
 // Keeps track of the current state of the keys and controllers.
+// The top level fields merge all the channels together: they hold the most recent
+// value from any channel.  Channels holds each channel separately.
 type MidiState struct {
-	KeyVolumes         [128]byte      // values from 0 to 127
-	ControllerValues   [128]byte      // values from 0 to 127
+	KeyVolumes         [128]byte // values from 0 to 127
+	ControllerValues   [128]byte // values from 0 to 127
+	Aftertouch         [128]byte // polyphonic key pressure from 0 to 127
+	Pressure           byte      // channel pressure from 0 to 127
+	PitchBend          int       // from -8192 to 8191, 0 is centered
+	Program            byte      // most recent program change
+	Channels           [16]ChannelState
+	NRPNValues         map[int]int    // channel << 14 | NRPN --> 14-bit value
+	KeysPressed        [128]bool      // keys which went down during the most recent call to UpdateStateXXX()
+	KeysReleased       [128]bool      // keys which went up during the most recent call to UpdateStateXXX()
+	ControllersPressed [128]bool      // controllers which went from 0 to non-zero on any channel during the most recent call to UpdateStateXXX()
 	RecentMidiMessages []*MidiMessage // midi messages from the most recent call to UpdateStateXXX()
+
+	Clock       Clock    // tempo and beat position
+	Timecode    Timecode // most recent MIDI time code
+	TapTempoKey byte     // key which taps the tempo when there's no clock, or 0 for none
+
+	Time          time.Time          // when the frame began, for a snapshot made by Snapshot.  zero otherwise
+	ControlValues map[string]float64 // values of named controls worked out for this frame by a control layer, if any
+}
+
+// Return the 14-bit value of a controller from 0 to 31 combined with its
+// least significant byte from the controller 32 higher.
+func controller14(values *[128]byte, controller byte) int {
+	if controller >= 32 {
+		return int(values[controller]) << 7
+	}
+	return int(values[controller])<<7 | int(values[controller+32])
+}
+
+// Return the 14-bit value, from 0 to 16383, of a controller from 0 to 31 and its partner from 32 to 63.
+func (midiState *MidiState) Controller14(controller byte) int {
+	return controller14(&midiState.ControllerValues, controller)
+}
+
+// Return the 14-bit value, from 0 to 16383, of a controller from 0 to 31 and its partner from 32 to 63.
+func (cs *ChannelState) Controller14(controller byte) int {
+	return controller14(&cs.ControllerValues, controller)
+}
+
+// Return the most recent value of an NRPN on a channel, and whether one has been received.
+func (midiState *MidiState) NRPN(channel byte, nrpn int) (value int, ok bool) {
+	value, ok = midiState.NRPNValues[int(channel)<<14|nrpn]
+	return value, ok
 }
 
 // Pull all the available MidiMessages out of the channel without blocking.  Requires a channel
@@ -275,18 +409,162 @@ func (midiState *MidiState) UpdateStateFromChannel(midiMessageChan chan *MidiMes
 }
 
 // Given a slice of MidiMessages, update the MidiState object.
-// Note that the MidiState object will keep a pointer to the midiMessages
-// object you provide here (as MidiState.RecentMidiMessages).
+// The MidiState keeps its own copy of the slice (as MidiState.RecentMidiMessages),
+// so the caller is free to reuse it.
 func (midiState *MidiState) UpdateStateFromSlice(midiMessages []*MidiMessage) {
-	midiState.RecentMidiMessages = midiMessages
+	midiState.RecentMidiMessages = append([]*MidiMessage{}, midiMessages...)
+	midiState.KeysPressed = [128]bool{}
+	midiState.KeysReleased = [128]bool{}
+	midiState.ControllersPressed = [128]bool{}
+	for ii := range midiState.Channels {
+		midiState.Channels[ii].ControllersPressed = [128]bool{}
+	}
 	for _, m := range midiState.RecentMidiMessages {
+		if m.Kind == SYSTEM {
+			midiState.Clock.update(m)
+			if m.Channel == MTC_QUARTER_FRAME {
+				midiState.Timecode.update(m.Key)
+			}
+			continue
+		}
+		channel := &midiState.Channels[m.Channel]
 		switch m.Kind {
 		case NOTE_OFF:
-			midiState.KeyVolumes[m.Key] = 0
+			midiState.releaseKey(channel, m.Key)
 		case NOTE_ON:
+			if m.Value == 0 {
+				// running status senders use velocity 0 for note off
+				midiState.releaseKey(channel, m.Key)
+				break
+			}
+			if midiState.KeyVolumes[m.Key] == 0 {
+				midiState.KeysPressed[m.Key] = true
+			}
 			midiState.KeyVolumes[m.Key] = m.Value
+			channel.KeyVolumes[m.Key] = m.Value
+			if m.Key == midiState.TapTempoKey && m.Key != 0 {
+				midiState.Clock.tap(m.Time)
+			}
+		case AFTERTOUCH:
+			midiState.Aftertouch[m.Key] = m.Value
+			channel.Aftertouch[m.Key] = m.Value
 		case CONTROLLER:
+			// buttons which send a controller go down when it leaves 0, not on every non-zero value
+			if channel.ControllerValues[m.Key] == 0 && m.Value > 0 {
+				midiState.ControllersPressed[m.Key] = true
+				channel.ControllersPressed[m.Key] = true
+			}
 			midiState.ControllerValues[m.Key] = m.Value
+			channel.ControllerValues[m.Key] = m.Value
+			midiState.updateNRPN(m.Channel, channel, m.Key)
+		case PROGRAM_CHANGE:
+			midiState.Program = m.Key
+			channel.Program = m.Key
+		case CHANNEL_PRESSURE:
+			midiState.Pressure = m.Key
+			channel.Pressure = m.Key
+		case PITCH_BEND:
+			bend := int(m.Value)<<7 | int(m.Key) - 8192
+			midiState.PitchBend = bend
+			channel.PitchBend = bend
+		}
+	}
+}
+
+// Return a copy of the state as of a frame beginning at now, which shares nothing
+// with the original.  Other threads can read a snapshot while the original keeps changing,
+// as long as nobody changes the snapshot.
+func (midiState *MidiState) Snapshot(now time.Time) *MidiState {
+	snapshot := *midiState
+	snapshot.Time = now
+	if midiState.NRPNValues != nil {
+		snapshot.NRPNValues = make(map[int]int, len(midiState.NRPNValues))
+		for key, value := range midiState.NRPNValues {
+			snapshot.NRPNValues[key] = value
+		}
+	}
+	if midiState.ControlValues != nil {
+		snapshot.ControlValues = make(map[string]float64, len(midiState.ControlValues))
+		for name, value := range midiState.ControlValues {
+			snapshot.ControlValues[name] = value
+		}
+	}
+	snapshot.RecentMidiMessages = append([]*MidiMessage{}, midiState.RecentMidiMessages...)
+	return &snapshot
+}
+
+// Collects the key presses, key releases and messages from several updates of a MidiState,
+// for snapshots which are taken less often than the state is updated.  Otherwise a pad hit
+// or a program change between two snapshots would be lost.
+type RecentEvents struct {
+	keysPressed        [128]bool
+	keysReleased       [128]bool
+	controllersPressed [17][128]bool // for any channel, then for each channel
+	messages           []*MidiMessage
+}
+
+// Add the events from the most recent call to UpdateStateXXX().
+func (re *RecentEvents) Add(midiState *MidiState) {
+	for key := range re.keysPressed {
+		re.keysPressed[key] = re.keysPressed[key] || midiState.KeysPressed[key]
+		re.keysReleased[key] = re.keysReleased[key] || midiState.KeysReleased[key]
+		re.controllersPressed[0][key] = re.controllersPressed[0][key] || midiState.ControllersPressed[key]
+		for ii := range midiState.Channels {
+			pressed := &re.controllersPressed[ii+1][key]
+			*pressed = *pressed || midiState.Channels[ii].ControllersPressed[key]
+		}
+	}
+	re.messages = append(re.messages, midiState.RecentMidiMessages...)
+}
+
+// Return a snapshot of the state as of a frame beginning at now (see Snapshot) which has
+// every event added since the last call, and start collecting again.
+func (re *RecentEvents) Snapshot(midiState *MidiState, now time.Time) *MidiState {
+	snapshot := midiState.Snapshot(now)
+	snapshot.KeysPressed = re.keysPressed
+	snapshot.KeysReleased = re.keysReleased
+	snapshot.ControllersPressed = re.controllersPressed[0]
+	for ii := range snapshot.Channels {
+		snapshot.Channels[ii].ControllersPressed = re.controllersPressed[ii+1]
+	}
+	snapshot.RecentMidiMessages = re.messages
+	*re = RecentEvents{}
+	return snapshot
+}
+
+func (midiState *MidiState) releaseKey(channel *ChannelState, key byte) {
+	if midiState.KeyVolumes[key] > 0 {
+		midiState.KeysReleased[key] = true
+	}
+	midiState.KeyVolumes[key] = 0
+	midiState.Aftertouch[key] = 0
+	channel.KeyVolumes[key] = 0
+	channel.Aftertouch[key] = 0
+}
+
+// Keep track of which NRPN is selected on a channel and store data entry values for it.
+func (midiState *MidiState) updateNRPN(channelNumber byte, channel *ChannelState, controller byte) {
+	values := &channel.ControllerValues
+	switch controller {
+	case CC_NRPN_MSB, CC_NRPN_LSB:
+		channel.nrpn = int(values[CC_NRPN_MSB])<<7 | int(values[CC_NRPN_LSB])
+		// 127, 127 is the "null" parameter which deselects everything
+		channel.nrpnSelected = channel.nrpn != 0x3fff
+	case CC_RPN_MSB, CC_RPN_LSB:
+		channel.nrpnSelected = false
+	case CC_DATA_ENTRY_MSB, CC_DATA_ENTRY_LSB:
+		if !channel.nrpnSelected {
+			return
+		}
+		if midiState.NRPNValues == nil {
+			midiState.NRPNValues = make(map[int]int)
+		}
+		key := int(channelNumber)<<14 | channel.nrpn
+		if controller == CC_DATA_ENTRY_MSB {
+			// a new MSB starts a new value
+			midiState.NRPNValues[key] = int(values[CC_DATA_ENTRY_MSB]) << 7
+		} else {
+			midiState.NRPNValues[key] = midiState.NRPNValues[key]&^0x7f | int(values[CC_DATA_ENTRY_LSB])
 		}
 	}
 }
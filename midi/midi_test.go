@@ -2,7 +2,12 @@ package midi
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 //================================================================================
@@ -60,7 +65,8 @@ func TestMidiStreamParser(t *testing.T) {
 	midiTest(t, []byte{0x9f, 60, 0}, []byte{NOTE_ON})
 	midiTest(t, []byte{0x90, 31, 127, 0x90, 31, 0}, []byte{NOTE_ON, NOTE_ON})
 	midiTest(t, []byte{0x90, 31, 127, 7, 0x90, 31, 0}, []byte{NOTE_ON, NOTE_ON})
-	midiTest(t, []byte{0x90, 31, 127, 7, 7, 7, 7, 7, 0x90, 31, 0}, []byte{NOTE_ON, NOTE_ON})
+	// running status turns the extra data bytes into two more notes
+	midiTest(t, []byte{0x90, 31, 127, 7, 7, 7, 7, 7, 0x90, 31, 0}, []byte{NOTE_ON, NOTE_ON, NOTE_ON, NOTE_ON})
 	midiTest(t, []byte{0xb0, 64, 127, 0x90, 60, 0}, []byte{CONTROLLER, NOTE_ON})
 	midiTest(t, []byte{0x90, 31, 127, 0xf0 + CLOCK, 0x90, 31, 0}, []byte{NOTE_ON, SYSTEM, NOTE_ON})
 	midiTest(t, []byte{0x90, 31, 127, 0xf0 + START, 0x90, 31, 0}, []byte{NOTE_ON, SYSTEM, NOTE_ON})
@@ -68,6 +74,70 @@ func TestMidiStreamParser(t *testing.T) {
 	midiTest(t, []byte{0x90, 31, 127, 0xf0, 0x90, 31, 0}, []byte{NOTE_ON, NOTE_ON})
 }
 
+func TestMidiStreamParserMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytes    []byte
+		expected []MidiMessage
+	}{
+		{"running status",
+			[]byte{0x91, 60, 100, 61, 90, 62, 0},
+			[]MidiMessage{{Kind: NOTE_ON, Channel: 1, Key: 60, Value: 100}, {Kind: NOTE_ON, Channel: 1, Key: 61, Value: 90}, {Kind: NOTE_ON, Channel: 1, Key: 62, Value: 0}}},
+		{"running status with one data byte",
+			[]byte{0xc2, 5, 6, 7},
+			[]MidiMessage{{Kind: PROGRAM_CHANGE, Channel: 2, Key: 5}, {Kind: PROGRAM_CHANGE, Channel: 2, Key: 6}, {Kind: PROGRAM_CHANGE, Channel: 2, Key: 7}}},
+		{"real-time in the middle of a message",
+			[]byte{0xb0, 7, 0xf8, 100, 8, 0xfa, 50},
+			[]MidiMessage{{Kind: SYSTEM, Channel: CLOCK}, {Kind: CONTROLLER, Key: 7, Value: 100}, {Kind: SYSTEM, Channel: START}, {Kind: CONTROLLER, Key: 8, Value: 50}}},
+		{"real-time bytes we don't use",
+			[]byte{0xfe, 0x90, 60, 0xfe, 1, 0xff},
+			[]MidiMessage{{Kind: NOTE_ON, Key: 60, Value: 1}}},
+		{"continue",
+			[]byte{0xfb},
+			[]MidiMessage{{Kind: SYSTEM, Channel: CONTINUE}}},
+		{"sysex",
+			[]byte{0xf0, 0x7e, 0x7f, 0x06, 0x01, 0xf7, 0x90, 60, 1},
+			[]MidiMessage{{Kind: SYSTEM, Channel: SYSEX, Data: []byte{0x7e, 0x7f, 0x06, 0x01}}, {Kind: NOTE_ON, Key: 60, Value: 1}}},
+		{"sysex with a clock inside",
+			[]byte{0xf0, 0x41, 0xf8, 0x42, 0xf7},
+			[]MidiMessage{{Kind: SYSTEM, Channel: CLOCK}, {Kind: SYSTEM, Channel: SYSEX, Data: []byte{0x41, 0x42}}}},
+		{"sysex cut off by another status byte",
+			[]byte{0xf0, 0x41, 0x42, 0x90, 60, 1, 0xf7},
+			[]MidiMessage{{Kind: NOTE_ON, Key: 60, Value: 1}}},
+		{"sysex ends running status",
+			[]byte{0x90, 60, 1, 0xf0, 0xf7, 61, 1},
+			[]MidiMessage{{Kind: NOTE_ON, Key: 60, Value: 1}, {Kind: SYSTEM, Channel: SYSEX, Data: []byte{}}}},
+		{"song position",
+			[]byte{0xf2, 0x10, 0x02},
+			[]MidiMessage{{Kind: SYSTEM, Channel: SONG_POSITION, Key: 0x10, Value: 0x02}}},
+		{"song position has no running status",
+			[]byte{0xf2, 0x10, 0x02, 0x11, 0x03},
+			[]MidiMessage{{Kind: SYSTEM, Channel: SONG_POSITION, Key: 0x10, Value: 0x02}}},
+		{"mtc quarter frame",
+			[]byte{0xf1, 0x35},
+			[]MidiMessage{{Kind: SYSTEM, Channel: MTC_QUARTER_FRAME, Key: 0x35}}},
+		{"tune request",
+			[]byte{0xf6, 0x90, 60, 1},
+			[]MidiMessage{{Kind: SYSTEM, Channel: TUNE_REQUEST}, {Kind: NOTE_ON, Key: 60, Value: 1}}},
+		{"undefined system messages",
+			[]byte{0xf4, 1, 2, 0xf5, 3},
+			[]MidiMessage{}},
+	}
+	for _, test := range tests {
+		midiMessages := midiBytesToMessages(test.bytes)
+		if len(midiMessages) != len(test.expected) {
+			t.Errorf("%s: got %v, want %d messages", test.name, midiMessages, len(test.expected))
+			continue
+		}
+		for ii, m := range midiMessages {
+			want := test.expected[ii]
+			if m.Kind != want.Kind || m.Channel != want.Channel || m.Key != want.Key || m.Value != want.Value || string(m.Data) != string(want.Data) {
+				t.Errorf("%s: message %d is %v %v, want %v %v", test.name, ii, m, m.Data, &want, want.Data)
+			}
+		}
+	}
+}
+
 //================================================================================
 
 func TestMidiState(t *testing.T) {
@@ -84,3 +154,510 @@ func TestMidiState(t *testing.T) {
 		t.Errorf("state failed")
 	}
 }
+
+//================================================================================
+
+// Parse the bytes and pretend the messages arrived interval apart, starting at start.
+func timedMessages(bytes []byte, start time.Time, interval time.Duration) []*MidiMessage {
+	midiMessages := midiBytesToMessages(bytes)
+	for ii, m := range midiMessages {
+		m.Time = start.Add(time.Duration(ii) * interval)
+	}
+	return midiMessages
+}
+
+func TestMidiClock(t *testing.T) {
+	state := MidiState{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// START, then two beats of clock at 120 bpm (24 ticks per half second)
+	tickInterval := time.Second / 48
+	bytes := []byte{0xf0 + START}
+	for ii := 0; ii < 48; ii++ {
+		bytes = append(bytes, 0xf0+CLOCK)
+	}
+	state.UpdateStateFromSlice(timedMessages(bytes, start.Add(-tickInterval), tickInterval))
+	now := start.Add(47 * tickInterval)
+
+	if !state.Clock.Running {
+		t.Errorf("clock should be running after START")
+	}
+	if state.Clock.Ticks != 48 {
+		t.Errorf("got %d ticks, want 48", state.Clock.Ticks)
+	}
+	if math.Abs(state.Clock.BPM-120) > 0.5 {
+		t.Errorf("got %f bpm, want 120", state.Clock.BPM)
+	}
+	if beats := state.Clock.Beats(now); math.Abs(beats-47.0/24) > 0.01 {
+		t.Errorf("got %f beats, want %f", beats, 47.0/24)
+	}
+	// halfway between ticks the phase should keep moving
+	if beats := state.Clock.Beats(now.Add(tickInterval / 2)); math.Abs(beats-47.5/24) > 0.01 {
+		t.Errorf("got %f beats between ticks, want %f", beats, 47.5/24)
+	}
+	if phase := state.Clock.BarPhase(now); math.Abs(phase-47.0/24/4) > 0.01 {
+		t.Errorf("got bar phase %f, want %f", phase, 47.0/24/4)
+	}
+
+	// STOP freezes the position
+	state.UpdateStateFromSlice(timedMessages([]byte{0xf0 + STOP}, now.Add(tickInterval/2), 0))
+	if state.Clock.Running {
+		t.Errorf("clock should not be running after STOP")
+	}
+	if beats := state.Clock.Beats(now.Add(tickInterval)); math.Abs(beats-47.0/24) > 0.01 {
+		t.Errorf("got %f beats after STOP, want %f", beats, 47.0/24)
+	}
+}
+
+func TestMidiClockWithoutStart(t *testing.T) {
+	state := MidiState{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a beat of clock at 120 bpm with no START in front of it
+	tickInterval := time.Second / 48
+	bytes := []byte{}
+	for ii := 0; ii < 25; ii++ {
+		bytes = append(bytes, 0xf0+CLOCK)
+	}
+	state.UpdateStateFromSlice(timedMessages(bytes, start, tickInterval))
+	now := start.Add(24 * tickInterval)
+	if state.Clock.Running {
+		t.Errorf("clock should not be running without START")
+	}
+	if beats := state.Clock.Beats(now); math.Abs(beats-1) > 0.01 {
+		t.Errorf("got %f beats, want 1", beats)
+	}
+	if beats := state.Clock.Beats(now.Add(tickInterval / 2)); math.Abs(beats-24.5/24) > 0.01 {
+		t.Errorf("got %f beats between ticks, want %f", beats, 24.5/24)
+	}
+
+	// clock which keeps coming after STOP doesn't move the position until CONTINUE
+	bytes = []byte{0xf0 + STOP, 0xf0 + CLOCK, 0xf0 + CLOCK, 0xf0 + CONTINUE, 0xf0 + CLOCK}
+	state.UpdateStateFromSlice(timedMessages(bytes, now.Add(tickInterval/2), tickInterval/2))
+	if state.Clock.Ticks != 26 {
+		t.Errorf("got %d ticks, want 26", state.Clock.Ticks)
+	}
+}
+
+func TestTapTempo(t *testing.T) {
+	state := MidiState{TapTempoKey: 44}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// four taps at 100 bpm
+	taps := []byte{0x90, 44, 127, 0x80, 44, 0, 0x90, 44, 127, 0x80, 44, 0, 0x90, 44, 127, 0x80, 44, 0, 0x90, 44, 127}
+	state.UpdateStateFromSlice(timedMessages(taps, start, 300*time.Millisecond))
+	now := start.Add(1800 * time.Millisecond)
+
+	if !state.Clock.Tapped {
+		t.Errorf("tempo should come from tapping")
+	}
+	if math.Abs(state.Clock.BPM-100) > 0.5 {
+		t.Errorf("got %f bpm, want 100", state.Clock.BPM)
+	}
+	if phase := state.Clock.BeatPhase(now); phase > 0.01 {
+		t.Errorf("got beat phase %f on a tap, want 0", phase)
+	}
+	if phase := state.Clock.BeatPhase(now.Add(300 * time.Millisecond)); math.Abs(phase-0.5) > 0.01 {
+		t.Errorf("got beat phase %f halfway to the next beat, want 0.5", phase)
+	}
+
+	// a clock takes over from tapping
+	state.UpdateStateFromSlice(timedMessages([]byte{0xf8, 0xf8, 0xf8}, now, time.Second/48))
+	if state.Clock.Tapped {
+		t.Errorf("tempo should come from the clock once it arrives")
+	}
+}
+
+//================================================================================
+
+func TestMidiStateChannelMessages(t *testing.T) {
+	state := MidiState{}
+	state.UpdateStateFromSlice(midiBytesToMessages([]byte{
+		0x91, 60, 100, // note on, channel 1
+		0xa1, 60, 55, // aftertouch
+		0xe2, 0x00, 0x60, // pitch bend on channel 2
+		0xd2, 33, // channel pressure
+		0xc3, 5, // program change on channel 3
+		0xb0, 7, 100, 0xb0, 39, 3, // 14-bit volume
+	}))
+	if state.KeyVolumes[60] != 100 || state.Channels[1].KeyVolumes[60] != 100 || state.Channels[0].KeyVolumes[60] != 0 {
+		t.Errorf("note on should be recorded on channel 1 only")
+	}
+	if !state.KeysPressed[60] || state.KeysReleased[60] {
+		t.Errorf("key 60 should have been pressed")
+	}
+	if state.Aftertouch[60] != 55 || state.Channels[1].Aftertouch[60] != 55 {
+		t.Errorf("got aftertouch %d, want 55", state.Aftertouch[60])
+	}
+	if state.PitchBend != 4096 || state.Channels[2].PitchBend != 4096 {
+		t.Errorf("got pitch bend %d, want 4096", state.PitchBend)
+	}
+	if state.Pressure != 33 || state.Channels[2].Pressure != 33 {
+		t.Errorf("got channel pressure %d, want 33", state.Pressure)
+	}
+	if state.Program != 5 || state.Channels[3].Program != 5 {
+		t.Errorf("got program %d, want 5", state.Program)
+	}
+	if v := state.Controller14(7); v != 100<<7|3 {
+		t.Errorf("got 14-bit controller %d, want %d", v, 100<<7|3)
+	}
+
+	// velocity 0 means note off, and the pressed and released sets only last one update
+	state.UpdateStateFromSlice(midiBytesToMessages([]byte{0x91, 60, 0}))
+	if state.KeyVolumes[60] != 0 || state.Channels[1].KeyVolumes[60] != 0 || state.Aftertouch[60] != 0 {
+		t.Errorf("note on with velocity 0 should release the key")
+	}
+	if state.KeysPressed[60] || !state.KeysReleased[60] {
+		t.Errorf("key 60 should have been released")
+	}
+	state.UpdateStateFromSlice(midiBytesToMessages([]byte{}))
+	if state.KeysPressed[60] || state.KeysReleased[60] {
+		t.Errorf("pressed and released should be cleared on the next update")
+	}
+}
+
+func TestMidiStateNRPN(t *testing.T) {
+	state := MidiState{}
+	state.UpdateStateFromSlice(midiBytesToMessages([]byte{
+		0xb4, 99, 1, 0xb4, 98, 8, // select NRPN 136 on channel 4
+		0xb4, 6, 64, 0xb4, 38, 10, // data entry
+		0xb4, 101, 0, 0xb4, 100, 0, // select an RPN instead
+		0xb4, 6, 2, // which isn't stored as an NRPN
+	}))
+	if v, ok := state.NRPN(4, 1<<7|8); !ok || v != 64<<7|10 {
+		t.Errorf("got NRPN value %d (%v), want %d", v, ok, 64<<7|10)
+	}
+	if _, ok := state.NRPN(0, 1<<7|8); ok {
+		t.Errorf("NRPN should only be set on channel 4")
+	}
+}
+
+func TestMidiStateSnapshot(t *testing.T) {
+	state := MidiState{}
+	messages := midiBytesToMessages([]byte{
+		0xb0, 99, 0, 0xb0, 98, 1, 0xb0, 6, 5, // NRPN 1 on channel 0 gets 5 << 7
+		0x90, 60, 100,
+	})
+	state.UpdateStateFromSlice(messages)
+	now := time.Now()
+	snapshot := state.Snapshot(now)
+
+	// reusing the slice or updating the state again doesn't change the snapshot
+	messages[0] = &MidiMessage{Kind: NOTE_OFF, Key: 60}
+	state.UpdateStateFromSlice(midiBytesToMessages([]byte{0x80, 60, 0, 0xb0, 6, 9}))
+	if len(state.RecentMidiMessages) != 2 || state.RecentMidiMessages[0] == messages[0] {
+		t.Errorf("the state should keep its own copy of the messages")
+	}
+	if snapshot.RecentMidiMessages[0].Kind != CONTROLLER || len(snapshot.RecentMidiMessages) != 4 {
+		t.Errorf("the snapshot's messages changed")
+	}
+	if v, _ := snapshot.NRPN(0, 1); v != 5<<7 {
+		t.Errorf("got snapshot NRPN %d, want %d", v, 5<<7)
+	}
+	if v, _ := state.NRPN(0, 1); v != 9<<7 {
+		t.Errorf("got NRPN %d, want %d", v, 9<<7)
+	}
+	if snapshot.KeyVolumes[60] != 100 || !snapshot.KeysPressed[60] || !snapshot.Time.Equal(now) {
+		t.Errorf("got snapshot key %d pressed %v time %v", snapshot.KeyVolumes[60], snapshot.KeysPressed[60], snapshot.Time)
+	}
+}
+
+func TestRecentEvents(t *testing.T) {
+	state := MidiState{}
+	var recent RecentEvents
+	update := func(bytes []byte) {
+		state.UpdateStateFromSlice(midiBytesToMessages(bytes))
+		recent.Add(&state)
+	}
+	// a quick tap, a program change, and a pad held down, over three updates between snapshots
+	update([]byte{0x90, 36, 100})
+	update([]byte{0x80, 36, 0, 0xc0, 5})
+	update([]byte{0x90, 37, 90, 0xb2, 20, 127})
+	snapshot := recent.Snapshot(&state, time.Now())
+	if !snapshot.KeysPressed[36] || !snapshot.KeysReleased[36] || !snapshot.KeysPressed[37] {
+		t.Errorf("pressed %v %v released %v, want every key's press and release", snapshot.KeysPressed[36], snapshot.KeysPressed[37], snapshot.KeysReleased[36])
+	}
+	if !snapshot.ControllersPressed[20] || !snapshot.Channels[2].ControllersPressed[20] || snapshot.Channels[0].ControllersPressed[20] {
+		t.Errorf("got controller 20 pressed %v, on channel 3 %v, want it pressed on channel 3 only", snapshot.ControllersPressed[20], snapshot.Channels[2].ControllersPressed[20])
+	}
+	if len(snapshot.RecentMidiMessages) != 5 || snapshot.RecentMidiMessages[2].Kind != PROGRAM_CHANGE {
+		t.Errorf("got messages %v, want all five", snapshot.RecentMidiMessages)
+	}
+	if snapshot.KeyVolumes[36] != 0 || snapshot.KeyVolumes[37] != 90 || snapshot.Program != 5 {
+		t.Errorf("the snapshot should have the state as it is now")
+	}
+
+	// and it starts over after each snapshot
+	update(nil)
+	snapshot = recent.Snapshot(&state, time.Now())
+	if snapshot.KeysPressed[37] || snapshot.ControllersPressed[20] || len(snapshot.RecentMidiMessages) != 0 {
+		t.Errorf("got pressed %v %v and messages %v, want nothing new", snapshot.KeysPressed[37], snapshot.ControllersPressed[20], snapshot.RecentMidiMessages)
+	}
+}
+
+func TestSongPositionAndTimecode(t *testing.T) {
+	state := MidiState{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// jump to the 9th 16th note (beat 2 of bar 1), then continue
+	state.UpdateStateFromSlice(timedMessages([]byte{0xf2, 8, 0, 0xfb, 0xf8}, start, time.Second/48))
+	if beats := state.Clock.Beats(start.Add(2 * time.Second / 48)); beats != 2 {
+		t.Errorf("got %f beats after song position, want 2", beats)
+	}
+
+	// 01:02:03 frame 4 at 25 fps, as eight quarter frames
+	quarterFrames := []byte{}
+	for ii, nibble := range []byte{4, 0, 3, 0, 2, 0, 1, 1 << 1} {
+		quarterFrames = append(quarterFrames, 0xf1, byte(ii)<<4|nibble)
+	}
+	state.UpdateStateFromSlice(midiBytesToMessages(quarterFrames))
+	tc := state.Timecode
+	if tc.Hours != 1 || tc.Minutes != 2 || tc.Seconds != 3 || tc.Frames != 4 || tc.Rate != 25 {
+		t.Errorf("got timecode %+v, want 01:02:03:04 at 25 fps", tc)
+	}
+	if seconds := tc.TotalSeconds(); math.Abs(seconds-3723.16) > 0.001 {
+		t.Errorf("got %f seconds, want 3723.16", seconds)
+	}
+}
+
+func TestParseIdentityReply(t *testing.T) {
+	messages := midiBytesToMessages([]byte{
+		0xf0, 0x7e, 0x00, 0x06, 0x02, 0x42, 0x13, 0x01, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0xf7, // one byte manufacturer
+		0xf0, 0x7e, 0x00, 0x06, 0x02, 0x00, 0x20, 0x29, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02, 0xf7, // three bytes
+		0xf0, 0x7e, 0x7f, 0x06, 0x01, 0xf7, // an identity request, not a reply
+	})
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(messages))
+	}
+	id := ParseIdentityReply(messages[0])
+	if id == nil || string(id.Manufacturer) != "\x42" || id.Family != 0x01<<7|0x13 || id.Member != 0 {
+		t.Errorf("got identity %v, want Korg family %#x", id, 0x01<<7|0x13)
+	}
+	id = ParseIdentityReply(messages[1])
+	if id == nil || string(id.Manufacturer) != "\x00\x20\x29" || id.Family != 0x20 || len(id.Version) != 4 {
+		t.Errorf("got identity %v, want Novation family 0x20", id)
+	}
+	if id := ParseIdentityReply(messages[2]); id != nil {
+		t.Errorf("identity request parsed as reply %v", id)
+	}
+}
+
+func TestMidiMessageBytes(t *testing.T) {
+	bytes := []byte{
+		0x93, 60, 100, // note on
+		0xb0, 7, 127, // controller
+		0xc2, 5, // program change
+		0xe0, 0, 64, // pitch bend
+		0xf0, 0x7e, 0x7f, 0x06, 0x01, 0xf7, // sysex
+		0xf2, 8, 0, // song position
+		0xf8, // clock
+	}
+	encoded := []byte{}
+	for _, m := range midiBytesToMessages(bytes) {
+		encoded = append(encoded, m.Bytes()...)
+	}
+	if string(encoded) != string(bytes) {
+		t.Errorf("got % x, want % x", encoded, bytes)
+	}
+	if request := IdentityRequest().Bytes(); string(request) != "\xf0\x7e\x7f\x06\x01\xf7" {
+		t.Errorf("got identity request % x", request)
+	}
+}
+
+func TestMidiOutputThread(t *testing.T) {
+	// messages which pile up before the thread gets to them are merged
+	inCh := make(chan *MidiMessage, 10)
+	inCh <- &MidiMessage{Kind: NOTE_ON, Key: 1, Value: 5}
+	inCh <- &MidiMessage{Kind: CONTROLLER, Key: 7, Value: 127}
+	inCh <- &MidiMessage{Kind: NOTE_OFF, Key: 1}
+	inCh <- &MidiMessage{Kind: NOTE_ON, Channel: 1, Key: 1, Value: 3}
+	close(inCh)
+
+	reader, writer := io.Pipe()
+	errCh := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		errCh <- MidiOutputThread(inCh, writer, 300)
+		writer.Close()
+	}()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("output thread returned %v", err)
+	}
+	want := []byte{0x80, 1, 0, 0xb0, 7, 127, 0x91, 1, 3}
+	if string(got) != string(want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+	// 9 bytes at 300 bytes per second
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("sent 9 bytes in %v, which is faster than the rate limit", elapsed)
+	}
+}
+
+func TestMidiOutputStream(t *testing.T) {
+	// a temp file stands in for the midi device
+	path := filepath.Join(t.TempDir(), "midi")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	outCh := GetMidiOutputStream(path)
+	outCh <- &MidiMessage{Kind: NOTE_ON, Key: 60, Value: 1}
+	outCh <- IdentityRequest()
+	close(outCh)
+
+	want := "\x90\x3c\x01\xf0\x7e\x7f\x06\x01\xf7"
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if got, _ := os.ReadFile(path); string(got) == want {
+			return
+		}
+	}
+	got, _ := os.ReadFile(path)
+	t.Errorf("device file holds % x, want % x", got, want)
+}
+
+func TestScanDevices(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"dev/snd/midiC1D0":   "",
+		"dev/midi1":          "", // the same device as midiC1D0
+		"dev/midi2":          "",
+		"dev/snd/pcmC0D0p":   "",
+		"asound/card1/midi0": "nanoKONTROL2 MIDI 1\n\nOutput 0\n",
+		"asound/card1/id":    "nanoKONTROL2\n",
+		"asound/card2/id":    "mini\n",
+	}
+	for fn, contents := range files {
+		path := filepath.Join(root, fn)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(globs []string, asound string) {
+		MIDI_DEVICE_GLOBS, PROC_ASOUND = globs, asound
+	}(MIDI_DEVICE_GLOBS, PROC_ASOUND)
+	MIDI_DEVICE_GLOBS = []string{filepath.Join(root, "dev/snd/midiC*D*"), filepath.Join(root, "dev/midi*")}
+	PROC_ASOUND = filepath.Join(root, "asound")
+
+	devices := ScanDevices("")
+	if len(devices) != 2 {
+		t.Fatalf("got devices %v, want 2", devices)
+	}
+	if devices[0].Path != filepath.Join(root, "dev/midi2") || devices[0].Name != "mini" {
+		t.Errorf("got device %v, want midi2 named mini", devices[0])
+	}
+	if devices[1].Path != filepath.Join(root, "dev/snd/midiC1D0") || devices[1].Name != "nanoKONTROL2 MIDI 1" {
+		t.Errorf("got device %v, want midiC1D0 named nanoKONTROL2 MIDI 1", devices[1])
+	}
+	if devices := ScanDevices("NANO"); len(devices) != 1 || devices[0].Card != 1 {
+		t.Errorf("got devices %v matching NANO, want card 1", devices)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "recording.txt")
+	recorder, err := NewRecorder(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := recorder.start
+	recorded := []*MidiMessage{
+		{Kind: CONTROLLER, Channel: 2, Key: 1, Value: 127, Time: start},
+		{Kind: NOTE_ON, Key: 36, Value: 100, Time: start.Add(30 * time.Millisecond)},
+		{Kind: SYSTEM, Channel: SYSEX, Data: []byte{0x7e, 0x7f, 0x06, 0x01}, Time: start.Add(40 * time.Millisecond)},
+		{Kind: NOTE_OFF, Key: 36, Time: start.Add(60 * time.Millisecond)},
+	}
+	// two frames' worth, the second slightly out of order
+	if err := recorder.Record(recorded[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Record([]*MidiMessage{recorded[3], recorded[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recording, err := ReadRecording(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Messages) != len(recorded) {
+		t.Fatalf("read %d messages, want %d", len(recording.Messages), len(recorded))
+	}
+	for ii, rm := range recording.Messages {
+		want := recorded[ii]
+		if rm.Message.String() != want.String() || string(rm.Message.Data) != string(want.Data) {
+			t.Errorf("message %d is %v, want %v", ii, rm.Message, want)
+		}
+		if offset := want.Time.Sub(start); rm.Offset-offset > time.Microsecond || offset-rm.Offset > time.Microsecond {
+			t.Errorf("message %d is at %v, want %v", ii, rm.Offset, offset)
+		}
+	}
+
+	// replay keeps the timing, and the state ends up where it was
+	replayStart := time.Now()
+	state := MidiState{}
+	for m := range GetMidiReplayStream(recording, false) {
+		state.UpdateStateFromSlice([]*MidiMessage{m})
+	}
+	if elapsed := time.Since(replayStart); elapsed < 60*time.Millisecond {
+		t.Errorf("replay took %v, want at least 60ms", elapsed)
+	}
+	if state.Channels[2].ControllerValues[1] != 127 || state.KeyVolumes[36] != 0 {
+		t.Errorf("replayed state is wrong")
+	}
+}
+
+func TestParseMidiFile(t *testing.T) {
+	data := []byte{
+		'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 1, 0, 2, 0, 96, // format 1, 2 tracks, 96 ticks per quarter note
+		// tempo track: 120 bpm, then 240 bpm after one quarter note, and the end after two
+		'M', 'T', 'r', 'k', 0, 0, 0, 18,
+		0x00, 0xff, 0x51, 3, 0x07, 0xa1, 0x20,
+		0x60, 0xff, 0x51, 3, 0x03, 0xd0, 0x90,
+		0x60, 0xff, 0x2f, 0,
+		// an unknown chunk to skip
+		'X', 'X', 'X', 'X', 0, 0, 0, 2, 1, 2,
+		// notes, using running status
+		'M', 'T', 'r', 'k', 0, 0, 0, 21,
+		0x00, 0x90, 60, 100,
+		0x60, 60, 0, // note off as a running status note on at tick 96
+		0x30, 0xf0, 3, 0x41, 0x42, 0xf7, // sysex at tick 144
+		0x00, 0xb1, 1, 64,
+		0x00, 0xff, 0x2f, 0,
+	}
+	song, err := ParseMidiFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RecordedMessage{
+		{0, &MidiMessage{Kind: NOTE_ON, Key: 60, Value: 100}},
+		{500 * time.Millisecond, &MidiMessage{Kind: NOTE_ON, Key: 60, Value: 0}},
+		{625 * time.Millisecond, &MidiMessage{Kind: SYSTEM, Channel: SYSEX, Data: []byte{0x41, 0x42}}},
+		{625 * time.Millisecond, &MidiMessage{Kind: CONTROLLER, Channel: 1, Key: 1, Value: 64}},
+	}
+	if len(song.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(song.Messages), len(want))
+	}
+	for ii, rm := range song.Messages {
+		if rm.Message.String() != want[ii].Message.String() || string(rm.Message.Data) != string(want[ii].Message.Data) {
+			t.Errorf("message %d is %v, want %v", ii, rm.Message, want[ii].Message)
+		}
+		if diff := rm.Offset - want[ii].Offset; diff > time.Millisecond || diff < -time.Millisecond {
+			t.Errorf("message %d is at %v, want %v", ii, rm.Offset, want[ii].Offset)
+		}
+	}
+	if diff := song.Length - 750*time.Millisecond; diff > time.Millisecond || diff < -time.Millisecond {
+		t.Errorf("got length %v, want 750ms", song.Length)
+	}
+
+	if _, err := ParseMidiFile(append([]byte{}, data[:20]...)); err == nil {
+		t.Errorf("parsing a truncated file should fail")
+	}
+}
@@ -0,0 +1,154 @@
+package midi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// How many bytes per second a 31250 baud MIDI cable can carry.
+// USB controllers are faster, but their LEDs still fall behind if we flood them.
+const MIDI_BYTES_PER_SECOND = 3125
+
+//================================================================================
+// ENCODE MESSAGE OBJECTS INTO MIDI BYTES
+
+// Return the raw MIDI bytes for the message, or nil if it's a kind we can't send.
+func (m *MidiMessage) Bytes() []byte {
+	if m.Kind == SYSTEM {
+		switch {
+		case m.Channel == SYSEX:
+			bytes := append([]byte{SYSTEM + SYSEX}, m.Data...)
+			return append(bytes, SYSTEM+SYSEX_END)
+		case m.Channel >= CLOCK:
+			return []byte{SYSTEM + m.Channel}
+		}
+	}
+	status := m.Kind | m.Channel&0x0f
+	switch dataLength(status) {
+	case 0:
+		return []byte{status}
+	case 1:
+		return []byte{status, m.Key & 0x7f}
+	case 2:
+		return []byte{status, m.Key & 0x7f, m.Value & 0x7f}
+	}
+	return nil
+}
+
+// Return true if two messages set the same thing, so only the newer one needs to be sent:
+// the same note, controller, or aftertouch key, or the same channel's program, pressure, or pitch bend.
+// Note on and note off count as the same thing since they both set a pad's light.
+func sameTarget(a, b *MidiMessage) bool {
+	kindA, kindB := a.Kind, b.Kind
+	if kindA == NOTE_OFF {
+		kindA = NOTE_ON
+	}
+	if kindB == NOTE_OFF {
+		kindB = NOTE_ON
+	}
+	if kindA != kindB || a.Channel != b.Channel {
+		return false
+	}
+	switch kindA {
+	case NOTE_ON, AFTERTOUCH, CONTROLLER:
+		return a.Key == b.Key
+	case PROGRAM_CHANGE, CHANNEL_PRESSURE, PITCH_BEND:
+		return true
+	}
+	return false
+}
+
+// Add a message to the queue of messages waiting to be sent.
+// If an older message for the same target is still waiting, replace it.
+func queueMidiMessage(pending []*MidiMessage, m *MidiMessage) []*MidiMessage {
+	for ii, old := range pending {
+		if sameTarget(old, m) {
+			pending[ii] = m
+			return pending
+		}
+	}
+	return append(pending, m)
+}
+
+//================================================================================
+// WRITE MIDI MESSAGES
+
+// Read *MidiMessage structs from inCh, encode them, and write them to w no faster than
+// bytesPerSecond.
+// Messages that pile up while we wait are merged, so a pad light which changes several
+// times before we get to it is only sent once, with its newest value.
+// Return nil once inCh has been closed and everything has been written, or the
+// error if a write fails.
+func MidiOutputThread(inCh chan *MidiMessage, w io.Writer, bytesPerSecond float64) error {
+	debug("starting output thread")
+	pending := []*MidiMessage{}
+	for {
+		if len(pending) == 0 {
+			m, ok := <-inCh
+			if !ok {
+				debug("output thread is done")
+				return nil
+			}
+			pending = append(pending, m)
+		}
+
+		// pick up everything else that's waiting
+	drain:
+		for {
+			select {
+			case m, ok := <-inCh:
+				if !ok {
+					break drain
+				}
+				pending = queueMidiMessage(pending, m)
+			default:
+				break drain
+			}
+		}
+
+		m := pending[0]
+		pending = pending[1:]
+		bytes := m.Bytes()
+		if bytes == nil {
+			continue
+		}
+		if _, err := w.Write(bytes); err != nil {
+			return err
+		}
+		time.Sleep(time.Duration(float64(len(bytes)) / bytesPerSecond * float64(time.Second)))
+	}
+}
+
+// Start a thread which will write MIDI messages to the device in the background.
+// Return a channel which accepts pointers to MidiMessage structs.  Closing it stops the thread.
+// "path" should be the path to the midi device, e.g. "/dev/midi1".
+// If the path can't be opened, it will keep retrying forever until it succeeds.
+// Messages sent before then wait in the channel, so don't block on sending to it.
+func GetMidiOutputStream(path string) chan *MidiMessage {
+	midiMessageChan := make(chan *MidiMessage, 500)
+	go tenaciousFileWriterThread(path, midiMessageChan)
+	return midiMessageChan
+}
+
+// Write the messages from inCh to the given path, reopening it if a write fails.
+func tenaciousFileWriterThread(path string, inCh chan *MidiMessage) {
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			time.Sleep(time.Duration(RETRY_WAIT * time.Second))
+			fmt.Println("[midi] couldn't open midi device for writing:", path, " ... waiting and trying again")
+			continue
+		}
+		fmt.Println("[midi] successfully opened midi device for writing", path)
+
+		err = MidiOutputThread(inCh, file, MIDI_BYTES_PER_SECOND)
+		file.Close()
+		if err == nil {
+			return
+		}
+		fmt.Println("[midi] couldn't write to midi device:", path)
+		fmt.Println(err)
+	}
+}
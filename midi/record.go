@@ -0,0 +1,200 @@
+package midi
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A midi recording is a text file with one message per line: the number of seconds since
+// the recording began, then the message's bytes in hex.  Lines starting with # are comments.
+//
+//	# pixelslinger midi recording
+//	0.000000 b0 01 7f
+//	0.512345 90 24 64
+//	0.640000 80 24 00
+
+// A message from a recording and when it happened.
+type RecordedMessage struct {
+	Offset  time.Duration // time since the recording began
+	Message *MidiMessage
+}
+
+// Timed midi messages from a recording file or a midi file.
+type Recording struct {
+	Messages []RecordedMessage // in time order
+	Length   time.Duration     // where the recording ends, and where looping starts over
+}
+
+// Return the message in a slice of raw MIDI bytes, which should hold exactly one
+// complete message with its status byte.
+func MessageFromBytes(bytes []byte) (*MidiMessage, error) {
+	if len(bytes) == 0 || bytes[0] < 0x80 {
+		return nil, fmt.Errorf("% x doesn't start with a status byte", bytes)
+	}
+	status := bytes[0]
+	switch {
+	case status == SYSTEM+SYSEX:
+		if bytes[len(bytes)-1] != SYSTEM+SYSEX_END {
+			return nil, fmt.Errorf("% x: sysex doesn't end with f7", bytes)
+		}
+		return &MidiMessage{Kind: SYSTEM, Channel: SYSEX, Data: append([]byte{}, bytes[1:len(bytes)-1]...)}, nil
+	case status >= 0xf8:
+		return &MidiMessage{Kind: SYSTEM, Channel: status & 0x0f}, nil
+	}
+	length := dataLength(status)
+	if length < 0 || len(bytes) != length+1 {
+		return nil, fmt.Errorf("% x isn't a midi message we understand", bytes)
+	}
+	m := &MidiMessage{Kind: status & 0xf0, Channel: status & 0x0f}
+	if length > 0 {
+		m.Key = bytes[1]
+	}
+	if length > 1 {
+		m.Value = bytes[2]
+	}
+	return m, nil
+}
+
+//================================================================================
+// RECORD
+
+// Writes midi messages to a recording file as they happen.
+type Recorder struct {
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+}
+
+// Create a recording file and start the clock.
+func NewRecorder(fn string) (*Recorder, error) {
+	file, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{file: file, writer: bufio.NewWriter(file), start: time.Now()}
+	fmt.Fprintf(r.writer, "# pixelslinger midi recording started %s\n", r.start.Format(time.RFC3339))
+	return r, r.writer.Flush()
+}
+
+// Add messages to the recording, timed by their Time fields (or now, if they don't have one).
+// Everything is flushed to the file before returning, so a crash doesn't lose the end of the recording.
+func (r *Recorder) Record(messages []*MidiMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, m := range messages {
+		bytes := m.Bytes()
+		if bytes == nil {
+			continue
+		}
+		t := m.Time
+		if t.IsZero() {
+			t = now
+		}
+		fmt.Fprintf(r.writer, "%.6f % x\n", t.Sub(r.start).Seconds(), bytes)
+	}
+	return r.writer.Flush()
+}
+
+func (r *Recorder) Close() error {
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+//================================================================================
+// REPLAY
+
+// Read a recording file.  It ends with its last message.
+func ReadRecording(fn string) (*Recording, error) {
+	file, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	recording := []RecordedMessage{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // sysex can make long lines
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad time %q", fn, lineNumber, fields[0])
+		}
+		bytes, err := hex.DecodeString(strings.Join(fields[1:], ""))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fn, lineNumber, err)
+		}
+		m, err := MessageFromBytes(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fn, lineNumber, err)
+		}
+		offset := time.Duration(seconds * float64(time.Second))
+		recording = append(recording, RecordedMessage{Offset: offset, Message: m})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	// messages merged from several devices can be slightly out of order
+	sort.SliceStable(recording, func(i, j int) bool { return recording[i].Offset < recording[j].Offset })
+	length := time.Duration(0)
+	if len(recording) > 0 {
+		length = recording[len(recording)-1].Offset
+	}
+	return &Recording{Messages: recording, Length: length}, nil
+}
+
+// Send the recorded messages over outCh with their original timing, starting now.
+// Each message is a copy whose Time is when it was sent.
+// If loop is true, start over at the end of the recording forever.  Otherwise close outCh at the end.
+func ReplayThread(recording *Recording, loop bool, outCh chan *MidiMessage) {
+	start := time.Now()
+	for {
+		for _, rm := range recording.Messages {
+			if wait := time.Until(start.Add(rm.Offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+			m := *rm.Message
+			m.Time = time.Now()
+			outCh <- &m
+		}
+		if !loop {
+			break
+		}
+		// a recording with no length would spin
+		length := recording.Length
+		if length < time.Second/100 {
+			length = time.Second / 100
+		}
+		start = start.Add(length)
+		if wait := time.Until(start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	fmt.Println("[midi] replay finished")
+	close(outCh)
+}
+
+// Start a thread which replays a recording in the background, looping it if loop is true.
+// Return a channel which emits pointers to MidiMessage structs, like GetMidiMessageStream.
+func GetMidiReplayStream(recording *Recording, loop bool) chan *MidiMessage {
+	midiMessageChan := make(chan *MidiMessage, 500)
+	go ReplayThread(recording, loop, midiMessageChan)
+	return midiMessageChan
+}
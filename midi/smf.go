@@ -0,0 +1,249 @@
+package midi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Meta events in a standard midi file which we pay attention to.
+const (
+	META_END_OF_TRACK byte = 0x2f
+	META_TEMPO        byte = 0x51 // microseconds per quarter note, in 3 bytes
+)
+
+// Tempo of a standard midi file until it says otherwise: 120 bpm.
+const DEFAULT_MICROSECONDS_PER_QUARTER = 500000
+
+// A midi message or a tempo change at some tick of a midi file.
+type smfEvent struct {
+	tick    int
+	track   int
+	order   int          // position within the track, so events at the same tick keep their order
+	message *MidiMessage // nil for tempo changes
+	tempo   int          // microseconds per quarter note
+}
+
+// Read a standard midi file (.mid) of format 0 or 1.
+// Its channel messages and SysEx are timed according to its tempo map.  Other meta
+// events are left out.  The recording's length is the end of the longest track.
+func ReadMidiFile(fn string) (*Recording, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	recording, err := ParseMidiFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return recording, nil
+}
+
+// Parse the bytes of a standard midi file.  See ReadMidiFile.
+func ParseMidiFile(data []byte) (*Recording, error) {
+	if len(data) < 14 || string(data[:4]) != "MThd" {
+		return nil, fmt.Errorf("not a standard midi file")
+	}
+	headerLength := int(binary.BigEndian.Uint32(data[4:8]))
+	if headerLength < 6 || 8+headerLength > len(data) {
+		return nil, fmt.Errorf("bad header length %d", headerLength)
+	}
+	format := binary.BigEndian.Uint16(data[8:10])
+	nTracks := int(binary.BigEndian.Uint16(data[10:12]))
+	division := binary.BigEndian.Uint16(data[12:14])
+	if format > 1 {
+		return nil, fmt.Errorf("format %d isn't supported, only 0 and 1", format)
+	}
+	if division == 0 || (division&0x8000 != 0 && (division&0xff == 0 || int8(division>>8) >= 0)) {
+		return nil, fmt.Errorf("bad time division %#x", division)
+	}
+
+	// read the tracks, skipping any other kinds of chunks
+	events := []smfEvent{}
+	endTick := 0
+	pos := 8 + headerLength
+	track := 0
+	for track < nTracks && pos+8 <= len(data) {
+		chunkType := string(data[pos : pos+4])
+		chunkLength := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		if chunkLength < 0 || pos+chunkLength > len(data) {
+			return nil, fmt.Errorf("chunk %q at byte %d runs past the end of the file", chunkType, pos-8)
+		}
+		if chunkType == "MTrk" {
+			trackEvents, trackEnd, err := parseTrack(data[pos:pos+chunkLength], track)
+			if err != nil {
+				return nil, fmt.Errorf("track %d: %v", track, err)
+			}
+			events = append(events, trackEvents...)
+			if trackEnd > endTick {
+				endTick = trackEnd
+			}
+			track++
+		}
+		pos += chunkLength
+	}
+	if track < nTracks {
+		return nil, fmt.Errorf("found %d of %d tracks", track, nTracks)
+	}
+
+	// merge the tracks
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.tick != b.tick {
+			return a.tick < b.tick
+		}
+		if a.track != b.track {
+			return a.track < b.track
+		}
+		return a.order < b.order
+	})
+
+	// convert ticks to time, following the tempo changes
+	tickDuration := func(tempo int) time.Duration {
+		if division&0x8000 != 0 {
+			// SMPTE: frames per second in the high byte as a negative number, ticks per frame in the low byte
+			framesPerSecond := -int(int8(division >> 8))
+			ticksPerFrame := int(division & 0xff)
+			if framesPerSecond == 29 {
+				return time.Duration(float64(time.Second) / (29.97 * float64(ticksPerFrame)))
+			}
+			return time.Second / time.Duration(framesPerSecond*ticksPerFrame)
+		}
+		return time.Duration(tempo) * time.Microsecond / time.Duration(division)
+	}
+	recording := &Recording{}
+	tempo := DEFAULT_MICROSECONDS_PER_QUARTER
+	lastTick := 0
+	offset := time.Duration(0)
+	for _, event := range events {
+		offset += time.Duration(event.tick-lastTick) * tickDuration(tempo)
+		lastTick = event.tick
+		if event.message == nil {
+			tempo = event.tempo
+			continue
+		}
+		recording.Messages = append(recording.Messages, RecordedMessage{Offset: offset, Message: event.message})
+	}
+	recording.Length = offset + time.Duration(endTick-lastTick)*tickDuration(tempo)
+	return recording, nil
+}
+
+// Return the events in one track chunk and the tick where the track ends.
+func parseTrack(data []byte, track int) ([]smfEvent, int, error) {
+	events := []smfEvent{}
+	pos := 0
+	tick := 0
+	var status byte // for running status
+
+	// read a variable length quantity: 7 bits per byte, high bit set on all but the last byte
+	readVLQ := func() (int, error) {
+		value := 0
+		for ii := 0; ii < 4; ii++ {
+			if pos >= len(data) {
+				return 0, fmt.Errorf("ran out of data at byte %d", pos)
+			}
+			b := data[pos]
+			pos++
+			value = value<<7 | int(b&0x7f)
+			if b < 0x80 {
+				return value, nil
+			}
+		}
+		return 0, fmt.Errorf("variable length number is too long at byte %d", pos)
+	}
+	// read n bytes
+	readBytes := func(n int) ([]byte, error) {
+		if n < 0 || pos+n > len(data) {
+			return nil, fmt.Errorf("ran out of data at byte %d", pos)
+		}
+		b := data[pos : pos+n]
+		pos += n
+		return b, nil
+	}
+
+	for pos < len(data) {
+		delta, err := readVLQ()
+		if err != nil {
+			return nil, 0, err
+		}
+		tick += delta
+		if pos >= len(data) {
+			return nil, 0, fmt.Errorf("ran out of data at byte %d", pos)
+		}
+
+		b := data[pos]
+		switch {
+		case b == 0xff:
+			// meta event
+			pos++
+			kind, err := readBytes(1)
+			if err != nil {
+				return nil, 0, err
+			}
+			length, err := readVLQ()
+			if err != nil {
+				return nil, 0, err
+			}
+			body, err := readBytes(length)
+			if err != nil {
+				return nil, 0, err
+			}
+			switch kind[0] {
+			case META_TEMPO:
+				if length != 3 {
+					return nil, 0, fmt.Errorf("tempo event with %d bytes at tick %d", length, tick)
+				}
+				tempo := int(body[0])<<16 | int(body[1])<<8 | int(body[2])
+				events = append(events, smfEvent{tick: tick, track: track, order: len(events), tempo: tempo})
+			case META_END_OF_TRACK:
+				return events, tick, nil
+			}
+
+		case b == SYSTEM+SYSEX || b == SYSTEM+SYSEX_END:
+			// sysex, or an escape holding any bytes at all, which we skip
+			pos++
+			length, err := readVLQ()
+			if err != nil {
+				return nil, 0, err
+			}
+			body, err := readBytes(length)
+			if err != nil {
+				return nil, 0, err
+			}
+			if b == SYSTEM+SYSEX {
+				if length > 0 && body[length-1] == SYSTEM+SYSEX_END {
+					body = body[:length-1]
+				}
+				m := &MidiMessage{Kind: SYSTEM, Channel: SYSEX, Data: append([]byte{}, body...)}
+				events = append(events, smfEvent{tick: tick, track: track, order: len(events), message: m})
+			}
+			status = 0
+
+		default:
+			// channel message, perhaps with running status
+			if b >= 0x80 {
+				if b >= SYSTEM {
+					return nil, 0, fmt.Errorf("unexpected status byte %#x at byte %d", b, pos)
+				}
+				status = b
+				pos++
+			} else if status == 0 {
+				return nil, 0, fmt.Errorf("data byte %#x without a status at byte %d", b, pos)
+			}
+			body, err := readBytes(dataLength(status))
+			if err != nil {
+				return nil, 0, err
+			}
+			m := &MidiMessage{Kind: status & 0xf0, Channel: status & 0x0f, Key: body[0]}
+			if len(body) > 1 {
+				m.Value = body[1]
+			}
+			events = append(events, smfEvent{tick: tick, track: track, order: len(events), message: m})
+		}
+	}
+	// a track without an end of track event ends at its last event
+	return events, tick, nil
+}
@@ -0,0 +1,161 @@
+package opc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestBlendPixel(t *testing.T) {
+	// a dim orange below, a blue layer above
+	tests := []struct {
+		mode    string
+		r, g, b float64
+	}{
+		{BLEND_NORMAL, 0, 51, 204},
+		{BLEND_ADD, 204, 153, 204},
+		{BLEND_MULTIPLY, 0, 20.4, 0},
+		{BLEND_SCREEN, 204, 132.6, 204},
+		{BLEND_MAX, 204, 102, 204},
+		{BLEND_LIGHTEN, 204, 102, 0}, // the pixel below is brighter overall
+	}
+	for _, tt := range tests {
+		r, g, b := blendPixel(tt.mode, 204, 102, 0, 0, 51, 204)
+		if math.Abs(r-tt.r) > 1e-9 || math.Abs(g-tt.g) > 1e-9 || math.Abs(b-tt.b) > 1e-9 {
+			t.Errorf("%s: got %v %v %v, want %v %v %v", tt.mode, r, g, b, tt.r, tt.g, tt.b)
+		}
+	}
+	// lighten takes the layer when it's brighter overall
+	if r, g, b := blendPixel(BLEND_LIGHTEN, 0, 0, 255, 255, 255, 0); r != 255 || g != 255 || b != 0 {
+		t.Errorf("lighten: got %v %v %v, want the layer", r, g, b)
+	}
+	if err := CheckBlendMode("overlay"); err == nil {
+		t.Error("no error for an unknown blend mode")
+	}
+}
+
+func TestParsePixelGroup(t *testing.T) {
+	tests := []struct {
+		ranges  []string
+		want    string // x for each pixel in the group
+		wantErr bool
+	}{
+		{[]string{"0-2"}, "xxx...", false},
+		{[]string{"1", " 4-5 "}, ".x..xx", false},
+		{[]string{"4-10"}, "....xx", false}, // clipped to the layout
+		{[]string{"3-1"}, "......", false},  // backwards is empty
+		{nil, "......", false},
+		{[]string{"one"}, "", true},
+		{[]string{"1-"}, "", true},
+	}
+	for _, tt := range tests {
+		inGroup, err := parsePixelGroup(tt.ranges, 6)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePixelGroup(%q) error = %v, want error %v", tt.ranges, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		got := ""
+		for _, in := range inGroup {
+			if in {
+				got += "x"
+			} else {
+				got += "."
+			}
+		}
+		if got != tt.want {
+			t.Errorf("parsePixelGroup(%q) = %s, want %s", tt.ranges, got, tt.want)
+		}
+	}
+}
+
+func TestMakeMask(t *testing.T) {
+	savedGroups := config.SHOW.Groups
+	defer func() { config.SHOW.Groups = savedGroups }()
+	config.SHOW.Groups = map[string][]string{"ends": {"0", "4"}}
+
+	// five pixels along x from 0 to 1
+	locations := []float64{0, 0, 0, 0.25, 0, 0, 0.5, 0, 0, 0.75, 0, 0, 1, 0, 0}
+	tests := []struct {
+		mask    *config.MaskConfig
+		want    []float64
+		wantErr bool
+	}{
+		{nil, []float64{1, 1, 1, 1, 1}, false},
+		{&config.MaskConfig{Group: "ends"}, []float64{1, 0, 0, 0, 1}, false},
+		{&config.MaskConfig{Group: "ends", Invert: true}, []float64{0, 1, 1, 1, 0}, false},
+		{&config.MaskConfig{Axis: "x", Min: 0.25, Max: 0.5}, []float64{0, 1, 1, 0, 0}, false},
+		{&config.MaskConfig{Axis: "x", Min: 0.5, Max: 1, Softness: 0.5}, []float64{0, 0, 0.5, 1, 0.5}, false}, // fades across each end
+		{&config.MaskConfig{Group: "middle"}, nil, true},
+		{&config.MaskConfig{Axis: "w"}, nil, true},
+		{&config.MaskConfig{}, nil, true},
+	}
+	for _, tt := range tests {
+		mask, err := makeMask(tt.mask, locations)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("makeMask(%+v) error = %v, want error %v", tt.mask, err, tt.wantErr)
+			continue
+		}
+		for ii := range tt.want {
+			if math.Abs(mask[ii]-tt.want[ii]) > 1e-9 {
+				t.Errorf("makeMask(%+v) = %v, want %v", tt.mask, mask, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestComposeRender(t *testing.T) {
+	savedCompose := config.SHOW.Compose
+	savedOpacity := PARAMS.save(layerOpacityParam(1))
+	defer func() {
+		config.SHOW.Compose = savedCompose
+		PARAMS.restore(savedOpacity)
+	}()
+	config.SHOW.Compose.Layers = []config.LayerConfig{
+		{Pattern: "white"},
+		{Pattern: "off", Blend: BLEND_NORMAL, Mask: &config.MaskConfig{Axis: "x", Min: 0, Max: 0.4}},
+		{Pattern: "compose"}, // skipped
+	}
+	p := NewPatternCompose()
+	p.Init([]float64{0, 0, 0, 1, 0, 0})
+	PARAMS.Set(layerOpacityParam(1), 0.5)
+	bytes := make([]byte, 6)
+	p.Render(bytes, 0, 0, &midi.MidiState{})
+	// white with its knobs at zero is red; the black layer covers half of the first pixel only
+	if string(bytes) != string([]byte{128, 0, 0, 255, 0, 0}) {
+		t.Errorf("got %v", bytes)
+	}
+	colors := make([]float32, 6)
+	p.(FloatPattern).RenderFloat(colors, 0, 0, &midi.MidiState{})
+	if colors[0] != 0.5 || colors[1] != 0 || colors[3] != 1 {
+		t.Errorf("got %v in high precision, want [0.5 0 0 1 0 0]", colors)
+	}
+	ClosePattern(p)
+}
+
+func TestCheckContained(t *testing.T) {
+	tests := []struct {
+		outer, inner string
+		ok           bool
+	}{
+		{"midi-switcher", "fire", true},
+		{"midi-switcher", "compose", false},
+		{"compose", "midi-switcher", true},
+		{"compose", "compose", false},
+		{"playlist", "compose", true},
+		{"playlist", "schedule", false},
+		{"schedule", "playlist", true},
+		{"schedule", "schedule", false},
+		{"compose", "nothing", false},
+	}
+	for _, tt := range tests {
+		if err := checkContained(tt.outer, tt.inner); (err == nil) != tt.ok {
+			t.Errorf("checkContained(%s, %s) = %v, want ok %v", tt.outer, tt.inner, err, tt.ok)
+		}
+	}
+}
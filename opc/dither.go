@@ -0,0 +1,48 @@
+package opc
+
+// Temporal dithering
+//   LEDs only have so many levels per channel: 256 over OPC and 128 on the LPD8806,
+//   and after gamma correction only a handful of those are near black.  Rounding
+//   each frame to the nearest level makes slow fades and dim colors step.
+//
+//   A ditherer rounds each channel and carries the rounding error into the next
+//   frame instead, so a color between two levels flickers between them too fast
+//   to see and averages out to the right brightness.
+
+import (
+	"math"
+)
+
+// Error diffusion over time for one frame's worth of channels.
+type ditherer struct {
+	errors []float64 // channel --> rounding error carried to the next frame, in levels
+}
+
+// Get ready for a frame of n channels.
+func (d *ditherer) resize(n int) {
+	if len(d.errors) != n {
+		d.errors = make([]float64, n)
+	}
+}
+
+// Return value, from 0 to 1, as a whole number of levels from 0 to maxLevel for channel ii.
+// Channels which are fully off or fully on forget their error so that black stays black.
+func (d *ditherer) quantize(ii int, value float64, maxLevel int) int {
+	if value <= 0 {
+		d.errors[ii] = 0
+		return 0
+	}
+	if value >= 1 {
+		d.errors[ii] = 0
+		return maxLevel
+	}
+	v := value*float64(maxLevel) + d.errors[ii]
+	level := int(math.Floor(v + 0.5))
+	if level < 0 {
+		level = 0
+	} else if level > maxLevel {
+		level = maxLevel
+	}
+	d.errors[ii] = v - float64(level)
+	return level
+}
@@ -0,0 +1,33 @@
+package opc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDither(t *testing.T) {
+	const FRAMES = 1000
+	d := &ditherer{}
+	values := []float64{0, 0.0013, 0.3, 0.5, 0.9991, 1}
+	sums := make([]int, len(values))
+	for frame := 0; frame < FRAMES; frame++ {
+		d.resize(len(values))
+		for ii, v := range values {
+			level := d.quantize(ii, v, 127)
+			if level < 0 || level > 127 {
+				t.Fatalf("value %v: level %v out of range", v, level)
+			}
+			sums[ii] += level
+		}
+	}
+	for ii, v := range values {
+		// over many frames the levels average out to the value
+		average := float64(sums[ii]) / FRAMES / 127
+		if math.Abs(average-v) > 1.0/FRAMES {
+			t.Errorf("value %v: averaged %v", v, average)
+		}
+	}
+	if sums[0] != 0 {
+		t.Errorf("black lit up %d times", sums[0])
+	}
+}
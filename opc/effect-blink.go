@@ -0,0 +1,102 @@
+package opc
+
+// Blink effects
+//   blink-arch: when its pad is let go, a ring sweeps outward from the middle
+//   of the layout.  Harder hits make it move faster.
+//   blink-back: when its pad is let go, a beam sweeps around the middle like
+//   a lighthouse.
+
+import (
+	"math"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+var BLINK_ARCH_PARAMS = []*Param{
+	{"blink-arch.duration", PARAM_FLOAT, 0, 5, 1.2, "How long the blink-arch ring takes to sweep outward, in seconds."},
+}
+
+var BLINK_BACK_PARAMS = []*Param{
+	{"blink-back.duration", PARAM_FLOAT, 0, 5, 1.2, "How long the blink-back beam takes to sweep around, in seconds."},
+}
+
+type effectBlinkArch struct {
+	pad            *config.Control
+	locations      []float64
+	maxX           float64
+	lastRadialTime float64
+}
+
+func NewEffectBlinkArch() Effect {
+	return &effectBlinkArch{pad: config.BLINK_ARCH_PAD}
+}
+
+func (e *effectBlinkArch) Init(locations []float64) {
+	e.locations = locations
+	_, maxx := boundingBox(locations)
+	e.maxX = maxx[0]
+}
+
+func (e *effectBlinkArch) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	blinkDuration := PARAMS.Get("blink-arch.duration", midiState)
+	blinkArchPad := e.pad.Value(midiState)
+	if blinkArchPad > 0 {
+		e.lastRadialTime = t
+	}
+	radialLeft := math.Pow(t-e.lastRadialTime, 0.5-(0.5*blinkArchPad))
+	if radialLeft > blinkDuration || radialLeft <= 0 {
+		return
+	}
+
+	rad_const := e.maxX * radialLeft
+	for ii := 0; ii < len(colors)/3 && ii*3+2 < len(e.locations); ii++ {
+		x := e.locations[ii*3+0]
+		z := e.locations[ii*3+2]
+		rad := math.Sqrt(x*x + z*z)
+		radial_amount := 1 - math.Pow(math.Abs(rad_const-rad), 0.8)
+		if radial_amount > 0.9 {
+			colors[ii*3+0] = radial_amount
+			colors[ii*3+1] = radial_amount
+			colors[ii*3+2] = radial_amount
+		}
+	}
+}
+
+type effectBlinkBack struct {
+	pad           *config.Control
+	locations     []float64
+	lastSweepTime float64
+}
+
+func NewEffectBlinkBack() Effect {
+	return &effectBlinkBack{pad: config.BLINK_BACK_PAD}
+}
+
+func (e *effectBlinkBack) Init(locations []float64) {
+	e.locations = locations
+}
+
+func (e *effectBlinkBack) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	blinkDuration := PARAMS.Get("blink-back.duration", midiState)
+	if e.pad.Value(midiState) > 0 {
+		e.lastSweepTime = t
+	}
+	sweepLeft := t - e.lastSweepTime
+	if sweepLeft > blinkDuration || sweepLeft <= 0 {
+		return
+	}
+
+	theta_const := math.Pi * sweepLeft * 2
+	for ii := 0; ii < len(colors)/3 && ii*3+2 < len(e.locations); ii++ {
+		x := e.locations[ii*3+0]
+		z := e.locations[ii*3+2]
+		theta := math.Atan2(z, x)
+		sweep_amount := 1 - math.Abs(theta_const-theta)
+		if sweep_amount > 0.5 {
+			colors[ii*3+0] = sweep_amount
+			colors[ii*3+1] = sweep_amount
+			colors[ii*3+2] = sweep_amount
+		}
+	}
+}
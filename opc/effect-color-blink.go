@@ -0,0 +1,65 @@
+package opc
+
+// Color blink effects
+//   blink-red, blink-green, blink-blue: while the pad is down, wash the frame
+//   in that color channel, as strongly as the pad was hit.  The wash fades out
+//   after the pad is let go.
+
+import (
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Return the params for the color blink effect called name.
+func colorBlinkParams(name string) []*Param {
+	return []*Param{
+		{name + ".duration", PARAM_FLOAT, 0, 2, 6.0 / 40.0, "How long the " + name + " wash takes to fade after its pad is released, in seconds."},
+	}
+}
+
+var (
+	BLINK_RED_PARAMS   = colorBlinkParams("blink-red")
+	BLINK_GREEN_PARAMS = colorBlinkParams("blink-green")
+	BLINK_BLUE_PARAMS  = colorBlinkParams("blink-blue")
+)
+
+type effectColorBlink struct {
+	pad           *config.Control
+	channel       int    // 0, 1, or 2 for red, green, or blue
+	durationParam string // name of the param for how long the wash fades
+	lastDownTime  float64
+	lastVelocity  float64
+}
+
+func NewEffectBlinkRed() Effect {
+	return &effectColorBlink{pad: config.BLINK_RED_PAD, channel: 0, durationParam: BLINK_RED_PARAMS[0].Name}
+}
+
+func NewEffectBlinkGreen() Effect {
+	return &effectColorBlink{pad: config.BLINK_GREEN_PAD, channel: 1, durationParam: BLINK_GREEN_PARAMS[0].Name}
+}
+
+func NewEffectBlinkBlue() Effect {
+	return &effectColorBlink{pad: config.BLINK_BLUE_PAD, channel: 2, durationParam: BLINK_BLUE_PARAMS[0].Name}
+}
+
+func (e *effectColorBlink) Init(locations []float64) {}
+
+func (e *effectColorBlink) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	COLOR_BLINK_DURATION := PARAMS.Get(e.durationParam, midiState)
+	if pad := e.pad.Value(midiState); pad > 0 {
+		e.lastDownTime = t
+		e.lastVelocity = pad
+	}
+	amount := e.lastVelocity
+	if t > e.lastDownTime {
+		amount *= colorutils.Clamp(colorutils.Remap(t-e.lastDownTime, 0, COLOR_BLINK_DURATION, 1, 0), 0, 1)
+	}
+	if amount <= 0 {
+		return
+	}
+	for ii := e.channel; ii < len(colors); ii += 3 {
+		colors[ii] = colors[ii]*(1-amount) + amount
+	}
+}
@@ -0,0 +1,33 @@
+package opc
+
+// Desaturation effect
+//   Listen to the desat knob and wash the colors out towards gray.
+
+import (
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+type effectDesat struct {
+	knob *config.Control
+}
+
+func NewEffectDesat() Effect {
+	return &effectDesat{knob: config.DESAT_KNOB}
+}
+
+func (e *effectDesat) Init(locations []float64) {}
+
+func (e *effectDesat) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	desatKnob := e.knob.Value(midiState)
+	if desatKnob == 0 {
+		return
+	}
+	for ii := 0; ii+2 < len(colors); ii += 3 {
+		r, g, b := colors[ii+0], colors[ii+1], colors[ii+2]
+		gray := (r + g + b) / 3.0 * 1.3 // boost it a little bit
+		colors[ii+0] = r*(1-desatKnob) + gray*desatKnob
+		colors[ii+1] = g*(1-desatKnob) + gray*desatKnob
+		colors[ii+2] = b*(1-desatKnob) + gray*desatKnob
+	}
+}
@@ -0,0 +1,62 @@
+package opc
+
+// Color extract effect
+//   Listen to the extract knob, which picks a hue, and wash out every color
+//   except the ones near that hue, like a film with one color left in.
+//   The knob's bottom position turns the effect off.
+//
+//   This isn't one of the DEFAULT_EFFECTS because the extract knob is the LPD8's
+//   knob 8, which shows have been binding to params with --bind.  Add it to the
+//   show's effects to use the knob.
+
+import (
+	"math"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+var EXTRACT_PARAMS = []*Param{
+	{"extract.width", PARAM_FLOAT, 0, 0.5, 0.08, "How far from the chosen hue colors are kept by the extract knob, as a fraction of the color wheel."},
+	{"extract.softness", PARAM_FLOAT, 0, 0.5, 0.05, "Width of the fade between kept and washed out colors, as a fraction of the color wheel."},
+}
+
+// The extract knob does nothing below this position.
+const EXTRACT_OFF = 0.02
+
+type effectExtract struct {
+	knob *config.Control
+}
+
+func NewEffectExtract() Effect {
+	return &effectExtract{knob: config.EXTRACT_KNOB}
+}
+
+func (e *effectExtract) Init(locations []float64) {}
+
+func (e *effectExtract) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	extractKnob := e.knob.Value(midiState)
+	if extractKnob < EXTRACT_OFF {
+		return
+	}
+	EXTRACT_WIDTH := PARAMS.Get("extract.width", midiState)
+	EXTRACT_SOFTNESS := PARAMS.Get("extract.softness", midiState)
+
+	// the knob's travel above EXTRACT_OFF goes once around the color wheel, starting at red.
+	// Oklab's hue angle for pure red is about 0.08 of the way around.
+	target := colorutils.Remap(extractKnob, EXTRACT_OFF, 1, 0, 1) + 0.08
+	for ii := 0; ii+2 < len(colors); ii += 3 {
+		L, a, b := colorutils.RgbToOklab(colors[ii+0], colors[ii+1], colors[ii+2])
+		hue := math.Atan2(b, a) / (2 * math.Pi)
+		distance := colorutils.ModDist2(hue, target, 1)
+		keep := 0.0
+		switch {
+		case distance <= EXTRACT_WIDTH:
+			continue
+		case distance < EXTRACT_WIDTH+EXTRACT_SOFTNESS:
+			keep = 1 - (distance-EXTRACT_WIDTH)/EXTRACT_SOFTNESS
+		}
+		colors[ii+0], colors[ii+1], colors[ii+2] = colorutils.OklabToRgb(L, a*keep, b*keep)
+	}
+}
@@ -0,0 +1,48 @@
+package opc
+
+// Eyelid effect
+//   Listen to the eyelid knob and close the pattern from the top down,
+//   with a soft edge.
+
+import (
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+var EYELID_PARAMS = []*Param{
+	{"eyelid.blend", PARAM_FLOAT, 0, 1, 0.25, "Size of the eyelid gradient relative to the entire bounding box."},
+}
+
+type effectEyelid struct {
+	knob *config.Control
+	zp   []float64 // each pixel's height from 0 to 1 in the bounding box
+}
+
+func NewEffectEyelid() Effect {
+	return &effectEyelid{knob: config.EYELID_KNOB}
+}
+
+func (e *effectEyelid) Init(locations []float64) {
+	minn, maxx := boundingBox(locations)
+	e.zp = make([]float64, len(locations)/3)
+	for ii := range e.zp {
+		e.zp[ii] = colorutils.Remap(locations[ii*3+2], minn[2], maxx[2], 0, 1)
+	}
+}
+
+func (e *effectEyelid) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	EYELID_BLEND := PARAMS.Get("eyelid.blend", midiState)
+	eyelidKnob := e.knob.Value(midiState)
+	eyelidKnob = colorutils.Clamp(colorutils.Remap(eyelidKnob, 0.05, 0.95, 0, 1), 0, 1)
+
+	for ii := 0; ii < len(colors)/3 && ii < len(e.zp); ii++ {
+		zp := e.zp[ii]
+		eyelid := colorutils.Clamp(colorutils.Remap(zp,
+			eyelidKnob-(1-zp)*EYELID_BLEND/2,
+			eyelidKnob+zp*EYELID_BLEND/2, 1, 0), 0, 1)
+		colors[ii*3+0] *= eyelid
+		colors[ii*3+1] *= eyelid
+		colors[ii*3+2] *= eyelid
+	}
+}
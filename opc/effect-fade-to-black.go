@@ -0,0 +1,41 @@
+package opc
+
+// Fade to black effect
+//   While the fade to black pad is held down, fade the whole frame out.
+//   Letting go brings it straight back.
+
+import (
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+var FADE_TO_BLACK_PARAMS = []*Param{
+	{"fade-to-black.time", PARAM_FLOAT, 0, 10, 15.0 / 40.0, "How long the fade to black pad takes, in seconds."},
+}
+
+type effectFadeToBlack struct {
+	pad                  *config.Control
+	fadeToBlackBeginTime float64
+}
+
+func NewEffectFadeToBlack() Effect {
+	return &effectFadeToBlack{pad: config.FADE_TO_BLACK_PAD}
+}
+
+func (e *effectFadeToBlack) Init(locations []float64) {}
+
+func (e *effectFadeToBlack) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	FADE_TO_BLACK_TIME := PARAMS.Get("fade-to-black.time", midiState)
+	if e.pad.Pressed(midiState) {
+		// pad has just gone down
+		e.fadeToBlackBeginTime = t
+	}
+	if e.pad.Value(midiState) <= 0 {
+		return
+	}
+	fadeToBlackAmount := 1 - colorutils.Clamp((t-e.fadeToBlackBeginTime)/FADE_TO_BLACK_TIME, 0, 1)
+	for ii := range colors {
+		colors[ii] *= fadeToBlackAmount
+	}
+}
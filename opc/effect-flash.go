@@ -0,0 +1,68 @@
+package opc
+
+// Lightning flash effect
+//   When the flash pad goes down, light every pixel pale blue and let each one
+//   fade back to the pattern at its own random speed.
+//
+//   This isn't one of the DEFAULT_EFFECTS because the fader it came from never
+//   fired it, and shows are used to the flash pad (the LPD8's pad 1) doing
+//   nothing.  Add it to the show's effects to turn it on.
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+var FLASH_PARAMS = []*Param{
+	{"flash.duration-min", PARAM_FLOAT, 0, 2, 2.0 / 40.0, "Shortest lightning flash, in seconds."},
+	{"flash.duration-max", PARAM_FLOAT, 0, 2, 10.0 / 40.0, "Longest lightning flash, in seconds."},
+}
+
+const (
+	FLASH_R            = 0.6
+	FLASH_G            = 0.84
+	FLASH_B            = 1.00
+	FLASH_DURATION_EXP = 5.0 // exponent for random duration
+)
+
+type effectFlash struct {
+	pad           *config.Control
+	randomValues  []float64 // each pixel's place between the shortest and longest flash
+	lastFlashTime float64
+}
+
+func NewEffectFlash() Effect {
+	return &effectFlash{pad: config.FLASH_PAD}
+}
+
+func (e *effectFlash) Init(locations []float64) {
+	// make persistant random values
+	rng := rand.New(rand.NewSource(99))
+	e.randomValues = make([]float64, len(locations)/3)
+	for ii := range e.randomValues {
+		e.randomValues[ii] = math.Pow(rng.Float64(), FLASH_DURATION_EXP)
+	}
+}
+
+func (e *effectFlash) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	FLASH_DURATION_MIN := PARAMS.Get("flash.duration-min", midiState)
+	FLASH_DURATION_MAX := PARAMS.Get("flash.duration-max", midiState)
+	if e.pad.Pressed(midiState) {
+		e.lastFlashTime = t
+	}
+
+	for ii := 0; ii < len(colors)/3 && ii < len(e.randomValues); ii++ {
+		duration := FLASH_DURATION_MIN + e.randomValues[ii]*(FLASH_DURATION_MAX-FLASH_DURATION_MIN)
+		flashAmt := colorutils.Clamp(colorutils.Remap(t-e.lastFlashTime, 0, duration, 1, 0), 0, 1)
+		if flashAmt == 0 {
+			continue
+		}
+		colors[ii*3+0] = colors[ii*3+0]*(1-flashAmt) + flashAmt*FLASH_R
+		colors[ii*3+1] = colors[ii*3+1]*(1-flashAmt) + flashAmt*FLASH_G
+		colors[ii*3+2] = colors[ii*3+2]*(1-flashAmt) + flashAmt*FLASH_B
+	}
+}
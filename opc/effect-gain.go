@@ -0,0 +1,41 @@
+package opc
+
+// Gain effect
+//   Listen to the gain knob and fade the entire pattern to black.
+//   Fade half the pixels to black first, then a quarter, then the rest,
+//   so the pattern stays visible until the knob is nearly all the way down.
+
+import (
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+type effectGain struct {
+	knob *config.Control
+}
+
+func NewEffectGain() Effect {
+	return &effectGain{knob: config.GAIN_KNOB}
+}
+
+func (e *effectGain) Init(locations []float64) {}
+
+func (e *effectGain) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	gainKnob := e.knob.Value(midiState)
+	gain0 := colorutils.Clamp(colorutils.Remap(gainKnob, 0.75, 0.95, 0, 1), 0, 1)
+	gain1 := colorutils.Clamp(colorutils.Remap(gainKnob, 0.40, 0.50, 0, 1), 0, 1)
+	gain2 := colorutils.Clamp(colorutils.Remap(gainKnob, 0.05, 0.25, 0, 1), 0, 1)
+
+	for ii := 0; ii < len(colors)/3; ii++ {
+		gain := gain2
+		if ii%4 == 0 || ii%4 == 2 {
+			gain = gain0
+		} else if ii%4 == 3 {
+			gain = gain1
+		}
+		colors[ii*3+0] *= gain
+		colors[ii*3+1] *= gain
+		colors[ii*3+2] *= gain
+	}
+}
@@ -0,0 +1,41 @@
+package opc
+
+// Hue rotation effect
+//   Listen to the hue knob and turn every color around the color wheel.
+//   The rotation happens in the Oklab color space so that colors keep their
+//   brightness as they turn, instead of yellows glaring and blues going dim.
+//
+//   This isn't one of the DEFAULT_EFFECTS because the diamond, fire and white
+//   patterns already follow the hue knob themselves.  Add it to the show's
+//   effects to make the knob turn every pattern.
+
+import (
+	"math"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+type effectHue struct {
+	knob *config.Control
+}
+
+func NewEffectHue() Effect {
+	return &effectHue{knob: config.HUE_KNOB}
+}
+
+func (e *effectHue) Init(locations []float64) {}
+
+func (e *effectHue) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	hueKnob := e.knob.Value(midiState)
+	if hueKnob == 0 || hueKnob == 1 {
+		return
+	}
+	sin, cos := math.Sincos(hueKnob * 2 * math.Pi)
+	for ii := 0; ii+2 < len(colors); ii += 3 {
+		L, a, b := colorutils.RgbToOklab(colors[ii+0], colors[ii+1], colors[ii+2])
+		a, b = a*cos-b*sin, a*sin+b*cos
+		colors[ii+0], colors[ii+1], colors[ii+2] = colorutils.OklabToRgb(L, a, b)
+	}
+}
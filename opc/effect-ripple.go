@@ -0,0 +1,135 @@
+package opc
+
+// Ripple effect
+//   Each hit of the ripple pad sends a shell of white light outward through the
+//   layout from one point, the way a drop spreads rings across a pond but in 3D.
+//   Harder hits make brighter ripples, ripples fade as they travel, and any
+//   number of them can overlap.
+//
+//   The points come from the show config's "ripple" section and take turns; if
+//   it has none, each hit starts at a random pixel.  Every pixel's distance from
+//   every point is worked out once at the start.
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+var RIPPLE_PARAMS = []*Param{
+	{"ripple.speed", PARAM_FLOAT, 0.05, 4, 0.7, "How fast ripples spread, in layout widths per second.  A layout width is the diagonal of the box around it."},
+	{"ripple.width", PARAM_FLOAT, 0.01, 1, 0.1, "Thickness of a ripple's shell, in layout widths."},
+	{"ripple.falloff", PARAM_FLOAT, 0.05, 4, 0.5, "How far a ripple travels before it's half as bright, in layout widths."},
+}
+
+const (
+	RIPPLE_RANDOM_ORIGINS = 16          // how many pixels to choose from when the show config has no origins
+	RIPPLE_MAX            = 32          // when there are more ripples than this, the oldest is dropped
+	RIPPLE_MIN_BRIGHTNESS = 1.0 / 512.0 // a ripple dimmer than this is forgotten
+)
+
+type ripple struct {
+	origin   int // index into the distance tables
+	start    float64
+	velocity float64
+}
+
+type effectRipple struct {
+	pad        *config.Control
+	rng        *rand.Rand
+	distances  [][]float64 // origin --> pixel --> distance in layout widths
+	maxDist    []float64   // origin --> distance of the farthest pixel
+	nextOrigin int
+	ripples    []ripple
+}
+
+func NewEffectRipple() Effect {
+	return &effectRipple{pad: config.RIPPLE_PAD}
+}
+
+func (e *effectRipple) Init(locations []float64) {
+	e.rng = rand.New(rand.NewSource(97))
+	n_pixels := len(locations) / 3
+
+	var origins [][3]float64
+	if len(config.SHOW.Ripple.Origins) > 0 {
+		origins = config.SHOW.Ripple.Origins
+	} else if n_pixels > 0 {
+		for ii := 0; ii < RIPPLE_RANDOM_ORIGINS; ii++ {
+			pp := e.rng.Intn(n_pixels)
+			origins = append(origins, [3]float64{locations[pp*3], locations[pp*3+1], locations[pp*3+2]})
+		}
+	}
+
+	// measure in layout widths so the params suit any size of layout
+	minn, maxx := boundingBox(locations)
+	size := math.Sqrt((maxx[0]-minn[0])*(maxx[0]-minn[0]) + (maxx[1]-minn[1])*(maxx[1]-minn[1]) + (maxx[2]-minn[2])*(maxx[2]-minn[2]))
+	if size == 0 {
+		size = 1
+	}
+
+	e.distances = make([][]float64, len(origins))
+	e.maxDist = make([]float64, len(origins))
+	for oo, origin := range origins {
+		e.distances[oo] = make([]float64, n_pixels)
+		for ii := 0; ii < n_pixels; ii++ {
+			dx := locations[ii*3+0] - origin[0]
+			dy := locations[ii*3+1] - origin[1]
+			dz := locations[ii*3+2] - origin[2]
+			d := math.Sqrt(dx*dx+dy*dy+dz*dz) / size
+			e.distances[oo][ii] = d
+			e.maxDist[oo] = math.Max(e.maxDist[oo], d)
+		}
+	}
+}
+
+func (e *effectRipple) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	RIPPLE_SPEED := PARAMS.Get("ripple.speed", midiState)
+	RIPPLE_WIDTH := PARAMS.Get("ripple.width", midiState)
+	RIPPLE_FALLOFF := PARAMS.Get("ripple.falloff", midiState)
+
+	if e.pad.Pressed(midiState) && len(e.distances) > 0 {
+		origin := e.nextOrigin
+		if len(config.SHOW.Ripple.Origins) > 0 {
+			e.nextOrigin = (e.nextOrigin + 1) % len(e.distances)
+		} else {
+			origin = e.rng.Intn(len(e.distances))
+		}
+		e.ripples = append(e.ripples, ripple{origin: origin, start: t, velocity: e.pad.Value(midiState)})
+		if len(e.ripples) > RIPPLE_MAX {
+			e.ripples = e.ripples[1:]
+		}
+	}
+
+	// forget ripples which have left the layout or faded away
+	alive := e.ripples[:0]
+	for _, r := range e.ripples {
+		radius := (t - r.start) * RIPPLE_SPEED
+		brightness := r.velocity * math.Pow(0.5, (radius-RIPPLE_WIDTH)/RIPPLE_FALLOFF)
+		if radius-RIPPLE_WIDTH <= e.maxDist[r.origin] && brightness >= RIPPLE_MIN_BRIGHTNESS {
+			alive = append(alive, r)
+		}
+	}
+	e.ripples = alive
+	if len(e.ripples) == 0 {
+		return
+	}
+
+	for _, r := range e.ripples {
+		radius := (t - r.start) * RIPPLE_SPEED
+		distances := e.distances[r.origin]
+		for ii := 0; ii < len(colors)/3 && ii < len(distances); ii++ {
+			d := distances[ii]
+			shell := 1 - math.Abs(d-radius)/RIPPLE_WIDTH
+			if shell <= 0 {
+				continue
+			}
+			amt := r.velocity * shell * math.Pow(0.5, d/RIPPLE_FALLOFF)
+			colors[ii*3+0] += amt
+			colors[ii*3+1] += amt
+			colors[ii*3+2] += amt
+		}
+	}
+}
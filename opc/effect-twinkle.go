@@ -0,0 +1,69 @@
+package opc
+
+// Twinkle strobe effect
+//   While the twinkle pad is down, light random pixels white.  Harder hits light
+//   more of them, and the strobe keeps going for a moment after the pad is let go.
+
+import (
+	"math/rand"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+var TWINKLE_PARAMS = []*Param{
+	{"twinkle.max-density", PARAM_FLOAT, 0, 1, 0.3, "Fraction of pixels lit by the twinkle strobe at full pad velocity."},
+	{"twinkle.duration", PARAM_FLOAT, 0, 2, 8.0 / 40.0, "How long the twinkle strobe lasts after the pad is released, in seconds."},
+	{"twinkle.beat-sync", PARAM_BOOL, 0, 1, 0, "While the twinkle pad is down, strobe once per beat of the midi clock or tapped tempo."},
+}
+
+type effectTwinkle struct {
+	pad             *config.Control
+	rng             *rand.Rand
+	lastTwinkleTime float64
+	lastTwinklePad  float64
+	lastBeat        int
+}
+
+func NewEffectTwinkle() Effect {
+	return &effectTwinkle{pad: config.TWINKLE_PAD}
+}
+
+func (e *effectTwinkle) Init(locations []float64) {
+	e.rng = rand.New(rand.NewSource(98))
+	e.lastBeat = -1
+}
+
+func (e *effectTwinkle) Apply(colors []float64, t, dt float64, midiState *midi.MidiState) {
+	MAX_TWINKLE_DENSITY := PARAMS.Get("twinkle.max-density", midiState)
+	TWINKLE_DURATION := PARAMS.Get("twinkle.duration", midiState)
+	BEAT_SYNC := PARAMS.GetBool("twinkle.beat-sync", midiState) && midiState.Clock.BPM > 0
+
+	twinklePad := e.pad.Value(midiState)
+	if twinklePad > 0 {
+		e.lastTwinklePad = twinklePad
+		if BEAT_SYNC {
+			// only restart the strobe when a new beat begins
+			beat := int(midiState.Clock.Beats(stateTime(midiState)))
+			if beat != e.lastBeat {
+				e.lastTwinkleTime = t
+			}
+			e.lastBeat = beat
+		} else {
+			e.lastTwinkleTime = t
+		}
+	}
+
+	twinkleAmt := colorutils.Clamp(colorutils.Remap(t-e.lastTwinkleTime, 0, TWINKLE_DURATION, 1, 0), 0, 1)
+	if twinkleAmt == 0 {
+		return
+	}
+	for ii := 0; ii < len(colors)/3; ii++ {
+		if e.rng.Float64() < e.lastTwinklePad*MAX_TWINKLE_DENSITY {
+			colors[ii*3+0] += twinkleAmt
+			colors[ii*3+1] += twinkleAmt
+			colors[ii*3+2] += twinkleAmt
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package opc
+
+// Effect interface
+//   An Effect changes a frame after the pattern has rendered it: the gain knob,
+//   the eyelid, the pads that flash and blink, and so on.  Each one has its own
+//   parameters and listens to its own controls, and a show picks which effects
+//   to use and in what order (see NewEffectChain).
+//
+//   Effects work on colors from 0 to 1 instead of bytes so that a chain of them
+//   only rounds once, at the end.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/midi"
+)
+
+type Effect interface {
+	// Called once before the first frame with the layout's [x y z  x y z ...] locations.
+	Init(locations []float64)
+
+	// Change one frame of colors in [r g b  r g b ...] order.  Colors are normally from 0
+	// to 1 but can go out of range along the way; they're clamped when they become bytes.
+	// t and dt are as for Pattern.Render.  midiState should be treated as read-only.
+	Apply(colors []float64, t, dt float64, midiState *midi.MidiState)
+}
+
+// What the registry knows about each effect: how to make it and which
+// parameters it understands.
+type EffectEntry struct {
+	New    func() Effect
+	Params []*Param
+}
+
+var EFFECT_REGISTRY = map[string]*EffectEntry{
+	"gain":          {New: NewEffectGain},
+	"eyelid":        {New: NewEffectEyelid, Params: EYELID_PARAMS},
+	"flash":         {New: NewEffectFlash, Params: FLASH_PARAMS},
+	"twinkle":       {New: NewEffectTwinkle, Params: TWINKLE_PARAMS},
+	"blink-arch":    {New: NewEffectBlinkArch, Params: BLINK_ARCH_PARAMS},
+	"blink-back":    {New: NewEffectBlinkBack, Params: BLINK_BACK_PARAMS},
+	"desat":         {New: NewEffectDesat},
+	"fade-to-black": {New: NewEffectFadeToBlack, Params: FADE_TO_BLACK_PARAMS},
+	"hue":           {New: NewEffectHue},
+	"extract":       {New: NewEffectExtract, Params: EXTRACT_PARAMS},
+	"blink-red":     {New: NewEffectBlinkRed, Params: BLINK_RED_PARAMS},
+	"blink-green":   {New: NewEffectBlinkGreen, Params: BLINK_GREEN_PARAMS},
+	"blink-blue":    {New: NewEffectBlinkBlue, Params: BLINK_BLUE_PARAMS},
+	"ripple":        {New: NewEffectRipple, Params: RIPPLE_PARAMS},
+}
+
+// The effects to use, in order, when the show config doesn't say.
+var DEFAULT_EFFECTS = []string{"gain", "eyelid", "twinkle", "blink-arch", "blink-back",
+	"blink-red", "blink-green", "blink-blue", "ripple", "desat", "fade-to-black"}
+
+// Return the names of the registered effects in alphabetical order.
+func EffectNames() []string {
+	names := make([]string, 0, len(EFFECT_REGISTRY))
+	for name := range EFFECT_REGISTRY {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Runs several effects in order as one Pattern which changes the bytes it's given
+// instead of filling them.
+type effectChain struct {
+	effects []Effect
+	timings []*Timing // effect --> how long it takes, if the chain is timed
+	colors  []float64
+}
+
+// Return a Pattern which applies the named effects to each frame, in order, instead of
+// filling it.  It's also a FloatPattern.  Each effect's time is recorded in TIMINGS as
+// "effects.<name>".
+func NewEffectChain(names []string) (Pattern, error) {
+	chain := &effectChain{}
+	for _, name := range names {
+		entry, ok := EFFECT_REGISTRY[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown effect %q", name)
+		}
+		chain.effects = append(chain.effects, entry.New())
+		chain.timings = append(chain.timings, TIMINGS.Get("effects."+name))
+	}
+	return chain, nil
+}
+
+// Return a ByteThread which applies the named effects to each frame, in order.
+func MakeEffectChain(names []string, locations []float64) (ByteThread, error) {
+	chain, err := NewEffectChain(names)
+	if err != nil {
+		return nil, err
+	}
+	return PatternToByteThread(chain, locations), nil
+}
+
+func (chain *effectChain) Init(locations []float64) {
+	for _, effect := range chain.effects {
+		effect.Init(locations)
+	}
+}
+
+// Run each effect on chain.colors, timing it if the chain is timed.
+func (chain *effectChain) apply(t, dt float64, midiState *midi.MidiState) {
+	for ii, effect := range chain.effects {
+		start := time.Now()
+		effect.Apply(chain.colors, t, dt, midiState)
+		if ii < len(chain.timings) {
+			chain.timings[ii].Add(time.Since(start))
+		}
+	}
+}
+
+func (chain *effectChain) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	if len(chain.effects) == 0 {
+		return
+	}
+	if len(chain.colors) != len(bytes) {
+		chain.colors = make([]float64, len(bytes))
+	}
+	for ii, b := range bytes {
+		chain.colors[ii] = float64(b) / 255
+	}
+	chain.apply(t, dt, midiState)
+	for ii, c := range chain.colors {
+		bytes[ii] = colorutils.FloatToByte(c)
+	}
+}
+
+func (chain *effectChain) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	if len(chain.effects) == 0 {
+		return
+	}
+	if len(chain.colors) != len(colors) {
+		chain.colors = make([]float64, len(colors))
+	}
+	for ii, c := range colors {
+		chain.colors[ii] = float64(c)
+	}
+	chain.apply(t, dt, midiState)
+	for ii, c := range chain.colors {
+		colors[ii] = float32(colorutils.Clamp(c, 0, 1))
+	}
+}
+
+//--------------------------------------------------------------------------------
+// HELPERS
+
+// Return the smallest and largest x, y, and z of the locations.
+func boundingBox(locations []float64) (minn, maxx [3]float64) {
+	for ii := 0; ii+2 < len(locations); ii += 3 {
+		for axis := 0; axis < 3; axis++ {
+			v := locations[ii+axis]
+			if ii == 0 || v < minn[axis] {
+				minn[axis] = v
+			}
+			if ii == 0 || v > maxx[axis] {
+				maxx[axis] = v
+			}
+		}
+	}
+	return minn, maxx
+}
+
+// Return the time of the frame the midi state belongs to, or now if it isn't a snapshot.
+func stateTime(midiState *midi.MidiState) time.Time {
+	if midiState.Time.IsZero() {
+		return time.Now()
+	}
+	return midiState.Time
+}
@@ -0,0 +1,243 @@
+package opc
+
+import (
+	"testing"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+// eight pixels spiralling out around the origin
+var EFFECT_TEST_LOCATIONS = []float64{
+	0.1, 0, 0.0,
+	0.14, 0, 0.14,
+	0.0, 0, 0.3,
+	-0.25, 0, 0.25,
+	-0.4, 0, 0.0,
+	-0.3, 0, -0.3,
+	0.0, 0, -0.45,
+	0.35, 0, -0.35,
+}
+
+var EFFECT_TEST_INPUT = []byte{
+	255, 0, 0, 0, 255, 0, 0, 0, 255, 255, 255, 255,
+	128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0,
+}
+
+// One frame of an effect test: when it is, which messages arrived just before it,
+// and the bytes that should come out.
+type effectTestFrame struct {
+	t        float64
+	messages []*midi.MidiMessage
+	want     []byte
+}
+
+func knobMessage(control *config.Control, value byte) *midi.MidiMessage {
+	return &midi.MidiMessage{Kind: midi.CONTROLLER, Key: control.Number, Value: value}
+}
+
+func padMessage(control *config.Control, velocity byte) *midi.MidiMessage {
+	return &midi.MidiMessage{Kind: midi.NOTE_ON, Key: control.Number, Value: velocity}
+}
+
+// Run the effect over EFFECT_TEST_INPUT for each frame and compare the results to the golden frames.
+func checkEffect(t *testing.T, name string, frames []effectTestFrame) {
+	entry, ok := EFFECT_REGISTRY[name]
+	if !ok {
+		t.Fatalf("%s: not registered", name)
+	}
+	chain := &effectChain{effects: []Effect{entry.New()}}
+	chain.Init(EFFECT_TEST_LOCATIONS)
+	midiState := &midi.MidiState{}
+	config.SetDefaultKnobValues(midiState)
+	for ii, frame := range frames {
+		midiState.UpdateStateFromSlice(frame.messages)
+		bytes := append([]byte{}, EFFECT_TEST_INPUT...)
+		chain.Render(bytes, frame.t, 0, midiState)
+		if string(bytes) != string(frame.want) {
+			t.Errorf("%s frame %d:\n got %v\nwant %v", name, ii, bytes, frame.want)
+		}
+	}
+}
+
+func TestEffectGain(t *testing.T) {
+	checkEffect(t, "gain", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{knobMessage(config.GAIN_KNOB, 90)}, []byte{
+			0, 0, 0, 0, 255, 0, 0, 0, 0, 255, 255, 255,
+			0, 0, 0, 10, 20, 30, 0, 0, 0, 0, 0, 0}},
+		{1000.2, []*midi.MidiMessage{knobMessage(config.GAIN_KNOB, 0)}, make([]byte, 24)},
+	})
+}
+
+func TestEffectEyelid(t *testing.T) {
+	checkEffect(t, "eyelid", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{knobMessage(config.EYELID_KNOB, 64)}, []byte{
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1000.2, []*midi.MidiMessage{knobMessage(config.EYELID_KNOB, 0)}, make([]byte, 24)},
+	})
+}
+
+func TestEffectFlash(t *testing.T) {
+	checkEffect(t, "flash", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{padMessage(config.FLASH_PAD, 100)}, []byte{
+			153, 215, 255, 153, 215, 255, 153, 215, 255, 153, 215, 255,
+			153, 215, 255, 153, 215, 255, 153, 215, 255, 153, 215, 255}},
+		{1000.15, nil, []byte{
+			255, 1, 1, 45, 243, 75, 54, 76, 255, 227, 244, 255,
+			130, 74, 47, 119, 168, 202, 200, 101, 3, 5, 7, 8}},
+		{1000.4, []*midi.MidiMessage{padMessage(config.FLASH_PAD, 0)}, EFFECT_TEST_INPUT},
+	})
+}
+
+func TestEffectTwinkle(t *testing.T) {
+	checkEffect(t, "twinkle", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{padMessage(config.TWINKLE_PAD, 127)}, []byte{
+			255, 0, 0, 255, 255, 255, 0, 0, 255, 255, 255, 255,
+			255, 255, 255, 10, 20, 30, 200, 100, 0, 255, 255, 255}},
+		{1000.2, []*midi.MidiMessage{padMessage(config.TWINKLE_PAD, 0)}, []byte{
+			255, 0, 0, 127, 255, 127, 127, 127, 255, 255, 255, 255,
+			128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1000.4, nil, EFFECT_TEST_INPUT},
+	})
+}
+
+func TestEffectBlinkArch(t *testing.T) {
+	checkEffect(t, "blink-arch", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{padMessage(config.BLINK_ARCH_PAD, 64)}, EFFECT_TEST_INPUT},
+		{1000.2, []*midi.MidiMessage{padMessage(config.BLINK_ARCH_PAD, 0)}, []byte{
+			249, 249, 249, 0, 255, 0, 0, 0, 255, 255, 255, 255,
+			128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1000.6, nil, []byte{
+			255, 0, 0, 232, 232, 232, 231, 231, 231, 255, 255, 255,
+			128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1001.1, nil, []byte{
+			255, 0, 0, 0, 255, 0, 232, 232, 232, 253, 253, 253,
+			232, 232, 232, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1002, nil, EFFECT_TEST_INPUT},
+	})
+}
+
+func TestEffectBlinkBack(t *testing.T) {
+	checkEffect(t, "blink-back", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{padMessage(config.BLINK_BACK_PAD, 100)}, EFFECT_TEST_INPUT},
+		{1000.2, []*midi.MidiMessage{padMessage(config.BLINK_BACK_PAD, 0)}, []byte{
+			255, 0, 0, 215, 215, 215, 0, 0, 255, 255, 255, 255,
+			128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1000.4, nil, []byte{
+			255, 0, 0, 0, 255, 0, 175, 175, 175, 135, 135, 135,
+			128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1002, nil, EFFECT_TEST_INPUT},
+	})
+}
+
+func TestEffectDesat(t *testing.T) {
+	checkEffect(t, "desat", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{knobMessage(config.DESAT_KNOB, 64)}, []byte{
+			182, 55, 55, 55, 182, 55, 55, 55, 182, 255, 255, 255,
+			112, 80, 65, 18, 23, 28, 165, 115, 65, 0, 0, 0}},
+		{1000.2, []*midi.MidiMessage{knobMessage(config.DESAT_KNOB, 127)}, []byte{
+			110, 110, 110, 110, 110, 110, 110, 110, 110, 255, 255, 255,
+			97, 97, 97, 26, 26, 26, 130, 130, 130, 0, 0, 0}},
+	})
+}
+
+func TestEffectFadeToBlack(t *testing.T) {
+	checkEffect(t, "fade-to-black", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{padMessage(config.FADE_TO_BLACK_PAD, 100)}, EFFECT_TEST_INPUT},
+		{1000.25, nil, []byte{
+			153, 0, 0, 0, 153, 0, 0, 0, 153, 153, 153, 153,
+			77, 38, 19, 6, 12, 18, 120, 60, 0, 0, 0, 0}},
+		{1001, nil, make([]byte, 24)},
+		{1001.1, []*midi.MidiMessage{padMessage(config.FADE_TO_BLACK_PAD, 0)}, EFFECT_TEST_INPUT},
+	})
+}
+
+func TestMakeEffectChain(t *testing.T) {
+	if _, err := MakeEffectChain([]string{"gain", "sparkle"}, EFFECT_TEST_LOCATIONS); err == nil {
+		t.Errorf("an unknown effect should be an error")
+	}
+	for _, name := range DEFAULT_EFFECTS {
+		if _, ok := EFFECT_REGISTRY[name]; !ok {
+			t.Errorf("default effect %q isn't registered", name)
+		}
+	}
+}
+
+func TestEffectHue(t *testing.T) {
+	checkEffect(t, "hue", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{knobMessage(config.HUE_KNOB, 42)}, []byte{
+			0, 174, 0, 89, 197, 255, 207, 0, 0, 255, 255, 255,
+			0, 101, 70, 29, 14, 16, 0, 160, 122, 0, 0, 0}},
+		{1000.2, []*midi.MidiMessage{knobMessage(config.HUE_KNOB, 127)}, EFFECT_TEST_INPUT},
+	})
+}
+
+func TestEffectExtract(t *testing.T) {
+	checkEffect(t, "extract", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{knobMessage(config.EXTRACT_KNOB, 3)}, []byte{
+			255, 0, 0, 211, 211, 211, 86, 86, 86, 255, 255, 255,
+			128, 64, 32, 19, 19, 19, 200, 100, 0, 0, 0, 0}},
+		{1000.2, []*midi.MidiMessage{knobMessage(config.EXTRACT_KNOB, 80)}, []byte{
+			136, 136, 136, 211, 211, 211, 0, 0, 255, 255, 255, 255,
+			84, 84, 84, 10, 20, 30, 131, 131, 131, 0, 0, 0}},
+	})
+}
+
+func TestEffectColorBlink(t *testing.T) {
+	// the LPD8 has no pad to spare for it
+	saved := *config.BLINK_GREEN_PAD
+	defer func() { *config.BLINK_GREEN_PAD = saved }()
+	config.BLINK_GREEN_PAD.Off = false
+	config.BLINK_GREEN_PAD.Number = 60
+
+	checkEffect(t, "blink-green", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{padMessage(config.BLINK_GREEN_PAD, 127)}, []byte{
+			255, 255, 0, 0, 255, 0, 0, 255, 255, 255, 255, 255,
+			128, 255, 32, 10, 255, 30, 200, 255, 0, 0, 255, 0}},
+		{1000.2, []*midi.MidiMessage{padMessage(config.BLINK_GREEN_PAD, 0)}, []byte{
+			255, 85, 0, 0, 255, 0, 0, 85, 255, 255, 255, 255,
+			128, 128, 32, 10, 98, 30, 200, 152, 0, 0, 85, 0}},
+		{1000.3, nil, EFFECT_TEST_INPUT},
+	})
+}
+
+func TestEffectRipple(t *testing.T) {
+	// the LPD8 has no pad to spare for it
+	saved := *config.RIPPLE_PAD
+	defer func() { *config.RIPPLE_PAD = saved }()
+	config.RIPPLE_PAD.Off = false
+	config.RIPPLE_PAD.Number = 61
+	savedOrigins := config.SHOW.Ripple.Origins
+	defer func() { config.SHOW.Ripple.Origins = savedOrigins }()
+	config.SHOW.Ripple.Origins = [][3]float64{{0.1, 0, 0}}
+
+	// the second hit starts while the first is still on its way out
+	checkEffect(t, "ripple", []effectTestFrame{
+		{1000, nil, EFFECT_TEST_INPUT},
+		{1000.1, []*midi.MidiMessage{padMessage(config.RIPPLE_PAD, 127)}, []byte{
+			255, 255, 255, 0, 255, 0, 0, 0, 255, 255, 255, 255,
+			128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1000.3, []*midi.MidiMessage{padMessage(config.RIPPLE_PAD, 0)}, []byte{
+			255, 0, 0, 205, 255, 205, 0, 0, 255, 255, 255, 255,
+			128, 64, 32, 10, 20, 30, 200, 100, 0, 0, 0, 0}},
+		{1000.6, []*midi.MidiMessage{padMessage(config.RIPPLE_PAD, 64)}, []byte{
+			255, 129, 129, 0, 255, 0, 81, 81, 255, 255, 255, 255,
+			128, 64, 32, 10, 20, 30, 222, 121, 21, 64, 64, 64}},
+		{1000.8, []*midi.MidiMessage{padMessage(config.RIPPLE_PAD, 0)}, []byte{
+			255, 0, 0, 103, 255, 103, 0, 0, 255, 255, 255, 255,
+			236, 172, 140, 118, 128, 138, 255, 162, 62, 22, 22, 22}},
+		{1003, nil, EFFECT_TEST_INPUT},
+	})
+}
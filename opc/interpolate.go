@@ -0,0 +1,61 @@
+package opc
+
+// Frame interpolation
+//   When the patterns can't keep up with the output, mainLoop renders fewer
+//   frames and sends blends of the last two in between.  Blending happens in
+//   linear light, the way the LEDs will show it after gamma correction, so a
+//   fade between two frames doesn't dip or bulge in brightness.  Because the
+//   LEDs' current is linear in linear light too, a blend of two frames which
+//   are within the power limit is also within it.
+
+import (
+	"math"
+	"sort"
+)
+
+// byte --> how bright the LED is, from 0 to 1
+var BYTE_TO_LINEAR = func() []float64 {
+	table := make([]float64, 256)
+	for ii := range table {
+		table[ii] = math.Pow(float64(ii)/255, GAMMA)
+	}
+	return table
+}()
+
+// Return the byte whose brightness is nearest to lin.
+func linearToByte(lin float64) byte {
+	// the first byte at least as bright as lin, or the one before it
+	ii := sort.SearchFloat64s(BYTE_TO_LINEAR, lin)
+	if ii >= 256 {
+		return 255
+	}
+	if ii > 0 && lin-BYTE_TO_LINEAR[ii-1] < BYTE_TO_LINEAR[ii]-lin {
+		return byte(ii - 1)
+	}
+	return byte(ii)
+}
+
+// Write the blend of the from and to frames into out.  amount goes from 0 (all from)
+// to 1 (all to).  out may be the same slice as from or to.
+func InterpolateBytes(out, from, to []byte, amount float64) {
+	for ii := range out {
+		if from[ii] == to[ii] {
+			out[ii] = from[ii]
+			continue
+		}
+		out[ii] = linearToByte(BYTE_TO_LINEAR[from[ii]]*(1-amount) + BYTE_TO_LINEAR[to[ii]]*amount)
+	}
+}
+
+// Like InterpolateBytes, but for high-precision colors.
+func InterpolateColors(out, from, to []float32, amount float64) {
+	for ii := range out {
+		if from[ii] == to[ii] {
+			out[ii] = from[ii]
+			continue
+		}
+		a := math.Pow(math.Max(float64(from[ii]), 0), GAMMA)
+		b := math.Pow(math.Max(float64(to[ii]), 0), GAMMA)
+		out[ii] = float32(math.Pow(a*(1-amount)+b*amount, 1/GAMMA))
+	}
+}
@@ -0,0 +1,61 @@
+package opc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterpolateBytes(t *testing.T) {
+	from := []byte{0, 0, 255, 17}
+	to := []byte{0, 255, 0, 17}
+	out := make([]byte, len(from))
+
+	InterpolateBytes(out, from, to, 0)
+	if string(out) != string(from) {
+		t.Errorf("amount 0: got %v, want %v", out, from)
+	}
+	InterpolateBytes(out, from, to, 1)
+	if string(out) != string(to) {
+		t.Errorf("amount 1: got %v, want %v", out, to)
+	}
+
+	// half the light of full brightness is well over half the byte
+	InterpolateBytes(out, from, to, 0.5)
+	want := linearToByte(0.5)
+	if out[0] != 0 || out[1] != want || out[2] != want || out[3] != 17 {
+		t.Errorf("amount 0.5: got %v, want [0 %v %v 17]", out, want, want)
+	}
+	if want < 180 || want > 195 {
+		t.Errorf("half brightness is byte %v", want)
+	}
+
+	// every byte survives the round trip to linear light
+	for ii := 0; ii < 256; ii++ {
+		if b := linearToByte(BYTE_TO_LINEAR[ii]); int(b) != ii {
+			t.Errorf("byte %v came back as %v", ii, b)
+		}
+	}
+}
+
+func TestInterpolateColors(t *testing.T) {
+	from := []float32{0, 0.25, 1}
+	to := []float32{0, 0.75, 0}
+	out := make([]float32, len(from))
+
+	InterpolateColors(out, from, to, 0)
+	for ii := range out {
+		if math.Abs(float64(out[ii]-from[ii])) > 1e-6 {
+			t.Errorf("amount 0: got %v, want %v", out, from)
+		}
+	}
+	InterpolateColors(out, from, to, 1)
+	for ii := range out {
+		if math.Abs(float64(out[ii]-to[ii])) > 1e-6 {
+			t.Errorf("amount 1: got %v, want %v", out, to)
+		}
+	}
+	InterpolateColors(out, from, to, 0.5)
+	if out[0] != 0 || out[1] <= 0.5 || out[2] <= 0.5 {
+		t.Errorf("amount 0.5: got %v, want brighter than the average of the colors", out)
+	}
+}
@@ -6,6 +6,7 @@ package opc
 import (
 	"bufio"
 	"fmt"
+	"github.com/longears/pixelslinger/colorutils"
 	"github.com/longears/pixelslinger/midi"
 	"math"
 	"net"
@@ -18,35 +19,98 @@ import (
 //--------------------------------------------------------------------------------
 // PATTERN REGISTRY
 
-var PATTERN_REGISTRY map[string](func(locations []float64) ByteThread)
+// What the registry knows about each pattern: how to make it and which
+// parameters it understands.
+// Old-style patterns provide a ByteThread Maker; newer ones provide New, which
+// returns an uninitialized Pattern.  Use MakeByteThread, MakeStage or NewPattern
+// to get whichever flavor you need regardless of how the pattern was written.
+type PatternEntry struct {
+	Maker  func(locations []float64) ByteThread
+	New    func() Pattern
+	Params []*Param
+	// 0 for ordinary patterns.  Patterns which play other patterns chosen by the show
+	// config have a number above 0 and only play patterns with a lower one, so that
+	// they can't end up playing themselves.
+	Container int
+}
+
+// Return an error unless the pattern called inner can be played by the container called outer.
+func checkContained(outer, inner string) error {
+	entry, ok := PATTERN_REGISTRY[inner]
+	if !ok {
+		return fmt.Errorf("unknown pattern %q", inner)
+	}
+	if entry.Container > 0 && entry.Container >= PATTERN_REGISTRY[outer].Container {
+		return fmt.Errorf("%q plays other patterns, so it can't go in the %s", inner, outer)
+	}
+	return nil
+}
+
+// Return a ByteThread which runs this entry's pattern.
+func (entry *PatternEntry) MakeByteThread(locations []float64) ByteThread {
+	if entry.New != nil {
+		return PatternToByteThread(entry.New(), locations)
+	}
+	return entry.Maker(locations)
+}
+
+// Return a Stage which runs this entry's pattern, with high-precision colors if highPrecision
+// is set and the pattern can render them.
+func (entry *PatternEntry) MakeStage(locations []float64, highPrecision bool) Stage {
+	if entry.New != nil {
+		return PatternToStage(entry.New(), locations, highPrecision)
+	}
+	return entry.Maker(locations)
+}
+
+// Return a new, uninitialized Pattern for this entry.
+func (entry *PatternEntry) NewPattern() Pattern {
+	if entry.New != nil {
+		return entry.New()
+	}
+	return NewByteThreadPattern(entry.Maker)
+}
+
+var PATTERN_REGISTRY map[string]*PatternEntry
 
 func init() {
 	// This has to happen in init() to avoid an initialization loop (circular dependency)
 	// because the midi-switcher pattern reads from this map.
-	PATTERN_REGISTRY = map[string](func(locations []float64) ByteThread){
-		"basic-midi":      MakePatternBasicMidi,
-		"diamond":         MakePatternDiamond,
-		"eye":             MakePatternEye,
-		"fire":            MakePatternFire,
-		"japan":           MakePatternJapan,
-		"midi-switcher":   MakePatternMidiSwitcher,
-		"moire":           MakePatternMoire,
-		"off":             MakePatternOff,
-		"raver-plaid":     MakePatternRaverPlaid,
-		"sailor-moon":     MakePatternSailorMoon,
-		"shield":          MakePatternShield,
-		"spatial-stripes": MakePatternSpatialStripes,
-		"square":          MakePatternSquare,
-		//"listener":        MakePatternListener,
-		"sunset":          MakePatternSunset,
-		"test":            MakePatternTest,
-		"test-gamma":      MakePatternTestGamma,
-		"test-rgb":        MakePatternTestRGB,
-		"white":           MakePatternWhite,
-		"aqua":			   MakePatternAqua,
-		"aquab":           MakePatternAquaB,
-		"colorbox":		   MakePatternSpatialColorBox,
-		"archimedes":      MakePatternArchimedes,
+	PATTERN_REGISTRY = map[string]*PatternEntry{
+		"basic-midi":      {Maker: MakePatternBasicMidi, Params: BASIC_MIDI_PARAMS},
+		"compose":         {New: NewPatternCompose, Params: COMPOSE_PARAMS, Container: 2},
+		"diamond":         {Maker: MakePatternDiamond},
+		"eye":             {Maker: MakePatternEye, Params: EYE_PARAMS},
+		"fire":            {New: NewPatternFire, Params: FIRE_PARAMS},
+		"japan":           {Maker: MakePatternJapan},
+		"midi-switcher":   {New: NewPatternMidiSwitcher, Params: MIDI_SWITCHER_PARAMS, Container: 1},
+		"moire":           {Maker: MakePatternMoire},
+		"off":             {New: NewPatternOff},
+		"playlist":        {New: NewPatternPlaylist, Container: 3},
+		"raver-plaid":     {Maker: MakePatternRaverPlaid},
+		"sailor-moon":     {Maker: MakePatternSailorMoon},
+		"shield":          {Maker: MakePatternShield},
+		"spatial-stripes": {Maker: MakePatternSpatialStripes},
+		"square":          {Maker: MakePatternSquare},
+		//"listener":        {Maker: MakePatternListener},
+		"schedule":        {New: NewPatternSchedule, Container: 4},
+		"sunset":          {New: NewPatternSunset, Params: SUNSET_PARAMS},
+		"test":            {Maker: MakePatternTest},
+		"test-gamma":      {Maker: MakePatternTestGamma},
+		"test-rgb":        {Maker: MakePatternTestRGB},
+		"white":           {New: NewPatternWhite},
+		"aqua":            {Maker: MakePatternAqua},
+		"aquab":           {Maker: MakePatternAquaB},
+		"colorbox":        {Maker: MakePatternSpatialColorBox},
+		"archimedes":      {Maker: MakePatternArchimedes},
+	}
+
+	// make every pattern's parameters settable by name
+	for _, entry := range PATTERN_REGISTRY {
+		PARAMS.Register(entry.Params)
+	}
+	for _, entry := range EFFECT_REGISTRY {
+		PARAMS.Register(entry.Params)
 	}
 }
 
@@ -62,10 +126,132 @@ func init() {
 // They should loop forever until the input channel is closed, then return.
 // The byte slice should hold values from 0 to 255 in [r g b  r g b  r g b  ... ] order
 // so its total length is 3 times the number of pixels in the LED strip.
-// The MidiState object is shared with other threads and should be treated as read-only.
-// It will be updated during the time when the ByteThread is not holding a byte slice.
+// The MidiState object belongs to the ByteThread's stage and should be treated as read-only.
+// RunStage updates it to the frame's snapshot while the ByteThread is not holding a byte slice.
 type ByteThread func(chan []byte, chan []byte, *midi.MidiState)
 
+// The high-precision counterpart of ByteThread.  Colors are float32s from 0 to 1 in
+// [r g b  r g b  r g b  ... ] order instead of bytes, so that slow fades and dim colors
+// don't band on their way to the LEDs.  Otherwise it works just like a ByteThread.
+type FloatThread func(chan []float32, chan []float32, *midi.MidiState)
+
+// One frame on its way through the stages: the pixels, and a snapshot of the midi state
+// as of when the frame began (its Time field is the frame time).
+// Every stage sees the same snapshot, which must not be changed.
+// High-precision frames also carry Colors, which are the real pixels; Bytes is then only
+// where 8-bit stages get a rounded copy of them.
+type Frame struct {
+	Bytes     []byte
+	Colors    []float32 // nil for 8-bit frames
+	MidiState *midi.MidiState
+}
+
+// A ByteThread or a FloatThread: something which can run as a stage with RunStage.
+type Stage interface {
+	runStage(framesIn chan *Frame, framesOut chan *Frame)
+}
+
+// Run a ByteThread or a FloatThread as a stage in its own goroutine.  It receives frames
+// on framesIn, passes their pixels through the thread, and sends them on to framesOut.
+// The thread gets its own copy of the MidiState which is only updated while it's idle,
+// so it never sees the state change in the middle of a frame.
+// When framesIn is closed, the thread's input is closed too.
+//
+// Frames are converted as needed, so either kind of thread can handle either kind of frame.
+// A ByteThread keeps a high-precision frame's colors wherever it leaves a pixel alone
+// (see bytesToChangedColors).
+func RunStage(thread Stage, framesIn chan *Frame, framesOut chan *Frame) {
+	thread.runStage(framesIn, framesOut)
+}
+
+func (thread ByteThread) runStage(framesIn chan *Frame, framesOut chan *Frame) {
+	bytesIn := make(chan []byte, 0)
+	bytesOut := make(chan []byte, 0)
+	midiState := &midi.MidiState{}
+	go thread(bytesIn, bytesOut, midiState)
+	go func() {
+		defer close(bytesIn)
+		var before []byte // what the ByteThread was given, for high-precision frames
+		for frame := range framesIn {
+			// the ByteThread isn't holding a slice right now, so it's safe to update its copy
+			*midiState = *frame.MidiState
+			if frame.Colors != nil {
+				FloatsToBytes(frame.Colors, frame.Bytes)
+				before = append(before[:0], frame.Bytes...)
+			}
+			given := frame.Bytes
+			bytesIn <- frame.Bytes
+			frame.Bytes = <-bytesOut
+			if frame.Colors != nil {
+				// a thread which sends back its own slice has filled the whole frame
+				filled := len(given) == 0 || len(frame.Bytes) == 0 || &given[0] != &frame.Bytes[0]
+				bytesToChangedColors(frame.Bytes, before, frame.Colors, filled)
+			}
+			framesOut <- frame
+		}
+	}()
+}
+
+func (thread FloatThread) runStage(framesIn chan *Frame, framesOut chan *Frame) {
+	colorsIn := make(chan []float32, 0)
+	colorsOut := make(chan []float32, 0)
+	midiState := &midi.MidiState{}
+	go thread(colorsIn, colorsOut, midiState)
+	go func() {
+		defer close(colorsIn)
+		var scratch []float32 // for 8-bit frames
+		for frame := range framesIn {
+			// the FloatThread isn't holding a slice right now, so it's safe to update its copy
+			*midiState = *frame.MidiState
+			if frame.Colors != nil {
+				colorsIn <- frame.Colors
+				frame.Colors = <-colorsOut
+			} else {
+				if len(scratch) != len(frame.Bytes) {
+					scratch = make([]float32, len(frame.Bytes))
+				}
+				BytesToFloats(frame.Bytes, scratch)
+				colorsIn <- scratch
+				scratch = <-colorsOut
+				FloatsToBytes(scratch, frame.Bytes)
+			}
+			framesOut <- frame
+		}
+	}()
+}
+
+// Update a high-precision frame's colors from the bytes an 8-bit stage sent back.
+// before is what the stage was given.  A pixel whose three bytes are all as they were
+// keeps its colors, so that a stage which only touches a few pixels doesn't round the
+// rest; any other pixel takes all three channels from the bytes.  If filled, the stage
+// made the bytes from scratch and every pixel is converted.
+func bytesToChangedColors(bytes, before []byte, colors []float32, filled bool) {
+	for ii := 0; ii+2 < len(bytes) && ii+2 < len(colors); ii += 3 {
+		if !filled && ii+2 < len(before) &&
+			bytes[ii] == before[ii] && bytes[ii+1] == before[ii+1] && bytes[ii+2] == before[ii+2] {
+			continue
+		}
+		colors[ii+0] = float32(bytes[ii+0]) / 255
+		colors[ii+1] = float32(bytes[ii+1]) / 255
+		colors[ii+2] = float32(bytes[ii+2]) / 255
+	}
+}
+
+// Convert bytes to colors from 0 to 1.  colors must be at least as long as bytes.
+func BytesToFloats(bytes []byte, colors []float32) {
+	for ii, b := range bytes {
+		colors[ii] = float32(b) / 255
+	}
+}
+
+// Round colors from 0 to 1 to bytes the way colorutils.FloatToByte does.
+// bytes must be at least as long as colors.
+func FloatsToBytes(colors []float32, bytes []byte) {
+	for ii, c := range colors {
+		bytes[ii] = colorutils.FloatToByte(float64(c))
+	}
+}
+
 //--------------------------------------------------------------------------------
 // CONSTANTS
 
@@ -171,114 +357,222 @@ func MakeSendToScreenThread() ByteThread {
 	}
 }
 
+// Writes frames to LED strips which use the LPD8806 chipset, over SPI.
+type lpd8806Writer struct {
+	spiFile *os.File
+}
+
+// Open the SPI device (such as "/dev/spidev1.0").
+// If it can't be opened, exit the whole program with exit status 1.
+func openLPD8806(spiFn string, name string) *lpd8806Writer {
+	// open output file and keep the file descriptor around
+	spiFile, err := os.Create(spiFn)
+	if err != nil {
+		fmt.Printf("[opc.%s] Error opening SPI file:\n", name)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return &lpd8806Writer{spiFile: spiFile}
+}
+
+func (w *lpd8806Writer) Close() {
+	if err := w.spiFile.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// HACK
+// white balance for the strips with white backing
+// red needs a boost
+// green and blue are too strong
+// Return how much to scale each channel of the given pixel.
+func lpd8806WhiteBalance(pixel int) (r, g, b float64) {
+	if pixel >= 160 {
+		return 1, 0.8, 0.7
+	}
+	return 1, 1, 1
+}
+
+// Send one frame of 7-bit levels (0 to 127) in [r g b  r g b ...] order.
+// This chipset expects colors in G R B order; this function is responsible for swapping from
+// the usual R G B order.
+func (w *lpd8806Writer) write(levels []byte) {
+	// build a new slice of bytes in the format the LED strand wants
+	// TODO: avoid allocating these bytes over and over
+	spiBytes := make([]byte, 0)
+
+	// leading zeros to begin a new frame of bytes
+	numZeroes := (len(levels)+31)/32 + 2
+	for ii := 0; ii < numZeroes*5; ii++ {
+		spiBytes = append(spiBytes, 0)
+	}
+
+	// actual bytes
+	for ii := 0; ii < len(levels)-2; ii += 3 {
+		// format for LPD8806
+		// high bit must be always on, remaining seven bits are data
+		r := 128 | levels[ii+0]
+		g := 128 | levels[ii+1]
+		b := 128 | levels[ii+2]
+		// swap to [g r b] order
+		if ii < 160*3 {
+			// copper-colored strip
+			spiBytes = append(spiBytes, g)
+			spiBytes = append(spiBytes, r)
+			spiBytes = append(spiBytes, b)
+		} else {
+			// white strips
+			spiBytes = append(spiBytes, b)
+			spiBytes = append(spiBytes, r)
+			spiBytes = append(spiBytes, g)
+		}
+	}
+
+	// send some extra black pixels to make the last LEDs latch
+	for ii := 0; ii < 6; ii++ {
+		spiBytes = append(spiBytes, 128)
+	}
+
+	// write spiBytes to the wire in chunks
+	//fmt.Println("sending", len(levels), " + ", numZeroes, " zeroes = ", len(spiBytes), "bytes")
+	bytesSent := 0
+	for ii := 0; ii < len(spiBytes); ii += SPI_CHUNK_SIZE {
+		endIndex := ii + SPI_CHUNK_SIZE
+		if endIndex > len(spiBytes) {
+			endIndex = len(spiBytes)
+		}
+		thisChunk := spiBytes[ii:endIndex]
+		bytesSent += len(thisChunk)
+		if _, err := w.spiFile.Write(thisChunk); err != nil {
+			panic(err)
+		}
+	}
+	//fmt.Println(bytesSent,len(spiBytes))
+}
+
+// Return a lookup table from bytes to gamma-corrected bytes.
+func makeGammaLookup() []byte {
+	gamma_lookup := make([]byte, 256)
+	for ii := 0; ii < 256; ii++ {
+		floatVal := math.Pow(float64(ii)/255, GAMMA)
+		if floatVal >= 1 {
+			gamma_lookup[ii] = 255
+		} else {
+			gamma_lookup[ii] = byte(floatVal * 256)
+		}
+	}
+	return gamma_lookup
+}
+
 // Return a ByteThread which writes bytes to SPI via the given filename (such as "/dev/spidev1.0").
 // Format the outgoing bytes for LED strips which use the LPD8806 chipset.
 // If the SPI device can't be opened, exit the whole program with exit status 1.
-// This chipset expects colors in G R B order; this function is responsible for swapping from
-// the usual R G B order.
 func MakeSendToLPD8806Thread(spiFn string) ByteThread {
 	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
 		fmt.Println("[opc.SendToLPD8806Thread] starting up")
 
-		// open output file and keep the file descriptor around
-		spiFile, err := os.Create(spiFn)
-		if err != nil {
-			fmt.Println("[opc.SendToLPD8806Thread] Error opening SPI file:")
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		// close spiFile on exit and check for its returned error
-		defer func() {
-			if err := spiFile.Close(); err != nil {
-				panic(err)
-			}
-		}()
+		lpd := openLPD8806(spiFn, "SendToLPD8806Thread")
+		defer lpd.Close()
 
-		gamma_lookup := make([]byte, 256)
-		for ii := 0; ii < 256; ii++ {
-			floatVal := math.Pow(float64(ii)/255, GAMMA)
-			if floatVal >= 1 {
-				gamma_lookup[ii] = 255
-			} else {
-				gamma_lookup[ii] = byte(floatVal * 256)
-			}
-		}
+		gamma_lookup := makeGammaLookup()
 
 		// as we get byte slices over the channel...
+		levels := make([]byte, 0)
 		for bytes := range bytesIn {
-			// build a new slice of bytes in the format the LED strand wants
-			// TODO: avoid allocating these bytes over and over
-			spiBytes := make([]byte, 0)
-
-			// leading zeros to begin a new frame of bytes
-			numZeroes := (len(bytes)+31)/32 + 2
-			for ii := 0; ii < numZeroes*5; ii++ {
-				spiBytes = append(spiBytes, 0)
-			}
-
-			// actual bytes
-			//for _, v := range bytes {
+			levels = levels[:0]
 			for ii := 0; ii < len(bytes)-2; ii += 3 {
 				// apply gamma lookup table
 				r := gamma_lookup[bytes[ii+0]]
 				g := gamma_lookup[bytes[ii+1]]
 				b := gamma_lookup[bytes[ii+2]]
 
-				// HACK
-				// white balance for the strips with white backing
-				// red needs a boost
-				// green and blue are too strong
-				if ii >= 160*3 {
-					//r = byte(math.Pow(float64(r)/256.0, 0.7) * 256.0)
-					g = byte(float64(g) * 0.8)
-					b = byte(float64(b) * 0.7)
-				}
+				wr, wg, wb := lpd8806WhiteBalance(ii / 3)
+				r = byte(float64(r) * wr)
+				g = byte(float64(g) * wg)
+				b = byte(float64(b) * wb)
 
-				// format for LPD8806
-				// high bit must be always on, remaining seven bits are data
-				r = 128 | (r >> 1)
-				g = 128 | (g >> 1)
-				b = 128 | (b >> 1)
-				// swap to [g r b] order
-				if ii < 160*3 {
-					// copper-colored strip
-					spiBytes = append(spiBytes, g)
-					spiBytes = append(spiBytes, r)
-					spiBytes = append(spiBytes, b)
-				} else {
-					// white strips
-					spiBytes = append(spiBytes, b)
-					spiBytes = append(spiBytes, r)
-					spiBytes = append(spiBytes, g)
-				}
+				// the chipset only has seven bits per channel
+				levels = append(levels, r>>1, g>>1, b>>1)
 			}
+			lpd.write(levels)
 
-			// send some extra black pixels to make the last LEDs latch
-			for ii := 0; ii < 6; ii++ {
-				spiBytes = append(spiBytes, 128)
-			}
+			bytesOut <- bytes
+		}
+	}
+}
 
-			// write spiBytes to the wire in chunks
-			//fmt.Println("sending", len(bytes), " + ", numZeroes, " zeroes = ", len(spiBytes), "bytes")
-			bytesSent := 0
-			for ii := 0; ii < len(spiBytes); ii += SPI_CHUNK_SIZE {
-				endIndex := ii + SPI_CHUNK_SIZE
-				if endIndex > len(spiBytes) {
-					endIndex = len(spiBytes)
-				}
-				thisChunk := spiBytes[ii:endIndex]
-				bytesSent += len(thisChunk)
-				if _, err := spiFile.Write(thisChunk); err != nil {
-					panic(err)
-				}
+// Return a FloatThread which writes high-precision colors to LPD8806 strips like
+// MakeSendToLPD8806Thread, dithering them down to the chipset's seven bits.
+func MakeSendToLPD8806FloatThread(spiFn string) FloatThread {
+	return func(colorsIn chan []float32, colorsOut chan []float32, midiState *midi.MidiState) {
+		fmt.Println("[opc.SendToLPD8806FloatThread] starting up")
+
+		lpd := openLPD8806(spiFn, "SendToLPD8806FloatThread")
+		defer lpd.Close()
+
+		dither := &ditherer{}
+		levels := make([]byte, 0)
+		for colors := range colorsIn {
+			dither.resize(len(colors))
+			levels = levels[:0]
+			for ii := 0; ii < len(colors)-2; ii += 3 {
+				wr, wg, wb := lpd8806WhiteBalance(ii / 3)
+				r := dither.quantize(ii+0, math.Pow(math.Max(float64(colors[ii+0]), 0), GAMMA)*wr, 127)
+				g := dither.quantize(ii+1, math.Pow(math.Max(float64(colors[ii+1]), 0), GAMMA)*wg, 127)
+				b := dither.quantize(ii+2, math.Pow(math.Max(float64(colors[ii+2]), 0), GAMMA)*wb, 127)
+				levels = append(levels, byte(r), byte(g), byte(b))
 			}
-			//fmt.Println(bytesSent,len(spiBytes))
+			lpd.write(levels)
 
-			bytesOut <- bytes
+			colorsOut <- colors
 		}
 	}
 }
 
+// A long-lived connection to an OPC server.  If the connection is bad at any point
+// (or was never good to begin with), it tries to reconnect whenever there's a new frame.
+type opcConnection struct {
+	ipPort string
+	name   string // for the log
+	conn   net.Conn
+}
+
+// Send the bytes as an OPC message with its header.  Silently drop them if it's not possible.
+// Return false if there's no connection, in which case the caller should wait WAIT_TO_RETRY
+// before trying again.
+func (c *opcConnection) send(bytes []byte) bool {
+	// if the connection has gone bad, make a new one
+	if c.conn == nil {
+		c.conn = getConnection(c.ipPort)
+	}
+	if c.conn == nil {
+		return false
+	}
+
+	// ok, at this point the connection is good
+
+	// make and send OPC header
+	channel := byte(0)
+	command := byte(0)
+	lenLowByte := byte(len(bytes) % 256)
+	lenHighByte := byte(len(bytes) / 256)
+	header := []byte{channel, command, lenHighByte, lenLowByte}
+	if _, err := c.conn.Write(header); err != nil {
+		// net error -- set conn to nil so we can try to make a new one
+		fmt.Printf("[opc.%s] %v\n", c.name, err)
+		c.conn = nil
+		return true
+	}
+
+	// send actual pixel values
+	if _, err := c.conn.Write(bytes); err != nil {
+		// net error -- set conn to nil so we can try to make a new one
+		fmt.Printf("[opc.%s] %v\n", c.name, err)
+		c.conn = nil
+	}
+	return true
+}
+
 // Return a ByteThread which sends the bytes out as OPC messages to the given ipPort.
 // Create OPC headers for each byte slice it sends.
 // Initiate and maintains a long-lived connection to ipPort.  If the connection is bad at any point
@@ -289,66 +583,56 @@ func MakeSendToOpcThread(ipPort string) ByteThread {
 	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
 		fmt.Println("[opc.SendToOpcThread] starting up")
 
-		var conn net.Conn
-		var err error
-
-		gamma_lookup := make([]byte, 256)
-		for ii := 0; ii < 256; ii++ {
-			floatVal := math.Pow(float64(ii)/255, GAMMA)
-			if floatVal >= 1 {
-				gamma_lookup[ii] = 255
-			} else {
-				gamma_lookup[ii] = byte(floatVal * 256)
-			}
-		}
+		conn := &opcConnection{ipPort: ipPort, name: "SendToOpcThread"}
+		gamma_lookup := makeGammaLookup()
 
 		for bytes := range bytesIn {
-			// if the connection has gone bad, make a new one
-			if conn == nil {
-				conn = getConnection(ipPort)
+			// gamma correct
+			// HACK: change this later when we decide if OPC should have
+			// pixels in perceptual or linear space
+			for ii := range bytes {
+				bytes[ii] = gamma_lookup[bytes[ii+0]]
 			}
+
 			// if that didn't work, wait a second and restart the loop
-			if conn == nil {
+			if !conn.send(bytes) {
 				bytesOut <- bytes
 				fmt.Println("[opc.SendToOpcThread] waiting to retry")
 				time.Sleep(WAIT_TO_RETRY * time.Millisecond)
 				continue
 			}
+			bytesOut <- bytes
+		}
+	}
+}
 
-			// ok, at this point the connection is good
+// Return a FloatThread which sends high-precision colors to the given ipPort like
+// MakeSendToOpcThread, dithering them down to bytes after gamma correction.
+func MakeSendToOpcFloatThread(ipPort string) FloatThread {
+	return func(colorsIn chan []float32, colorsOut chan []float32, midiState *midi.MidiState) {
+		fmt.Println("[opc.SendToOpcFloatThread] starting up")
 
-			// gamma correct
-			// HACK: change this later when we decide if OPC should have
-			// pixels in perceptual or linear space
-			for ii := range bytes {
-				bytes[ii] = gamma_lookup[bytes[ii+0]]
-			}
+		conn := &opcConnection{ipPort: ipPort, name: "SendToOpcFloatThread"}
+		dither := &ditherer{}
+		bytes := make([]byte, 0)
 
-			// make and send OPC header
-			channel := byte(0)
-			command := byte(0)
-			lenLowByte := byte(len(bytes) % 256)
-			lenHighByte := byte(len(bytes) / 256)
-			header := []byte{channel, command, lenHighByte, lenLowByte}
-			_, err = conn.Write(header)
-			if err != nil {
-				// net error -- set conn to nil so we can try to make a new one
-				fmt.Println("[opc.SendToOpcThread]", err)
-				conn = nil
-				bytesOut <- bytes
-				continue
+		for colors := range colorsIn {
+			dither.resize(len(colors))
+			if len(bytes) != len(colors) {
+				bytes = make([]byte, len(colors))
+			}
+			for ii, c := range colors {
+				bytes[ii] = byte(dither.quantize(ii, math.Pow(math.Max(float64(c), 0), GAMMA), 255))
 			}
 
-			// send actual pixel values
-			_, err = conn.Write(bytes)
-			if err != nil {
-				// net error -- set conn to nil so we can try to make a new one
-				fmt.Println("[opc.SendToOpcThread]", err)
-				conn = nil
-				bytesOut <- bytes
+			// if that didn't work, wait a second and restart the loop
+			if !conn.send(bytes) {
+				colorsOut <- colors
+				fmt.Println("[opc.SendToOpcFloatThread] waiting to retry")
+				time.Sleep(WAIT_TO_RETRY * time.Millisecond)
 				continue
 			}
-			bytesOut <- bytes
+			colorsOut <- colors
 		}
 	}
 }
@@ -0,0 +1,77 @@
+package opc
+
+import (
+	"testing"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestRunStage(t *testing.T) {
+	// lights the first channel and leaves the rest alone
+	var byteThread ByteThread = func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
+		for bytes := range bytesIn {
+			bytes[0] = 255
+			bytesOut <- bytes
+		}
+	}
+	// halves every channel
+	var floatThread FloatThread = func(colorsIn chan []float32, colorsOut chan []float32, midiState *midi.MidiState) {
+		for colors := range colorsIn {
+			for ii := range colors {
+				colors[ii] /= 2
+			}
+			colorsOut <- colors
+		}
+	}
+
+	framesIn := make(chan *Frame)
+	framesMid := make(chan *Frame)
+	framesOut := make(chan *Frame)
+	RunStage(byteThread, framesIn, framesMid)
+	RunStage(floatThread, framesMid, framesOut)
+	defer close(framesIn)
+	midiState := &midi.MidiState{}
+
+	// a high-precision frame keeps its precision wherever the ByteThread leaves a pixel alone.
+	// the pixel it touched is taken from its bytes.
+	framesIn <- &Frame{Bytes: make([]byte, 6), Colors: []float32{0.5, 0.1234, 0.0001, 0.5, 0.1234, 0.0001}, MidiState: midiState}
+	frame := <-framesOut
+	want := []float32{0.5, float32(31) / 255 / 2, 0, 0.25, 0.0617, 0.00005}
+	for ii, c := range frame.Colors {
+		if c != want[ii] {
+			t.Errorf("high-precision frame: got %v, want %v", frame.Colors, want)
+			break
+		}
+	}
+
+	// an 8-bit frame goes through the FloatThread and comes back as bytes
+	framesIn <- &Frame{Bytes: []byte{0, 200, 7}, MidiState: midiState}
+	frame = <-framesOut
+	if string(frame.Bytes) != string([]byte{128, 100, 3}) || frame.Colors != nil {
+		t.Errorf("8-bit frame: got %v %v, want [128 100 3] and no colors", frame.Bytes, frame.Colors)
+	}
+}
+
+func TestRunStageOwnSlice(t *testing.T) {
+	// sends back its own slice, which happens to match what it was given, like the OPC server might
+	var byteThread ByteThread = func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
+		mine := []byte{128, 31, 0}
+		for range bytesIn {
+			bytesOut <- mine
+		}
+	}
+	framesIn := make(chan *Frame)
+	framesOut := make(chan *Frame)
+	RunStage(byteThread, framesIn, framesOut)
+	defer close(framesIn)
+
+	framesIn <- &Frame{Bytes: make([]byte, 3), Colors: []float32{0.5, 0.1234, 0.0001}, MidiState: &midi.MidiState{}}
+	frame := <-framesOut
+	want := []float32{128.0 / 255, 31.0 / 255, 0}
+	for ii, c := range frame.Colors {
+		if c != want[ii] {
+			t.Errorf("got %v, want the whole frame from the thread's bytes %v", frame.Colors, want)
+			break
+		}
+	}
+}
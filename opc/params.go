@@ -0,0 +1,286 @@
+package opc
+
+// Pattern parameters
+//   Patterns and effects declare named, typed, ranged parameters instead of
+//   burying their tuning constants in const blocks.  Values can be set from
+//   the command line, from a show config file, or at runtime through PARAMS,
+//   and each parameter can be bound to a MIDI controller.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/midi"
+)
+
+//--------------------------------------------------------------------------------
+// PARAM TYPE
+
+// kinds of parameters
+const (
+	PARAM_FLOAT = "float"
+	PARAM_INT   = "int"
+	PARAM_BOOL  = "bool"
+)
+
+// A single tunable value exposed by a pattern or effect.
+// Name should be "pattern.param", for example "fire.speed".
+// All kinds are stored as float64; bools are 0 or 1.
+type Param struct {
+	Name        string
+	Kind        string // one of PARAM_FLOAT, PARAM_INT, PARAM_BOOL
+	Min         float64
+	Max         float64
+	Default     float64
+	Description string
+}
+
+// Clamp v to the parameter's range and round it if the parameter isn't a float.
+func (p *Param) Constrain(v float64) float64 {
+	v = colorutils.Clamp(v, p.Min, p.Max)
+	switch p.Kind {
+	case PARAM_INT:
+		v = math.Floor(v + 0.5)
+	case PARAM_BOOL:
+		if v >= 0.5 {
+			v = 1
+		} else {
+			v = 0
+		}
+	}
+	return v
+}
+
+// Parse a value for this parameter from a string such as "0.7", "3", or "true".
+func (p *Param) Parse(s string) (float64, error) {
+	if p.Kind == PARAM_BOOL {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return 0, fmt.Errorf("param %s expects true or false, got %q", p.Name, s)
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("param %s expects a number, got %q", p.Name, s)
+	}
+	if v < p.Min || v > p.Max {
+		return 0, fmt.Errorf("param %s must be between %v and %v, got %v", p.Name, p.Min, p.Max, v)
+	}
+	return p.Constrain(v), nil
+}
+
+// Convert the Param to a one-line human-readable description for the help output.
+func (p *Param) String() string {
+	def := strconv.FormatFloat(p.Default, 'g', -1, 64)
+	if p.Kind == PARAM_BOOL {
+		def = strconv.FormatBool(p.Default != 0)
+		return fmt.Sprintf("%s (bool, default %s) %s", p.Name, def, p.Description)
+	}
+	return fmt.Sprintf("%s (%s %v-%v, default %s) %s", p.Name, p.Kind, p.Min, p.Max, def, p.Description)
+}
+
+//--------------------------------------------------------------------------------
+// PARAM STORE
+
+// Holds the schema and current value of every registered parameter, and
+// which MIDI controllers they are bound to.
+// It's safe to use from several goroutines at once so that control servers
+// can change values while patterns are reading them.
+type ParamStore struct {
+	mutex    sync.RWMutex
+	params   map[string]*Param
+	values   map[string]float64
+	bindings map[string]byte // param name --> midi controller number
+}
+
+// The parameters of all the patterns and effects in this package.
+var PARAMS = NewParamStore()
+
+func NewParamStore() *ParamStore {
+	return &ParamStore{
+		params:   make(map[string]*Param),
+		values:   make(map[string]float64),
+		bindings: make(map[string]byte),
+	}
+}
+
+// Add parameters to the store and set them to their default values.
+// Registering a name twice keeps the existing value.
+func (ps *ParamStore) Register(params []*Param) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	for _, p := range params {
+		if _, ok := ps.params[p.Name]; ok {
+			continue
+		}
+		ps.params[p.Name] = p
+		ps.values[p.Name] = p.Constrain(p.Default)
+	}
+}
+
+// Return the schema of the named parameter, or nil if there isn't one.
+func (ps *ParamStore) Lookup(name string) *Param {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.params[name]
+}
+
+// Return the sorted names of all registered parameters.
+func (ps *ParamStore) Names() []string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	names := make([]string, 0, len(ps.params))
+	for name := range ps.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set a parameter's value.  Out-of-range values are clamped.
+// Setting a value removes any MIDI binding the parameter had.
+func (ps *ParamStore) Set(name string, v float64) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	p, ok := ps.params[name]
+	if !ok {
+		return fmt.Errorf("unknown param %q", name)
+	}
+	ps.values[name] = p.Constrain(v)
+	delete(ps.bindings, name)
+	return nil
+}
+
+// A parameter's value and binding, saved so that a temporary change can be undone.
+type paramSetting struct {
+	name       string
+	value      float64
+	controller byte
+	bound      bool
+}
+
+// Return the named parameter's current value and binding.
+func (ps *ParamStore) save(name string) paramSetting {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	controller, bound := ps.bindings[name]
+	return paramSetting{name: name, value: ps.values[name], controller: controller, bound: bound}
+}
+
+// Put a parameter back the way save found it.
+func (ps *ParamStore) restore(setting paramSetting) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if _, ok := ps.params[setting.name]; !ok {
+		return
+	}
+	ps.values[setting.name] = setting.value
+	if setting.bound {
+		ps.bindings[setting.name] = setting.controller
+	} else {
+		delete(ps.bindings, setting.name)
+	}
+}
+
+// Parse and apply an assignment like "fire.speed=0.7".
+func (ps *ParamStore) SetFromString(assignment string) error {
+	name, valueStr, err := splitAssignment(assignment)
+	if err != nil {
+		return err
+	}
+	p := ps.Lookup(name)
+	if p == nil {
+		return fmt.Errorf("unknown param %q", name)
+	}
+	v, err := p.Parse(valueStr)
+	if err != nil {
+		return err
+	}
+	return ps.Set(name, v)
+}
+
+// Apply a value from a JSON config file, which may be a number, a bool, or a string such as "0.7".
+func (ps *ParamStore) SetFromJSON(name string, raw json.RawMessage) error {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return fmt.Errorf("param %s: %v", name, err)
+	}
+	switch v := value.(type) {
+	case float64:
+		return ps.SetFromString(name + "=" + strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		return ps.SetFromString(name + "=" + strconv.FormatBool(v))
+	case string:
+		return ps.SetFromString(name + "=" + v)
+	}
+	return fmt.Errorf("param %s expects a number, bool or string, got %s", name, string(raw))
+}
+
+// Bind a parameter to a MIDI controller.  The controller's 0-127 range is
+// spread across the parameter's Min-Max range.
+func (ps *ParamStore) Bind(name string, controller byte) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if _, ok := ps.params[name]; !ok {
+		return fmt.Errorf("unknown param %q", name)
+	}
+	if controller > 127 {
+		return fmt.Errorf("param %s: controller number must be 0-127, got %v", name, controller)
+	}
+	ps.bindings[name] = controller
+	return nil
+}
+
+// Parse and apply a binding like "fire.speed=3".
+func (ps *ParamStore) BindFromString(assignment string) error {
+	name, valueStr, err := splitAssignment(assignment)
+	if err != nil {
+		return err
+	}
+	controller, err := strconv.ParseUint(valueStr, 10, 8)
+	if err != nil {
+		return fmt.Errorf("binding for %s expects a controller number, got %q", name, valueStr)
+	}
+	return ps.Bind(name, byte(controller))
+}
+
+// Return the current value of the named parameter.
+// If the parameter is bound to a MIDI controller, the value comes from midiState.
+// Unknown names return 0.
+func (ps *ParamStore) Get(name string, midiState *midi.MidiState) float64 {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	p, ok := ps.params[name]
+	if !ok {
+		return 0
+	}
+	if controller, ok := ps.bindings[name]; ok && midiState != nil {
+		knob := float64(midiState.ControllerValues[controller]) / 127.0
+		return p.Constrain(colorutils.Remap(knob, 0, 1, p.Min, p.Max))
+	}
+	return ps.values[name]
+}
+
+// Like Get, but for PARAM_BOOL parameters.
+func (ps *ParamStore) GetBool(name string, midiState *midi.MidiState) bool {
+	return ps.Get(name, midiState) != 0
+}
+
+// Split "name=value" into its two halves.
+func splitAssignment(assignment string) (name, value string, err error) {
+	parts := strings.SplitN(assignment, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expected name=value, got %q", assignment)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
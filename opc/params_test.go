@@ -0,0 +1,162 @@
+package opc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+func newTestParamStore() *ParamStore {
+	ps := NewParamStore()
+	ps.Register([]*Param{
+		{"test.speed", PARAM_FLOAT, 0, 2, 0.5, ""},
+		{"test.count", PARAM_INT, 1, 10, 3, ""},
+		{"test.on", PARAM_BOOL, 0, 1, 0, ""},
+	})
+	return ps
+}
+
+func TestParamStoreRegister(t *testing.T) {
+	ps := newTestParamStore()
+	if v := ps.Get("test.speed", nil); v != 0.5 {
+		t.Errorf("test.speed = %v, want the default 0.5", v)
+	}
+	ps.Set("test.speed", 1.5)
+	// registering again keeps the value
+	ps.Register([]*Param{{"test.speed", PARAM_FLOAT, 0, 2, 0.5, ""}})
+	if v := ps.Get("test.speed", nil); v != 1.5 {
+		t.Errorf("test.speed = %v after registering twice, want 1.5", v)
+	}
+	if v := ps.Get("test.missing", nil); v != 0 {
+		t.Errorf("unknown param = %v, want 0", v)
+	}
+	if names := ps.Names(); len(names) != 3 || names[0] != "test.count" {
+		t.Errorf("names = %v", names)
+	}
+}
+
+func TestParamStoreSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  float64
+	}{
+		{"test.speed", 0.7, 0.7},
+		{"test.speed", 5, 2}, // clamped
+		{"test.count", 4.6, 5},
+		{"test.on", 0.8, 1},
+	}
+	for _, tt := range tests {
+		ps := newTestParamStore()
+		if err := ps.Set(tt.name, tt.value); err != nil {
+			t.Errorf("Set(%s, %v): %v", tt.name, tt.value, err)
+		}
+		if got := ps.Get(tt.name, nil); got != tt.want {
+			t.Errorf("Set(%s, %v) then Get = %v, want %v", tt.name, tt.value, got, tt.want)
+		}
+	}
+	if err := newTestParamStore().Set("test.missing", 1); err == nil {
+		t.Error("no error setting an unknown param")
+	}
+}
+
+func TestParamStoreSetFromString(t *testing.T) {
+	tests := []struct {
+		assignment string
+		name       string
+		want       float64
+		wantErr    bool
+	}{
+		{"test.speed=0.7", "test.speed", 0.7, false},
+		{" test.speed = 1 ", "test.speed", 1, false},
+		{"test.on=true", "test.on", 1, false},
+		{"test.count=7", "test.count", 7, false},
+		{"test.speed=3", "", 0, true}, // out of range
+		{"test.on=maybe", "", 0, true},
+		{"test.speed=fast", "", 0, true},
+		{"test.speed", "", 0, true},
+		{"test.missing=1", "", 0, true},
+	}
+	for _, tt := range tests {
+		ps := newTestParamStore()
+		err := ps.SetFromString(tt.assignment)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetFromString(%q) error = %v, want error %v", tt.assignment, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && ps.Get(tt.name, nil) != tt.want {
+			t.Errorf("SetFromString(%q) gave %v, want %v", tt.assignment, ps.Get(tt.name, nil), tt.want)
+		}
+	}
+}
+
+func TestParamStoreSetFromJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    float64
+		wantErr bool
+	}{
+		{"test.speed", `0.7`, 0.7, false},
+		{"test.speed", `"0.7"`, 0.7, false},
+		{"test.on", `true`, 1, false},
+		{"test.on", `"true"`, 1, false},
+		{"test.count", `7`, 7, false},
+		{"test.speed", `3`, 0, true},
+		{"test.speed", `[1]`, 0, true},
+		{"test.speed", `{`, 0, true},
+	}
+	for _, tt := range tests {
+		ps := newTestParamStore()
+		err := ps.SetFromJSON(tt.name, json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetFromJSON(%s, %s) error = %v, want error %v", tt.name, tt.raw, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && ps.Get(tt.name, nil) != tt.want {
+			t.Errorf("SetFromJSON(%s, %s) gave %v, want %v", tt.name, tt.raw, ps.Get(tt.name, nil), tt.want)
+		}
+	}
+}
+
+func TestParamStoreBind(t *testing.T) {
+	ps := newTestParamStore()
+	midiState := &midi.MidiState{}
+	if err := ps.BindFromString("test.speed=3"); err != nil {
+		t.Fatal(err)
+	}
+	// the knob's range is spread across the param's
+	midiState.ControllerValues[3] = 127
+	if v := ps.Get("test.speed", midiState); v != 2 {
+		t.Errorf("bound to a full knob = %v, want 2", v)
+	}
+	midiState.ControllerValues[3] = 0
+	if v := ps.Get("test.speed", midiState); v != 0 {
+		t.Errorf("bound to an empty knob = %v, want 0", v)
+	}
+	// without a midi state the stored value is used
+	if v := ps.Get("test.speed", nil); v != 0.5 {
+		t.Errorf("bound without midi = %v, want 0.5", v)
+	}
+
+	// bools follow the knob past halfway
+	ps.Bind("test.on", 4)
+	midiState.ControllerValues[4] = 100
+	if !ps.GetBool("test.on", midiState) {
+		t.Error("test.on is false with its knob at 100")
+	}
+
+	// setting a value unbinds it
+	ps.Set("test.speed", 1)
+	midiState.ControllerValues[3] = 127
+	if v := ps.Get("test.speed", midiState); v != 1 {
+		t.Errorf("after Set = %v, want 1", v)
+	}
+
+	for _, binding := range []string{"test.missing=3", "test.speed=knob", "test.speed=300"} {
+		if err := ps.BindFromString(binding); err == nil {
+			t.Errorf("no error binding %q", binding)
+		}
+	}
+}
@@ -58,7 +58,7 @@ func MakePatternArchimedes(locations []float64) ByteThread {
 
 				//noi := math.Abs(rand.Float64() *0.000000000000001)
 
-                dirKnob := (float64(midiState.ControllerValues[config.MORPH_KNOB]) - 64.0)/8.0  
+                dirKnob := (config.MORPH_KNOB.Value(midiState) - 0.5)*16.0  
 
 				//noi := 1.0
 				//reverse_periodicity := 20.0
@@ -81,7 +81,7 @@ func MakePatternArchimedes(locations []float64) ByteThread {
 				noii := math.Abs(rand.Float64() * 0.000000000000)
 				//fmt.Println(noii)
 				//noii = 0.0
-			    speedKnob := float64(midiState.ControllerValues[config.SPEED_KNOB]) / 127.0
+			    speedKnob := config.SPEED_KNOB.Value(midiState)
 				spiral1 := Spiral(x, y, t, 0.1*noi, (speedKnob*2)+noii, 0.05, 0.9, 4)
 				spiral2 := Spiral(x, y, t, -0.1*noi, (speedKnob*4)+noii, 0.05, 0.5, 4)
 				spiral3 := Spiral(x, y, t, -0.05*noi, (speedKnob*8)+noii, 0.1, 0.3, 8)
@@ -120,7 +120,7 @@ func MakePatternArchimedes(locations []float64) ByteThread {
 				g := 0.0
 				b := 0.0
 				//s1 := math.Pow(colorutils.Cos(t, 0, 20.0, 0, 1), 0.2)
-                s1 := float64(midiState.ControllerValues[config.HUE_KNOB]) / 127.0
+                s1 := config.HUE_KNOB.Value(midiState)
 				//fmt.Println(s1)
 				r1 := 1 - s1
 				//fmt.Println(altColors[1])
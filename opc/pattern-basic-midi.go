@@ -10,16 +10,19 @@ import (
 	"time"
 )
 
+var BASIC_MIDI_PARAMS = []*Param{
+	{"basic-midi.volume-gain", PARAM_FLOAT, 0, 4, 1.5, "Multiply incoming midi volumes by this much."},
+	{"basic-midi.brightness-min", PARAM_FLOAT, 0, 1, 0.3, "Midi volume 1/127, after volume-gain, is remapped to this."},
+	{"basic-midi.brightness-max", PARAM_FLOAT, 0, 1, 1.0, "Midi volume 127, after volume-gain, is remapped to this."},
+	{"basic-midi.seconds-to-fade", PARAM_FLOAT, 0.01, 5, 0.3, "How long it takes to fade to black after a key is lifted."},
+	{"basic-midi.sustain", PARAM_BOOL, 0, 1, 1, "Leave lights on while keys are held down."},
+}
+
 func MakePatternBasicMidi(locations []float64) ByteThread {
 	var (
-		MIDI_VOLUME_GAIN    = 1.5  // multiply incoming midi volumes by this much
-		MIDI_BRIGHTNESS_MIN = 0.3  // midi volume 1/127, after MIDI_VOLUME_GAIN, is remapped to this
-		MIDI_BRIGHTNESS_MAX = 1.0  // midi volume 127, after MIDI_VOLUME_GAIN, is remapped to this
-		SECONDS_TO_FADE     = 0.3  // how long it takes to fade to black after key is lifted
 		COLOR_BLEEDING_RAD  = 3    // radius of glow effect around pressed keys.  set to 0 for no bleeding
 		COLOR_BLEEDING_GAIN = 0.2  // brightness of glow effect (range 0-1)
 		MIN_VISIBLE_COLOR   = 0.04 // min pixel brightness which is actually visible (range 0-1)
-		SUSTAIN             = true // leave lights on while keys are held down
 	)
 
 	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
@@ -35,6 +38,12 @@ func MakePatternBasicMidi(locations []float64) ByteThread {
 			t := float64(time.Now().UnixNano())/1.0e9 - 9.4e8
 			tDiff := colorutils.Clamp(t-last_t, 0, 5) // limit to max of 5 second to avoid pathological value at startup
 
+			MIDI_VOLUME_GAIN := PARAMS.Get("basic-midi.volume-gain", midiState)
+			MIDI_BRIGHTNESS_MIN := PARAMS.Get("basic-midi.brightness-min", midiState)
+			MIDI_BRIGHTNESS_MAX := PARAMS.Get("basic-midi.brightness-max", midiState)
+			SECONDS_TO_FADE := PARAMS.Get("basic-midi.seconds-to-fade", midiState)
+			SUSTAIN := PARAMS.GetBool("basic-midi.sustain", midiState)
+
 			// update keyVolumes from MidiState
 			if !SUSTAIN {
 				// if not sustaining, reset volumes to zero each frame
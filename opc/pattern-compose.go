@@ -0,0 +1,311 @@
+package opc
+
+// Compose
+//   Stacks several patterns as layers, bottom first, using the compose section of
+//   the show config.  Each layer has a blend mode, an opacity parameter which can be
+//   bound to a knob, and an optional mask which limits it to a group of pixels or a
+//   slice of the layout.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+// blend modes
+const (
+	BLEND_NORMAL   = "normal"   // the layer covers what's below it
+	BLEND_ADD      = "add"      // add the layer's light to what's below it
+	BLEND_MULTIPLY = "multiply" // darken what's below by the layer's color
+	BLEND_SCREEN   = "screen"   // like add, but gentler near white
+	BLEND_MAX      = "max"      // the brighter value of each channel
+	BLEND_LIGHTEN  = "lighten"  // the brighter of the two whole pixels
+)
+
+// Each layer up to this many gets an opacity parameter.
+const MAX_COMPOSE_LAYERS = 8
+
+// Return an error if mode isn't one of the BLEND_* constants.
+func CheckBlendMode(mode string) error {
+	switch mode {
+	case BLEND_NORMAL, BLEND_ADD, BLEND_MULTIPLY, BLEND_SCREEN, BLEND_MAX, BLEND_LIGHTEN:
+		return nil
+	}
+	return fmt.Errorf("unknown blend mode %q", mode)
+}
+
+// Return the name of the opacity parameter for a layer, counting from 0 at the bottom.
+func layerOpacityParam(index int) string {
+	return fmt.Sprintf("compose.layer%d.opacity", index+1)
+}
+
+var COMPOSE_PARAMS = func() []*Param {
+	params := make([]*Param, MAX_COMPOSE_LAYERS)
+	for ii := range params {
+		params[ii] = &Param{layerOpacityParam(ii), PARAM_FLOAT, 0, 1, 1, fmt.Sprintf("Opacity of compose layer %d, counting from the bottom.", ii+1)}
+	}
+	return params
+}()
+
+// Used when the show config doesn't have any layers.
+var DEFAULT_COMPOSE_LAYERS = []config.LayerConfig{
+	{Pattern: "sunset"},
+	{Pattern: "basic-midi", Blend: BLEND_ADD},
+}
+
+// Return which pixels are in a group of index ranges like "0-159" or "200".
+func parsePixelGroup(ranges []string, n_pixels int) ([]bool, error) {
+	inGroup := make([]bool, n_pixels)
+	for _, r := range ranges {
+		bounds := strings.SplitN(strings.TrimSpace(r), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad pixel range %q", r)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("bad pixel range %q", r)
+			}
+		}
+		for ii := first; ii <= last && ii < n_pixels; ii++ {
+			if ii >= 0 {
+				inGroup[ii] = true
+			}
+		}
+	}
+	return inGroup, nil
+}
+
+// Return how much of a layer shows at each pixel, from 0 to 1.
+func makeMask(maskConfig *config.MaskConfig, locations []float64) ([]float64, error) {
+	n_pixels := len(locations) / 3
+	mask := make([]float64, n_pixels)
+	switch {
+	case maskConfig == nil:
+		for ii := range mask {
+			mask[ii] = 1
+		}
+		return mask, nil
+	case maskConfig.Group != "":
+		ranges, ok := config.SHOW.Groups[maskConfig.Group]
+		if !ok {
+			return nil, fmt.Errorf("unknown group %q", maskConfig.Group)
+		}
+		inGroup, err := parsePixelGroup(ranges, n_pixels)
+		if err != nil {
+			return nil, err
+		}
+		for ii := range mask {
+			if inGroup[ii] {
+				mask[ii] = 1
+			}
+		}
+	case len(maskConfig.Axis) == 1 && maskConfig.Axis >= "x" && maskConfig.Axis <= "z":
+		axis := int(maskConfig.Axis[0] - 'x')
+		var minn, maxx float64
+		for ii := 0; ii < n_pixels; ii++ {
+			v := locations[ii*3+axis]
+			if ii == 0 || v < minn {
+				minn = v
+			}
+			if ii == 0 || v > maxx {
+				maxx = v
+			}
+		}
+		soft := maskConfig.Softness
+		for ii := range mask {
+			pos := colorutils.Remap(locations[ii*3+axis], minn, maxx, 0, 1)
+			if soft <= 0 {
+				if pos >= maskConfig.Min && pos <= maskConfig.Max {
+					mask[ii] = 1
+				}
+				continue
+			}
+			fadeIn := colorutils.RemapAndClamp(pos, maskConfig.Min-soft/2, maskConfig.Min+soft/2, 0, 1)
+			fadeOut := colorutils.RemapAndClamp(pos, maskConfig.Max-soft/2, maskConfig.Max+soft/2, 1, 0)
+			mask[ii] = fadeIn * fadeOut
+		}
+	default:
+		return nil, fmt.Errorf("a mask needs a group or an axis of x, y, or z")
+	}
+	if maskConfig.Invert {
+		for ii := range mask {
+			mask[ii] = 1 - mask[ii]
+		}
+	}
+	return mask, nil
+}
+
+type composeLayer struct {
+	pattern      Pattern
+	blend        string
+	mask         []float64
+	opacityParam string
+}
+
+type patternCompose struct {
+	layers      []*composeLayer
+	layerBytes  []byte    // scratch buffer for rendering each layer
+	layerColors []float32 // the same, for high precision
+}
+
+func NewPatternCompose() Pattern {
+	return &patternCompose{}
+}
+
+func (p *patternCompose) Init(locations []float64) {
+	layerConfigs := config.SHOW.Compose.Layers
+	if len(layerConfigs) == 0 {
+		layerConfigs = DEFAULT_COMPOSE_LAYERS
+	}
+	for _, layerConfig := range layerConfigs {
+		name := layerConfig.Pattern
+		if err := checkContained("compose", name); err != nil {
+			fmt.Println("[opc.compose] skipping layer:", err)
+			continue
+		}
+		if len(p.layers) == MAX_COMPOSE_LAYERS {
+			fmt.Printf("[opc.compose] only %d layers are allowed, skipping \"%s\"\n", MAX_COMPOSE_LAYERS, name)
+			continue
+		}
+		blend := layerConfig.Blend
+		if blend == "" {
+			blend = BLEND_NORMAL
+		}
+		if err := CheckBlendMode(blend); err != nil {
+			fmt.Println("[opc.compose]", err, "... using", BLEND_NORMAL)
+			blend = BLEND_NORMAL
+		}
+		mask, err := makeMask(layerConfig.Mask, locations)
+		if err != nil {
+			fmt.Printf("[opc.compose] layer \"%s\": %v ... showing it everywhere\n", name, err)
+			mask, _ = makeMask(nil, locations)
+		}
+
+		pattern := PATTERN_REGISTRY[name].NewPattern()
+		pattern.Init(locations)
+		p.layers = append(p.layers, &composeLayer{
+			pattern:      pattern,
+			blend:        blend,
+			mask:         mask,
+			opacityParam: layerOpacityParam(len(p.layers)),
+		})
+	}
+}
+
+// Blend one pixel of a layer (r, g, b) onto what's below it (r0, g0, b0).
+// Values are from 0 to 255 and the result might be out of range.
+func blendPixel(mode string, r0, g0, b0, r, g, b float64) (float64, float64, float64) {
+	switch mode {
+	case BLEND_ADD:
+		return r0 + r, g0 + g, b0 + b
+	case BLEND_MULTIPLY:
+		return r0 * r / 255, g0 * g / 255, b0 * b / 255
+	case BLEND_SCREEN:
+		return 255 - (255-r0)*(255-r)/255, 255 - (255-g0)*(255-g)/255, 255 - (255-b0)*(255-b)/255
+	case BLEND_MAX:
+		if r < r0 {
+			r = r0
+		}
+		if g < g0 {
+			g = g0
+		}
+		if b < b0 {
+			b = b0
+		}
+		return r, g, b
+	case BLEND_LIGHTEN:
+		if r+g+b < r0+g0+b0 {
+			return r0, g0, b0
+		}
+		return r, g, b
+	}
+	return r, g, b // BLEND_NORMAL
+}
+
+func (p *patternCompose) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	for ii := range bytes {
+		bytes[ii] = 0
+	}
+	if len(p.layerBytes) != len(bytes) {
+		p.layerBytes = make([]byte, len(bytes))
+	}
+	n_pixels := len(bytes) / 3
+
+	for _, layer := range p.layers {
+		// hidden layers don't render, so they pause like the midi-switcher's patterns
+		opacity := PARAMS.Get(layer.opacityParam, midiState)
+		if opacity <= 0 {
+			continue
+		}
+		layer.pattern.Render(p.layerBytes, t, dt, midiState)
+
+		for ii := 0; ii < n_pixels && ii < len(layer.mask); ii++ {
+			amt := opacity * layer.mask[ii]
+			if amt <= 0 {
+				continue
+			}
+			r0 := float64(bytes[ii*3+0])
+			g0 := float64(bytes[ii*3+1])
+			b0 := float64(bytes[ii*3+2])
+			r, g, b := blendPixel(layer.blend, r0, g0, b0,
+				float64(p.layerBytes[ii*3+0]), float64(p.layerBytes[ii*3+1]), float64(p.layerBytes[ii*3+2]))
+			bytes[ii*3+0] = byte(colorutils.Clamp(r0+(r-r0)*amt+0.5, 0, 255))
+			bytes[ii*3+1] = byte(colorutils.Clamp(g0+(g-g0)*amt+0.5, 0, 255))
+			bytes[ii*3+2] = byte(colorutils.Clamp(b0+(b-b0)*amt+0.5, 0, 255))
+		}
+	}
+}
+
+// Like Render, but in high precision.  blendPixel works from 0 to 255, so the colors are
+// scaled up for it and back down again.
+func (p *patternCompose) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	for ii := range colors {
+		colors[ii] = 0
+	}
+	if len(p.layerColors) != len(colors) {
+		p.layerColors = make([]float32, len(colors))
+	}
+	n_pixels := len(colors) / 3
+
+	for _, layer := range p.layers {
+		opacity := PARAMS.Get(layer.opacityParam, midiState)
+		if opacity <= 0 {
+			continue
+		}
+		renderFloat(layer.pattern, p.layerColors, &p.layerBytes, t, dt, midiState)
+
+		for ii := 0; ii < n_pixels && ii < len(layer.mask); ii++ {
+			amt := opacity * layer.mask[ii]
+			if amt <= 0 {
+				continue
+			}
+			r0 := float64(colors[ii*3+0]) * 255
+			g0 := float64(colors[ii*3+1]) * 255
+			b0 := float64(colors[ii*3+2]) * 255
+			r, g, b := blendPixel(layer.blend, r0, g0, b0,
+				float64(p.layerColors[ii*3+0])*255, float64(p.layerColors[ii*3+1])*255, float64(p.layerColors[ii*3+2])*255)
+			colors[ii*3+0] = float32(colorutils.Clamp((r0+(r-r0)*amt)/255, 0, 1))
+			colors[ii*3+1] = float32(colorutils.Clamp((g0+(g-g0)*amt)/255, 0, 1))
+			colors[ii*3+2] = float32(colorutils.Clamp((b0+(b-b0)*amt)/255, 0, 1))
+		}
+	}
+}
+
+func (p *patternCompose) Reset() {
+	for _, layer := range p.layers {
+		ResetPattern(layer.pattern)
+	}
+}
+
+func (p *patternCompose) Close() {
+	for _, layer := range p.layers {
+		ClosePattern(layer.pattern)
+	}
+	p.layers = nil
+}
@@ -12,6 +12,11 @@ import (
 	"time"
 )
 
+var EYE_PARAMS = []*Param{
+	{"eye.pupil-size", PARAM_FLOAT, 0.01, 0.5, 0.1, "Radius of the pupil as a fraction of the circle."},
+	{"eye.pupil-softness", PARAM_FLOAT, 0, 0.3, 0.05, "Fuzziness of the edge of the pupil."},
+}
+
 func MakePatternEye(locations []float64) ByteThread {
 
 	// get bounding box
@@ -36,8 +41,6 @@ func MakePatternEye(locations []float64) ByteThread {
 	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
 
 		const (
-			PUPIL_SIZE            = 0.1
-			PUPIL_SOFTNESS        = 0.05
 			EYELID_SOFTNESS       = 0.2  // fuzziness of edge of eyelid
 			MAX_BIG_MOVE_THETA    = 200  // max degrees to move during a big move
 			MIN_BIG_MOVE_THETA    = 30   // min degrees to move during a big move
@@ -61,6 +64,8 @@ func MakePatternEye(locations []float64) ByteThread {
 				n_pixels = 160
 			}
 			t := float64(time.Now().UnixNano())/1.0e9 - 9.4e8
+			PUPIL_SIZE := PARAMS.Get("eye.pupil-size", midiState)
+			PUPIL_SOFTNESS := PARAMS.Get("eye.pupil-softness", midiState)
 
 			// if the current move is over, figure out the next move
 			var moveDuration float64
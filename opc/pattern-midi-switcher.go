@@ -1,84 +1,175 @@
 package opc
 
-// Basic Midi
-//   Turns on one LED for each of the 128 MIDI pitches when that node is being played.
-//   LEDs are colored in rainbow order according to the circle of fifths.
+// Midi Switcher
+//   Uses a MIDI knob, program change messages, or the controller profile's pattern
+//   pads (like the APC mini's grid) to switch between other patterns.
+//   All the patterns run in this goroutine.  Patterns which aren't selected are
+//   paused instead of being torn down, so switching back to a pattern resumes it
+//   where it left off.
+//   Switching blends from the old pattern to the new one using the transition
+//   from the show config.
 
 import (
-	"time"
+	"fmt"
+	"sync/atomic"
 
-	"github.com/longears/pixelslinger/colorutils"
 	"github.com/longears/pixelslinger/config"
 	"github.com/longears/pixelslinger/midi"
 )
 
-func MakePatternMidiSwitcher(locations []float64) ByteThread {
-	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
-
-		// The patterns that our MIDI knob will switch between
-		PATTERN_LIST := []string{
-			"fire",
-			"sunset",
-			"diamond",
-			"raver-plaid",
-			"shield",
-			"spatial-stripes",
-			"moire",
-			"white",
-			"aqua",
-			"house-potty",
-            "archimedes",
+// The patterns that our MIDI knob will switch between, unless the show config
+// gives a different list.
+var DEFAULT_SWITCHER_PATTERNS = []string{
+	"fire",
+	"sunset",
+	"diamond",
+	"raver-plaid",
+	"shield",
+	"spatial-stripes",
+	"moire",
+	"white",
+	"aqua",
+	"archimedes",
+}
+
+const DEFAULT_SWITCHER_TRANSITION = TRANSITION_CROSSFADE
+
+// Index of the pattern the midi-switcher is showing, or -1 if it's not running.
+// The switcher writes it from the source thread while mainLoop reads it, so it's atomic.
+var switcherSelected int32 = -1
+
+// Return the index of the pattern the midi-switcher is showing, or -1 if it's not running.
+// The controller lights the matching pattern pad.
+func SwitcherSelected() int {
+	return int(atomic.LoadInt32(&switcherSelected))
+}
+
+var MIDI_SWITCHER_PARAMS = []*Param{
+	{"midi-switcher.transition-time", PARAM_FLOAT, 0, 10, 1, "How long a transition between patterns lasts, in seconds."},
+}
+
+type patternMidiSwitcher struct {
+	locations      []float64
+	patternList    []string           // the patterns that our MIDI knob will switch between
+	transitionKind string             // one of the TRANSITION_* constants
+	patterns       map[string]Pattern // patterns which have been started, by name
+	current        string             // name of the selected pattern
+	selected       int                // index into patternList chosen by the knob, a program change, or a pattern pad
+	lastKnob       int                // knob value from the last frame, or -1 at the start
+	transitioner   *transitioner
+}
+
+func NewPatternMidiSwitcher() Pattern {
+	return &patternMidiSwitcher{lastKnob: -1}
+}
+
+func (p *patternMidiSwitcher) Init(locations []float64) {
+	p.locations = locations
+	p.patterns = make(map[string]Pattern)
+	p.transitioner = newTransitioner(locations)
+
+	patternList := config.SHOW.Switcher.Patterns
+	if len(patternList) == 0 {
+		patternList = DEFAULT_SWITCHER_PATTERNS
+	}
+	for _, name := range patternList {
+		if err := checkContained("midi-switcher", name); err != nil {
+			fmt.Println("[opc.midi-switcher] skipping pattern:", err)
+			continue
+		}
+		p.patternList = append(p.patternList, name)
+	}
+	if len(p.patternList) == 0 {
+		p.patternList = []string{"off"}
+	}
+
+	p.transitionKind = config.SHOW.Switcher.Transition
+	if p.transitionKind == "" {
+		p.transitionKind = DEFAULT_SWITCHER_TRANSITION
+	}
+	if err := CheckTransitionKind(p.transitionKind); err != nil {
+		fmt.Println("[opc.midi-switcher]", err, "... using", DEFAULT_SWITCHER_TRANSITION)
+		p.transitionKind = DEFAULT_SWITCHER_TRANSITION
+	}
+}
+
+// Return the named pattern, starting it if this is the first time it's been used.
+func (p *patternMidiSwitcher) getPattern(name string) Pattern {
+	pattern, ok := p.patterns[name]
+	if !ok {
+		pattern = PATTERN_REGISTRY[name].NewPattern()
+		pattern.Init(p.locations)
+		p.patterns[name] = pattern
+	}
+	return pattern
+}
+
+// Decide which subpattern we want for this frame, start a transition if it changed, and return it.
+func (p *patternMidiSwitcher) choose(midiState *midi.MidiState) Pattern {
+
+	// // VERSION A for testing
+	// switchKnob := colorutils.PosMod2(t, 1)
+	// _ = config.SWITCH_KNOB
+
+	// VERSION B for production
+	knob := int(config.SWITCH_KNOB.Raw(midiState))
+	switchKnob := config.SWITCH_KNOB.Value(midiState)
+
+	// whichever moved most recently wins, the knob, a program change, or a pattern pad
+	if knob != p.lastKnob {
+		// assume switchKnob is between 0 and 1
+		p.selected = int(switchKnob * float64(len(p.patternList)) * 0.99999)
+		p.lastKnob = knob
+	}
+	for _, m := range midiState.RecentMidiMessages {
+		if m.Kind == midi.PROGRAM_CHANGE {
+			p.selected = int(m.Key) % len(p.patternList)
 		}
+	}
+	for ii, pad := range config.PATTERN_PADS {
+		if pad.Pressed(midiState) {
+			p.selected = ii % len(p.patternList)
+		}
+	}
+	patternName := p.patternList[p.selected]
+	atomic.StoreInt32(&switcherSelected, int32(p.selected))
 
-		// channels for communication with subpattern
-		chanToPattern := make(chan []byte, 0)
-		chanFromPattern := make(chan []byte, 0)
-
-		var patternName, lastPatternName string
-		for bytes := range bytesIn {
-			t := float64(time.Now().UnixNano())/1.0e9 - 9.4e8
-
-			// decide which subpattern we want for this frame
-
-			// // VERSION A for testing
-			// switchKnob := colorutils.PosMod2(t, 1)
-			// _ = config.SWITCH_KNOB
-
-			// VERSION B for production
-			_ = t
-			_ = colorutils.PosMod
-			switchKnob := float64(midiState.ControllerValues[config.SWITCH_KNOB]) / 127.0
-
-			// assume switchKnob is between 0 and 1
-			ii := int(switchKnob * float64(len(PATTERN_LIST)) * 0.99999)
-			patternName = PATTERN_LIST[ii]
-
-			// Subpattern has changed.  Close old one and start new one.
-			// This is not ideal because it has to re-init each pattern every
-			// time they switch.  This makes the patterns always start in the same
-			// place depending on how their time calculations work.
-			// On the other hand, if we kept all the patterns around all the time,
-			// some might freak out with weird time calculations when their frames
-			// are sometimes a long time apart while other patterns were running.
-			if patternName != lastPatternName {
-				// If patterns are properly written using "for byte := range bytesIn", this
-				// should terminate the previous pattern thread
-				close(chanToPattern)
-				// Make a new to-pattern channel.  We will re-use the from-pattern channel.
-				chanToPattern = make(chan []byte, 0)
-				sourceThreadMaker := PATTERN_REGISTRY[patternName]
-				sourceThread := sourceThreadMaker(locations)
-				go sourceThread(chanToPattern, chanFromPattern, midiState)
-			}
-			lastPatternName = patternName
-
-			// send byte slice to subpattern
-			chanToPattern <- bytes
-			// get result back from subpattern
-			bytes = <-chanFromPattern
-
-			// send our result back to our parent
-			bytesOut <- bytes
+	// Subpattern has changed.  Blend from the old one to the new one.
+	if patternName != p.current {
+		if p.current != "" {
+			p.transitioner.Start(p.patterns[p.current], p.transitionKind)
 		}
+		p.current = patternName
+	}
+	return p.getPattern(patternName)
+}
+
+// Only the selected patterns are rendered, so the others are paused.
+// Since dt is our own frame time, a pattern that was paused for a while
+// picks up where it left off instead of jumping ahead.
+func (p *patternMidiSwitcher) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	pattern := p.choose(midiState)
+	duration := PARAMS.Get("midi-switcher.transition-time", midiState)
+	p.transitioner.Render(pattern, bytes, t, dt, duration, midiState)
+}
+
+func (p *patternMidiSwitcher) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	pattern := p.choose(midiState)
+	duration := PARAMS.Get("midi-switcher.transition-time", midiState)
+	p.transitioner.RenderFloat(pattern, colors, t, dt, duration, midiState)
+}
+
+// Restart every subpattern from the beginning.
+func (p *patternMidiSwitcher) Reset() {
+	for _, pattern := range p.patterns {
+		ResetPattern(pattern)
+	}
+}
+
+func (p *patternMidiSwitcher) Close() {
+	atomic.StoreInt32(&switcherSelected, -1)
+	for name, pattern := range p.patterns {
+		ClosePattern(pattern)
+		delete(p.patterns, name)
 	}
 }
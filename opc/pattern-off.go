@@ -7,16 +7,25 @@ import (
 	"github.com/longears/pixelslinger/midi"
 )
 
-func MakePatternOff(locations []float64) ByteThread {
-	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
-		for bytes := range bytesIn {
-			n_pixels := len(bytes) / 3
-			for ii := 0; ii < n_pixels; ii++ {
-				bytes[ii*3+0] = 0
-				bytes[ii*3+1] = 0
-				bytes[ii*3+2] = 0
-			}
-			bytesOut <- bytes
-		}
+type patternOff struct{}
+
+func NewPatternOff() Pattern {
+	return &patternOff{}
+}
+
+func (p *patternOff) Init(locations []float64) {}
+
+func (p *patternOff) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	n_pixels := len(bytes) / 3
+	for ii := 0; ii < n_pixels; ii++ {
+		bytes[ii*3+0] = 0
+		bytes[ii*3+1] = 0
+		bytes[ii*3+2] = 0
+	}
+}
+
+func (p *patternOff) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	for ii := range colors {
+		colors[ii] = 0
 	}
 }
@@ -0,0 +1,223 @@
+package opc
+
+// Playlist
+//   Cycles through the patterns of a playlist from the show config, showing each
+//   one for its duration and blending between them with each entry's transition.
+//   When no midi has arrived for a while it switches to the attract playlist, and
+//   it hands control back to the main playlist as soon as a pad or knob moves.
+//   Without a show config it just runs the midi-switcher.
+
+import (
+	"fmt"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Transition used for entries which don't name their own.
+const (
+	DEFAULT_PLAYLIST_TRANSITION      = TRANSITION_CROSSFADE
+	DEFAULT_PLAYLIST_TRANSITION_TIME = 2.0 // seconds
+)
+
+// Used when the show config doesn't have a main playlist.
+var DEFAULT_PLAYLIST = []config.PlaylistEntry{
+	{Pattern: "midi-switcher"},
+}
+
+type patternPlaylist struct {
+	mainName     string // empty to use the show config's main playlist
+	locations    []float64
+	main         []config.PlaylistEntry
+	attract      []config.PlaylistEntry // empty when attract mode is off
+	idleSeconds  float64
+	patterns     map[string]Pattern // patterns which have been started, by name
+	transitioner *transitioner
+	saved        []paramSetting // what the current entry's params were before it set them
+
+	attracting     bool    // are we playing the attract playlist?
+	index          int     // position in the playlist we're playing
+	mainIndex      int     // where to resume the main playlist after attract mode
+	elapsed        float64 // seconds the current entry has been showing
+	transitionTime float64 // length of the current transition
+	lastActivity   float64 // time of the most recent pad or knob message
+}
+
+func NewPatternPlaylist() Pattern {
+	return &patternPlaylist{}
+}
+
+// Return a playlist pattern which plays the named playlist instead of the main one.
+func NewPlaylistPattern(name string) Pattern {
+	return &patternPlaylist{mainName: name}
+}
+
+// Return the entries of the named playlist which refer to real patterns.
+func loadPlaylist(name string) []config.PlaylistEntry {
+	entries := make([]config.PlaylistEntry, 0)
+	for _, entry := range config.SHOW.Playlists[name] {
+		if err := checkContained("playlist", entry.Pattern); err != nil {
+			fmt.Printf("[opc.playlist] skipping entry in playlist \"%s\": %v\n", name, err)
+			continue
+		}
+		if entry.Transition != "" {
+			if err := CheckTransitionKind(entry.Transition); err != nil {
+				fmt.Println("[opc.playlist]", err, "... using", DEFAULT_PLAYLIST_TRANSITION)
+				entry.Transition = DEFAULT_PLAYLIST_TRANSITION
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (p *patternPlaylist) Init(locations []float64) {
+	p.locations = locations
+	p.patterns = make(map[string]Pattern)
+	p.transitioner = newTransitioner(locations)
+
+	playlistConfig := config.SHOW.Playlist
+	mainName := p.mainName
+	if mainName == "" {
+		mainName = playlistConfig.Main
+	}
+	p.main = loadPlaylist(mainName)
+	if len(p.main) == 0 {
+		p.main = DEFAULT_PLAYLIST
+	}
+	if playlistConfig.Attract != "" && playlistConfig.IdleMinutes > 0 {
+		p.attract = loadPlaylist(playlistConfig.Attract)
+		p.idleSeconds = playlistConfig.IdleMinutes * 60
+	}
+	p.startEntry(nil)
+}
+
+// Return the named pattern, starting it if this is the first time it's been used.
+func (p *patternPlaylist) getPattern(name string) Pattern {
+	pattern, ok := p.patterns[name]
+	if !ok {
+		pattern = PATTERN_REGISTRY[name].NewPattern()
+		pattern.Init(p.locations)
+		p.patterns[name] = pattern
+	}
+	return pattern
+}
+
+// Return the playlist we're playing right now.
+func (p *patternPlaylist) playlist() []config.PlaylistEntry {
+	if p.attracting {
+		return p.attract
+	}
+	return p.main
+}
+
+// Begin showing the current entry of the current playlist, transitioning from the given pattern.
+// The entry's params last until it ends, and then go back to what they were, bindings and all.
+func (p *patternPlaylist) startEntry(from Pattern) {
+	p.restoreParams()
+	entry := p.playlist()[p.index]
+	for name, raw := range entry.Params {
+		saved := PARAMS.save(name)
+		if err := PARAMS.SetFromJSON(name, raw); err != nil {
+			fmt.Println("[opc.playlist]", err)
+			continue
+		}
+		p.saved = append(p.saved, saved)
+	}
+	p.elapsed = 0
+
+	kind := entry.Transition
+	if kind == "" {
+		kind = DEFAULT_PLAYLIST_TRANSITION
+	}
+	p.transitionTime = entry.TransitionTime
+	if p.transitionTime == 0 {
+		p.transitionTime = DEFAULT_PLAYLIST_TRANSITION_TIME
+	}
+	if from != nil && from != p.getPattern(entry.Pattern) {
+		p.transitioner.Start(from, kind)
+	}
+}
+
+// Undo the current entry's params.
+func (p *patternPlaylist) restoreParams() {
+	for _, setting := range p.saved {
+		PARAMS.restore(setting)
+	}
+	p.saved = p.saved[:0]
+}
+
+// Is there a pad or knob message in this frame?  Clock and other system
+// messages don't count as somebody being at the controller.
+func hasActivity(midiState *midi.MidiState) bool {
+	for _, m := range midiState.RecentMidiMessages {
+		if m.Kind != midi.SYSTEM {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the playlist along by dt and return the pattern to show, starting a transition
+// whenever the entry changes.
+func (p *patternPlaylist) choose(t, dt float64, midiState *midi.MidiState) Pattern {
+	current := p.getPattern(p.playlist()[p.index].Pattern)
+
+	active := hasActivity(midiState)
+	if p.lastActivity == 0 || active {
+		p.lastActivity = t
+	}
+
+	switch {
+	case p.attracting && active:
+		// somebody touched the controller, so hand control back
+		fmt.Println("[opc.playlist] midi activity, leaving attract mode")
+		p.attracting = false
+		p.index = p.mainIndex
+		p.startEntry(current)
+	case !p.attracting && len(p.attract) > 0 && t-p.lastActivity > p.idleSeconds:
+		fmt.Println("[opc.playlist] no midi for a while, starting attract mode")
+		p.attracting = true
+		p.mainIndex = p.index
+		p.index = 0
+		p.startEntry(current)
+	default:
+		// move on to the next entry when this one's time is up
+		p.elapsed += dt
+		entry := p.playlist()[p.index]
+		if entry.Duration > 0 && p.elapsed >= entry.Duration {
+			p.index = (p.index + 1) % len(p.playlist())
+			p.startEntry(current)
+		}
+	}
+
+	return p.getPattern(p.playlist()[p.index].Pattern)
+}
+
+func (p *patternPlaylist) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	next := p.choose(t, dt, midiState)
+	p.transitioner.Render(next, bytes, t, dt, p.transitionTime, midiState)
+}
+
+func (p *patternPlaylist) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	next := p.choose(t, dt, midiState)
+	p.transitioner.RenderFloat(next, colors, t, dt, p.transitionTime, midiState)
+}
+
+// Go back to the beginning of the main playlist and restart every pattern.
+func (p *patternPlaylist) Reset() {
+	for _, pattern := range p.patterns {
+		ResetPattern(pattern)
+	}
+	p.attracting = false
+	p.index = 0
+	p.startEntry(nil)
+}
+
+func (p *patternPlaylist) Close() {
+	p.restoreParams()
+	for name, pattern := range p.patterns {
+		ClosePattern(pattern)
+		delete(p.patterns, name)
+	}
+}
@@ -0,0 +1,97 @@
+package opc
+
+// Schedule
+//   Plays whatever the schedule in the show config says should be playing right now,
+//   crossfading from one rule's playlist or pattern to the next.  Outside of every
+//   rule it plays the main playlist.  The rules' brightness caps are applied at the
+//   output by BrightnessCap, whatever the source is.
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Stand-in for "no rule matches" in patternSchedule.patterns.
+const NO_SCHEDULE_RULE = -1
+
+type patternSchedule struct {
+	locations    []float64
+	scheduler    *Scheduler
+	patterns     map[int]Pattern // patterns which have been started, by rule index
+	transitioner *transitioner
+	current      int // index of the rule we're playing
+}
+
+func NewPatternSchedule() Pattern {
+	return &patternSchedule{}
+}
+
+func (p *patternSchedule) Init(locations []float64) {
+	p.locations = locations
+	p.patterns = make(map[int]Pattern)
+	p.transitioner = newTransitioner(locations)
+
+	scheduler, err := NewScheduler(config.SHOW.Schedule, config.SHOW.Location)
+	if err != nil {
+		fmt.Println("[opc.schedule]", err, "... ignoring the schedule")
+		scheduler, _ = NewScheduler(config.ScheduleConfig{}, config.SHOW.Location)
+	}
+	p.scheduler = scheduler
+	p.current = p.scheduler.ActiveIndex(time.Now())
+}
+
+// Return the pattern for the given rule, starting it if this is the first time it's been used.
+func (p *patternSchedule) getPattern(index int) Pattern {
+	pattern, ok := p.patterns[index]
+	if ok {
+		return pattern
+	}
+	switch {
+	case index == NO_SCHEDULE_RULE:
+		pattern = NewPatternPlaylist()
+	case p.scheduler.rules[index].Off:
+		pattern = NewPatternOff()
+	case p.scheduler.rules[index].Pattern != "":
+		pattern = PATTERN_REGISTRY[p.scheduler.rules[index].Pattern].NewPattern()
+	default:
+		pattern = NewPlaylistPattern(p.scheduler.rules[index].Playlist)
+	}
+	pattern.Init(p.locations)
+	p.patterns[index] = pattern
+	return pattern
+}
+
+// Return the pattern for the rule in effect now, starting a transition if the rule has changed.
+func (p *patternSchedule) choose() Pattern {
+	index := p.scheduler.ActiveIndex(time.Now())
+	if index != p.current {
+		fmt.Printf("[opc.schedule] switching from rule %d to rule %d\n", p.current+1, index+1)
+		p.transitioner.Start(p.getPattern(p.current), DEFAULT_PLAYLIST_TRANSITION)
+		p.current = index
+	}
+	return p.getPattern(p.current)
+}
+
+func (p *patternSchedule) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	p.transitioner.Render(p.choose(), bytes, t, dt, DEFAULT_PLAYLIST_TRANSITION_TIME, midiState)
+}
+
+func (p *patternSchedule) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	p.transitioner.RenderFloat(p.choose(), colors, t, dt, DEFAULT_PLAYLIST_TRANSITION_TIME, midiState)
+}
+
+func (p *patternSchedule) Reset() {
+	for _, pattern := range p.patterns {
+		ResetPattern(pattern)
+	}
+}
+
+func (p *patternSchedule) Close() {
+	for index, pattern := range p.patterns {
+		ClosePattern(pattern)
+		delete(p.patterns, index)
+	}
+}
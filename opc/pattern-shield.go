@@ -19,13 +19,13 @@ func MakePatternShield(locations []float64) ByteThread {
 
 			// time and speed knob bookkeeping
 			this_t := float64(time.Now().UnixNano())/1.0e9 - 9.4e8
-			speedKnob := float64(midiState.ControllerValues[config.SPEED_KNOB]) / 127.0
+			speedKnob := config.SPEED_KNOB.Value(midiState)
 			if speedKnob < 0.5 {
 				speedKnob = colorutils.RemapAndClamp(speedKnob, 0, 0.4, 0, 1)
 			} else {
 				speedKnob = colorutils.RemapAndClamp(speedKnob, 0.6, 1, 1, 4)
 			}
-			if midiState.KeyVolumes[config.SLOWMO_PAD] > 0 {
+			if config.SLOWMO_PAD.Down(midiState) {
 				speedKnob *= 0.25
 			}
 			if last_t != 0 {
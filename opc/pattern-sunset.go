@@ -11,7 +11,6 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"time"
 )
 
 func handleErr(err error) {
@@ -124,139 +123,156 @@ func (mi *MyImage) getInterpolatedColor(x, y float64, wrapMethod string) (r, g,
 //================================================================================
 // PIXEL PATTERN
 
-func MakePatternSunset(locations []float64) ByteThread {
+var SUNSET_PARAMS = []*Param{
+	{"sunset.real-sky", PARAM_BOOL, 0, 1, 0, "Follow the real sunrise and sunset at the show config's location instead of a fast cycle."},
+}
+
+type patternSunset struct {
+	locations    []float64
+	randomValues []float64
+	min_coord_z  float64
+	max_coord_z  float64
+	myImage      *MyImage
+	t            float64 // sped up or slowed down by the speed knob
+}
+
+func NewPatternSunset() Pattern {
+	return &patternSunset{}
+}
 
+func (p *patternSunset) Init(locations []float64) {
 	var (
 		IMG_PATH            = "images/sky4_square.png"
-		DAY_LENGTH          = 20.0 // seconds
-		SUN_SOFT_EDGE       = 0.2
 		STAR_BRIGHTNESS_EXP = 2.7 // higher number means fewer bright stars
-		STAR_THRESH         = 0.95
-		STAR_CONTRAST       = 3.0
-		STAR_FADE_EXP       = 4.0 // higher numbers keep stars from showing during sunrise/sunset
 	)
+	p.locations = locations
 
 	// make persistant random values
 	rng := rand.New(rand.NewSource(9))
-	randomValues := make([]float64, len(locations)/3)
-	for ii := range randomValues {
-		randomValues[ii] = math.Pow(rng.Float64(), STAR_BRIGHTNESS_EXP)
+	p.randomValues = make([]float64, len(locations)/3)
+	for ii := range p.randomValues {
+		p.randomValues[ii] = math.Pow(rng.Float64(), STAR_BRIGHTNESS_EXP)
 	}
 
 	// get bounding box
-	n_pixels := len(locations) / 3
-	var max_coord_x, max_coord_y, max_coord_z float64
-	var min_coord_x, min_coord_y, min_coord_z float64
-	for ii := 0; ii < n_pixels; ii++ {
-		x := locations[ii*3+0]
-		y := locations[ii*3+1]
-		z := locations[ii*3+2]
-        if ii == 0 || x > max_coord_x { max_coord_x = x }
-        if ii == 0 || y > max_coord_y { max_coord_y = y }
-        if ii == 0 || z > max_coord_z { max_coord_z = z }
-        if ii == 0 || x < min_coord_x { min_coord_x = x }
-        if ii == 0 || y < min_coord_y { min_coord_y = y }
-        if ii == 0 || z < min_coord_z { min_coord_z = z }
-	}
+	minn, maxx := boundingBox(locations)
+	p.min_coord_z = minn[2]
+	p.max_coord_z = maxx[2]
 
 	// load image
-	myImage := &MyImage{}
-	myImage.populateFromImage(IMG_PATH)
-
-	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
-		last_t := 0.0
-		t := 0.0
-		for bytes := range bytesIn {
-			n_pixels := len(bytes) / 3
-
-			// time and speed knob bookkeeping
-			this_t := float64(time.Now().UnixNano())/1.0e9 - 9.4e8
-			speedKnob := float64(midiState.ControllerValues[config.SPEED_KNOB]) / 127.0
-			if speedKnob < 0.5 {
-				speedKnob = colorutils.RemapAndClamp(speedKnob, 0, 0.4, 0, 1)
-			} else {
-				speedKnob = colorutils.RemapAndClamp(speedKnob, 0.6, 1, 1, 4)
-			}
-			if midiState.KeyVolumes[config.SLOWMO_PAD] > 0 {
-				speedKnob *= 0.25
-			}
-			if last_t != 0 {
-				t += (this_t - last_t) * speedKnob
-			}
-			last_t = this_t
-
-			for ii := 0; ii < n_pixels; ii++ {
-				//--------------------------------------------------------------------------------
-
-				x := locations[ii*3+0] / 2
-				y := locations[ii*3+1] / 2
-				z := locations[ii*3+2] / 2
-				_ = x
-				_ = y
-				_ = z
-
-				zp := colorutils.Remap(z, min_coord_z, max_coord_z, 0, 1)
-
-				// time of day, cycles through range 0 to 1.  0 is midnight, 0.5 is noon
-				// sunrise at 0.25, sunset at 0.75
-				timeOfDay := colorutils.PosMod2(t/DAY_LENGTH, 1)
-
-				// compute sun height in range 0 to 1
-				sunHeight := 0.0
-				SUNRISE_TIME := 0.2 // range 0 to 0.25
-				switch {
-				case timeOfDay < 0.25-SUNRISE_TIME:
-					sunHeight = 0
-				case timeOfDay < 0.25+SUNRISE_TIME:
-					sunHeight = colorutils.EaseRemapAndClamp(timeOfDay, 0.25-SUNRISE_TIME, 0.25+SUNRISE_TIME, 0, 1)
-				case timeOfDay < 0.75-SUNRISE_TIME:
-					sunHeight = 1
-				case timeOfDay < 0.75+SUNRISE_TIME:
-					sunHeight = colorutils.EaseRemapAndClamp(timeOfDay, 0.75-SUNRISE_TIME, 0.75+SUNRISE_TIME, 1, 0)
-				default:
-					sunHeight = 0
-				}
-
-				// sky color
-				r, g, b := myImage.getInterpolatedColor(timeOfDay+0.5, 1-zp, "tile")
-
-				// stars
-				if ii >= 160 {
-					// day/night
-					starAmt := math.Pow(1-sunHeight, STAR_FADE_EXP)
-					// fade at horizon
-					starAmt *= math.Pow(colorutils.RemapAndClamp(zp, 0.35, 0.48, 0, 1), 2)
-					// individual stars
-					starAmt *= colorutils.ContrastAndClamp(randomValues[ii], STAR_THRESH, STAR_CONTRAST, 0, 1)
-					// twinkle
-					starAmt *= colorutils.Cos(t, randomValues[ii], 0.3+2*colorutils.PosMod2(randomValues[ii]*7, 1), 0.6, 1)
-					r += starAmt
-					g += starAmt
-					b += starAmt
-				}
-
-				// sun circle
-				if ii < 160 {
-					pct := float64(ii) / 160.0
-					pct = pct * 2
-					if pct > 1 {
-						pct = 2 - pct
-					}
-					val := colorutils.Contrast(pct, colorutils.Remap(sunHeight, 0, 1, -SUN_SOFT_EDGE*2, 1+SUN_SOFT_EDGE*2), 1/SUN_SOFT_EDGE)
-					val = colorutils.Clamp(1-val, 0, 1)
-					r = val * 1.13
-					g = val * 0.85
-					b = val * 0.65
-				}
-
-				bytes[ii*3+0] = colorutils.FloatToByte(r)
-				bytes[ii*3+1] = colorutils.FloatToByte(g)
-				bytes[ii*3+2] = colorutils.FloatToByte(b)
-
-				//--------------------------------------------------------------------------------
-			}
+	p.myImage = &MyImage{}
+	p.myImage.populateFromImage(IMG_PATH)
+}
+
+func (p *patternSunset) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	p.render(len(bytes)/3, dt, midiState, func(ii int, r, g, b float64) {
+		bytes[ii*3+0] = colorutils.FloatToByte(r)
+		bytes[ii*3+1] = colorutils.FloatToByte(g)
+		bytes[ii*3+2] = colorutils.FloatToByte(b)
+	})
+}
+
+func (p *patternSunset) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	p.render(len(colors)/3, dt, midiState, func(ii int, r, g, b float64) {
+		colors[ii*3+0] = float32(colorutils.Clamp(r, 0, 1))
+		colors[ii*3+1] = float32(colorutils.Clamp(g, 0, 1))
+		colors[ii*3+2] = float32(colorutils.Clamp(b, 0, 1))
+	})
+}
+
+// Work out the color of each of the first n_pixels pixels and hand it to set.
+func (p *patternSunset) render(n_pixels int, dt float64, midiState *midi.MidiState, set func(ii int, r, g, b float64)) {
+	var (
+		DAY_LENGTH    = 20.0 // seconds
+		SUN_SOFT_EDGE = 0.2
+		STAR_THRESH   = 0.95
+		STAR_CONTRAST = 3.0
+		STAR_FADE_EXP = 4.0 // higher numbers keep stars from showing during sunrise/sunset
+	)
 
-			bytesOut <- bytes
+	// speed knob bookkeeping
+	speedKnob := config.SPEED_KNOB.Value(midiState)
+	if speedKnob < 0.5 {
+		speedKnob = colorutils.RemapAndClamp(speedKnob, 0, 0.4, 0, 1)
+	} else {
+		speedKnob = colorutils.RemapAndClamp(speedKnob, 0.6, 1, 1, 4)
+	}
+	if config.SLOWMO_PAD.Down(midiState) {
+		speedKnob *= 0.25
+	}
+	p.t += dt * speedKnob
+	t := p.t
+
+	realSky := PARAMS.GetBool("sunset.real-sky", midiState)
+	skyTimeOfDay := 0.0
+	if realSky {
+		skyTimeOfDay = SkyTimeOfDay(stateTime(midiState), config.SHOW.Location)
+	}
+
+	for ii := 0; ii < n_pixels; ii++ {
+		//--------------------------------------------------------------------------------
+
+		z := p.locations[ii*3+2] / 2
+
+		zp := colorutils.Remap(z, p.min_coord_z, p.max_coord_z, 0, 1)
+
+		// time of day, cycles through range 0 to 1.  0 is midnight, 0.5 is noon
+		// sunrise at 0.25, sunset at 0.75
+		timeOfDay := colorutils.PosMod2(t/DAY_LENGTH, 1)
+		if realSky {
+			timeOfDay = skyTimeOfDay
 		}
+
+		// compute sun height in range 0 to 1
+		sunHeight := 0.0
+		SUNRISE_TIME := 0.2 // range 0 to 0.25
+		switch {
+		case timeOfDay < 0.25-SUNRISE_TIME:
+			sunHeight = 0
+		case timeOfDay < 0.25+SUNRISE_TIME:
+			sunHeight = colorutils.EaseRemapAndClamp(timeOfDay, 0.25-SUNRISE_TIME, 0.25+SUNRISE_TIME, 0, 1)
+		case timeOfDay < 0.75-SUNRISE_TIME:
+			sunHeight = 1
+		case timeOfDay < 0.75+SUNRISE_TIME:
+			sunHeight = colorutils.EaseRemapAndClamp(timeOfDay, 0.75-SUNRISE_TIME, 0.75+SUNRISE_TIME, 1, 0)
+		default:
+			sunHeight = 0
+		}
+
+		// sky color
+		r, g, b := p.myImage.getInterpolatedColor(timeOfDay+0.5, 1-zp, "tile")
+
+		// stars
+		if ii >= 160 {
+			// day/night
+			starAmt := math.Pow(1-sunHeight, STAR_FADE_EXP)
+			// fade at horizon
+			starAmt *= math.Pow(colorutils.RemapAndClamp(zp, 0.35, 0.48, 0, 1), 2)
+			// individual stars
+			starAmt *= colorutils.ContrastAndClamp(p.randomValues[ii], STAR_THRESH, STAR_CONTRAST, 0, 1)
+			// twinkle
+			starAmt *= colorutils.Cos(t, p.randomValues[ii], 0.3+2*colorutils.PosMod2(p.randomValues[ii]*7, 1), 0.6, 1)
+			r += starAmt
+			g += starAmt
+			b += starAmt
+		}
+
+		// sun circle
+		if ii < 160 {
+			pct := float64(ii) / 160.0
+			pct = pct * 2
+			if pct > 1 {
+				pct = 2 - pct
+			}
+			val := colorutils.Contrast(pct, colorutils.Remap(sunHeight, 0, 1, -SUN_SOFT_EDGE*2, 1+SUN_SOFT_EDGE*2), 1/SUN_SOFT_EDGE)
+			val = colorutils.Clamp(1-val, 0, 1)
+			r = val * 1.13
+			g = val * 0.85
+			b = val * 0.65
+		}
+
+		set(ii, r, g, b)
+
+		//--------------------------------------------------------------------------------
 	}
 }
@@ -9,24 +9,42 @@ import (
 	"github.com/longears/pixelslinger/midi"
 )
 
-func MakePatternWhite(locations []float64) ByteThread {
-	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
-		for bytes := range bytesIn {
-			H := float64(midiState.ControllerValues[config.HUE_KNOB]) / 127.0
-			FADE_TO_WHITE := float64(midiState.ControllerValues[config.MORPH_KNOB]) / 127.0
-
-			r, g, b := colorutils.HslToRgb(H, 1.0, 0.5)
-			r = r*(1-FADE_TO_WHITE) + 1*FADE_TO_WHITE
-			g = g*(1-FADE_TO_WHITE) + 1*FADE_TO_WHITE
-			b = b*(1-FADE_TO_WHITE) + 1*FADE_TO_WHITE
-
-			n_pixels := len(bytes) / 3
-			for ii := 0; ii < n_pixels; ii++ {
-				bytes[ii*3+0] = colorutils.FloatToByte(r)
-				bytes[ii*3+1] = colorutils.FloatToByte(g)
-				bytes[ii*3+2] = colorutils.FloatToByte(b)
-			}
-			bytesOut <- bytes
-		}
+type patternWhite struct{}
+
+func NewPatternWhite() Pattern {
+	return &patternWhite{}
+}
+
+func (p *patternWhite) Init(locations []float64) {}
+
+// Return the color every pixel should be.
+func (p *patternWhite) color(midiState *midi.MidiState) (r, g, b float64) {
+	H := config.HUE_KNOB.Value(midiState)
+	FADE_TO_WHITE := config.MORPH_KNOB.Value(midiState)
+
+	r, g, b = colorutils.HslToRgb(H, 1.0, 0.5)
+	r = r*(1-FADE_TO_WHITE) + 1*FADE_TO_WHITE
+	g = g*(1-FADE_TO_WHITE) + 1*FADE_TO_WHITE
+	b = b*(1-FADE_TO_WHITE) + 1*FADE_TO_WHITE
+	return r, g, b
+}
+
+func (p *patternWhite) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	r, g, b := p.color(midiState)
+	n_pixels := len(bytes) / 3
+	for ii := 0; ii < n_pixels; ii++ {
+		bytes[ii*3+0] = colorutils.FloatToByte(r)
+		bytes[ii*3+1] = colorutils.FloatToByte(g)
+		bytes[ii*3+2] = colorutils.FloatToByte(b)
+	}
+}
+
+func (p *patternWhite) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	r, g, b := p.color(midiState)
+	n_pixels := len(colors) / 3
+	for ii := 0; ii < n_pixels; ii++ {
+		colors[ii*3+0] = float32(colorutils.Clamp(r, 0, 1))
+		colors[ii*3+1] = float32(colorutils.Clamp(g, 0, 1))
+		colors[ii*3+2] = float32(colorutils.Clamp(b, 0, 1))
 	}
 }
@@ -0,0 +1,206 @@
+package opc
+
+// Pattern interface
+//   A Pattern renders one frame at a time when it's asked to, instead of running
+//   forever in its own goroutine like a ByteThread.  This lets switchers, playlists
+//   and compositors keep several patterns alive in a single goroutine and pause or
+//   resume them just by not calling Render for a while.
+//
+//   Old-style ByteThread patterns keep working through byteThreadPattern, and any
+//   Pattern can be run as a ByteThread with PatternToByteThread.  Patterns which
+//   also implement FloatPattern can render high-precision colors; see PatternToStage.
+
+import (
+	"time"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Longest time step, in seconds, handed to a Pattern's Render.  Longer stalls are
+// clamped so that patterns don't jump ahead after a hiccup.
+const MAX_FRAME_DT = 0.5
+
+type Pattern interface {
+	// Called once before the first frame with the layout's [x y z  x y z ...] locations.
+	Init(locations []float64)
+
+	// Fill bytes with one frame in [r g b  r g b ...] order.
+	// t is the current time in seconds and dt is the time since this pattern's
+	// previous frame, not counting time spent paused.  dt is 0 for the first frame.
+	// midiState should be treated as read-only.
+	Render(bytes []byte, t, dt float64, midiState *midi.MidiState)
+}
+
+// Patterns which can render more than 8 bits per channel implement FloatPattern too.
+// The high-precision frame path calls RenderFloat instead of Render.
+type FloatPattern interface {
+	// Like Render, but fill colors from 0 to 1 in [r g b  r g b ...] order.
+	RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState)
+}
+
+// Patterns with internal state can implement Resetter to start over from the beginning.
+type Resetter interface {
+	Reset()
+}
+
+// Patterns which hold resources such as goroutines can implement Closer
+// to release them when they won't be rendered again.
+type Closer interface {
+	Close()
+}
+
+// Return the current time in seconds, in the same units patterns have always used.
+func frameTime() float64 {
+	return unixToFrameTime(time.Now())
+}
+
+// Return a time in seconds, in the same units as frameTime.
+func unixToFrameTime(t time.Time) float64 {
+	return float64(t.UnixNano())/1.0e9 - 9.4e8
+}
+
+// Reset p if it knows how to reset itself.
+func ResetPattern(p Pattern) {
+	if r, ok := p.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+// Close p if it knows how to close itself.
+func ClosePattern(p Pattern) {
+	if c, ok := p.(Closer); ok {
+		c.Close()
+	}
+}
+
+//--------------------------------------------------------------------------------
+// ADAPTERS
+
+// Works out t and dt for each frame the way Pattern.Render expects them.
+type patternClock struct {
+	last_t float64
+}
+
+// Return the time of the frame midiState belongs to, and the time since the previous frame.
+func (c *patternClock) tick(midiState *midi.MidiState) (t, dt float64) {
+	t = frameTime()
+	if !midiState.Time.IsZero() {
+		t = unixToFrameTime(midiState.Time)
+	}
+	if c.last_t != 0 {
+		dt = colorutils.Clamp(t-c.last_t, 0, MAX_FRAME_DT)
+	}
+	c.last_t = t
+	return t, dt
+}
+
+// Return a ByteThread which initializes p and then renders it into each byte
+// slice it receives.  When the input channel closes, p is closed too.
+func PatternToByteThread(p Pattern, locations []float64) ByteThread {
+	return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
+		p.Init(locations)
+		defer ClosePattern(p)
+		clock := &patternClock{}
+		for bytes := range bytesIn {
+			t, dt := clock.tick(midiState)
+			p.Render(bytes, t, dt, midiState)
+			bytesOut <- bytes
+		}
+	}
+}
+
+// Return a FloatThread which initializes p and then renders it into each slice of colors
+// it receives, in high precision if p is a FloatPattern.  When the input channel closes,
+// p is closed too.
+func PatternToFloatThread(p Pattern, locations []float64) FloatThread {
+	return func(colorsIn chan []float32, colorsOut chan []float32, midiState *midi.MidiState) {
+		p.Init(locations)
+		defer ClosePattern(p)
+		clock := &patternClock{}
+		var scratch []byte
+		for colors := range colorsIn {
+			t, dt := clock.tick(midiState)
+			renderFloat(p, colors, &scratch, t, dt, midiState)
+			colorsOut <- colors
+		}
+	}
+}
+
+// Return a FloatThread for p if highPrecision is set, or a ByteThread if not.
+func PatternToStage(p Pattern, locations []float64, highPrecision bool) Stage {
+	if highPrecision {
+		return PatternToFloatThread(p, locations)
+	}
+	return PatternToByteThread(p, locations)
+}
+
+// Render p into colors, in high precision if it's a FloatPattern.  Otherwise render it
+// into *scratch, which is grown as needed, and convert.  Patterns which change the
+// frame instead of filling it get the colors rounded to bytes.
+func renderFloat(p Pattern, colors []float32, scratch *[]byte, t, dt float64, midiState *midi.MidiState) {
+	if fp, ok := p.(FloatPattern); ok {
+		fp.RenderFloat(colors, t, dt, midiState)
+		return
+	}
+	if len(*scratch) != len(colors) {
+		*scratch = make([]byte, len(colors))
+	}
+	FloatsToBytes(colors, *scratch)
+	p.Render(*scratch, t, dt, midiState)
+	BytesToFloats(*scratch, colors)
+}
+
+// Wraps an old-style ByteThread so it can be used as a Pattern.
+// The ByteThread runs in its own goroutine between Init and Close, and gets
+// its own copy of the MidiState which is only updated while it's idle.
+type byteThreadPattern struct {
+	maker     func(locations []float64) ByteThread
+	locations []float64
+	midiState midi.MidiState
+	bytesIn   chan []byte
+	bytesOut  chan []byte
+}
+
+// Return a Pattern which runs the ByteThread made by maker.
+func NewByteThreadPattern(maker func(locations []float64) ByteThread) Pattern {
+	return &byteThreadPattern{maker: maker}
+}
+
+func (p *byteThreadPattern) Init(locations []float64) {
+	p.locations = locations
+	p.bytesIn = make(chan []byte, 0)
+	p.bytesOut = make(chan []byte, 0)
+	go p.maker(locations)(p.bytesIn, p.bytesOut, &p.midiState)
+}
+
+func (p *byteThreadPattern) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	// there's no ByteThread running before Init or after Close, so leave the frame alone
+	// rather than wait forever on a nil channel
+	if p.bytesIn == nil {
+		return
+	}
+	// the ByteThread isn't holding a slice right now, so it's safe to update its copy
+	p.midiState = *midiState
+	p.bytesIn <- bytes
+	result := <-p.bytesOut
+	// some ByteThreads (like the OPC server) hand back a different slice
+	if len(result) > 0 && len(bytes) > 0 && &result[0] != &bytes[0] {
+		copy(bytes, result)
+	}
+}
+
+// ByteThreads have no reset hook, so restart the goroutine from scratch.
+func (p *byteThreadPattern) Reset() {
+	p.Close()
+	p.Init(p.locations)
+}
+
+// If the ByteThread is properly written using "for bytes := range bytesIn",
+// closing its input channel makes it return.
+func (p *byteThreadPattern) Close() {
+	if p.bytesIn != nil {
+		close(p.bytesIn)
+		p.bytesIn = nil
+	}
+}
@@ -0,0 +1,130 @@
+package opc
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Fills every channel with its frame number, and remembers what it was asked to do.
+type testPattern struct {
+	locations []float64
+	frames    int
+	dts       []float64
+	closed    chan bool
+}
+
+func (p *testPattern) Init(locations []float64) {
+	p.locations = locations
+}
+
+func (p *testPattern) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	p.frames += 1
+	p.dts = append(p.dts, dt)
+	for ii := range bytes {
+		bytes[ii] = byte(p.frames)
+	}
+}
+
+func (p *testPattern) Close() {
+	p.closed <- true
+}
+
+func TestPatternToByteThread(t *testing.T) {
+	p := &testPattern{closed: make(chan bool, 1)}
+	locations := []float64{0, 0, 0}
+	bytesIn := make(chan []byte)
+	bytesOut := make(chan []byte)
+	midiState := &midi.MidiState{}
+	go PatternToByteThread(p, locations)(bytesIn, bytesOut, midiState)
+
+	start := time.Now()
+	for ii, offset := range []float64{0, 0.1, 2} {
+		// the thread only reads midiState while it's holding a slice
+		midiState.Time = start.Add(time.Duration(offset * float64(time.Second)))
+		bytesIn <- make([]byte, 3)
+		bytes := <-bytesOut
+		if bytes[0] != byte(ii+1) {
+			t.Errorf("frame %v: got %v", ii, bytes)
+		}
+	}
+	close(bytesIn)
+	<-p.closed
+
+	if len(p.locations) != 3 {
+		t.Errorf("Init got %v, want the locations", p.locations)
+	}
+	// dt is 0 at first, then the time between frames, clamped after a stall
+	want := []float64{0, 0.1, MAX_FRAME_DT}
+	for ii, dt := range p.dts {
+		if math.Abs(dt-want[ii]) > 1e-3 {
+			t.Errorf("dts = %v, want %v", p.dts, want)
+			break
+		}
+	}
+}
+
+func TestPatternToFloatThread(t *testing.T) {
+	// a Pattern which isn't a FloatPattern renders in bytes and is converted
+	p := &testPattern{closed: make(chan bool, 1)}
+	colorsIn := make(chan []float32)
+	colorsOut := make(chan []float32)
+	go PatternToFloatThread(p, nil)(colorsIn, colorsOut, &midi.MidiState{})
+	colorsIn <- make([]float32, 3)
+	colors := <-colorsOut
+	if colors[0] != 1.0/255 {
+		t.Errorf("got %v, want the first frame's bytes as colors", colors)
+	}
+	close(colorsIn)
+	<-p.closed
+}
+
+func TestByteThreadPattern(t *testing.T) {
+	stopped := make(chan bool, 2)
+	// counts its frames from 1 and hands back its own slice, like the OPC server does
+	maker := func(locations []float64) ByteThread {
+		return func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
+			defer func() { stopped <- true }()
+			frames := byte(0)
+			mine := make([]byte, len(locations))
+			for range bytesIn {
+				frames += 1
+				for ii := range mine {
+					mine[ii] = frames + midiState.ControllerValues[0]
+				}
+				bytesOut <- mine
+			}
+		}
+	}
+	p := NewByteThreadPattern(maker)
+	p.Init(make([]float64, 3))
+	midiState := &midi.MidiState{}
+	bytes := make([]byte, 3)
+
+	p.Render(bytes, 0, 0, midiState)
+	midiState.ControllerValues[0] = 10
+	p.Render(bytes, 0, 0, midiState)
+	if string(bytes) != string([]byte{12, 12, 12}) {
+		t.Errorf("got %v, want the second frame copied into the slice with the new midi state", bytes)
+	}
+
+	// resetting starts the ByteThread over
+	ResetPattern(p)
+	<-stopped
+	p.Render(bytes, 0, 0, midiState)
+	if bytes[0] != 11 {
+		t.Errorf("got %v after Reset, want the first frame again", bytes)
+	}
+
+	ClosePattern(p)
+	<-stopped
+	ClosePattern(p) // closing twice is harmless
+
+	// rendering after Close leaves the frame as it was instead of hanging
+	p.Render(bytes, 0, 0, midiState)
+	if bytes[0] != 11 {
+		t.Errorf("got %v after Close, want the frame untouched", bytes)
+	}
+}
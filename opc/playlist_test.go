@@ -0,0 +1,55 @@
+package opc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestPlaylistParams(t *testing.T) {
+	savedShow := config.SHOW
+	savedSpeed := PARAMS.save("fire.speed")
+	defer func() {
+		config.SHOW = savedShow
+		PARAMS.restore(savedSpeed)
+	}()
+	config.SHOW.Playlists = map[string][]config.PlaylistEntry{
+		"test": {
+			{Pattern: "white", Duration: 1, Params: map[string]json.RawMessage{"fire.speed": json.RawMessage(`2`)}},
+			{Pattern: "off", Duration: 1},
+		},
+	}
+	config.SHOW.Playlist = config.PlaylistConfig{Main: "test"}
+
+	// fire.speed follows a knob until the first entry sets it
+	PARAMS.Bind("fire.speed", 3)
+	midiState := &midi.MidiState{}
+	midiState.ControllerValues[3] = 127
+	fireSpeed := func() float64 { return PARAMS.Get("fire.speed", midiState) }
+
+	p := NewPatternPlaylist()
+	p.Init([]float64{0, 0, 0})
+	bytes := make([]byte, 3)
+	p.Render(bytes, 1000, 0, midiState)
+	if v := fireSpeed(); v != 2 {
+		t.Errorf("fire.speed = %v during the first entry, want 2", v)
+	}
+
+	// the next entry doesn't mention it, so the binding is back
+	p.Render(bytes, 1001, 1, midiState)
+	if v := fireSpeed(); v != 4 {
+		t.Errorf("fire.speed = %v during the second entry, want the knob's 4", v)
+	}
+
+	// and again when the playlist is closed partway through the first entry
+	p.Render(bytes, 1002, 1, midiState)
+	if v := fireSpeed(); v != 2 {
+		t.Errorf("fire.speed = %v back at the first entry, want 2", v)
+	}
+	ClosePattern(p)
+	if v := fireSpeed(); v != 4 {
+		t.Errorf("fire.speed = %v after Close, want the knob's 4", v)
+	}
+}
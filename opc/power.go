@@ -0,0 +1,222 @@
+package opc
+
+// Power limiter
+//   Estimates how much current the LEDs will draw for each frame and dims the
+//   frame when a power supply would be asked for more than it can give, so that
+//   full white from the flash pad or the white pattern doesn't brown it out.
+//
+//   Each supply in the show config's "power" section feeds a group of pixels.
+//   When a frame would need more than the supply's amps, its pixels are dimmed
+//   together just enough to fit, and then brought back up over POWER_RELEASE
+//   seconds so the brightness doesn't pump.  max_amps caps the whole layout the
+//   same way, after the supplies.
+//
+//   The estimate is made from the bytes after gamma correction because that's
+//   how long the LEDs are actually on.
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+const (
+	POWER_DEFAULT_MILLIAMPS_PER_CHANNEL = 20.0 // a typical WS2812 or LPD8806 channel at full brightness
+	POWER_RELEASE                       = 1.0  // seconds for a dimmed supply to come back to full brightness
+)
+
+// The pixels one limit applies to, and how far they're dimmed right now.
+type powerSupply struct {
+	pixels  []int
+	maxAmps float64
+	scale   float64 // from 0 to 1
+}
+
+type PowerLimiter struct {
+	supplies      []*powerSupply // the configured ones, then the whole layout if there's a global cap
+	allPixels     []int
+	pixelAmps     []float64    // pixel --> amps drawn by one of its channels at full brightness
+	duty          [256]float64 // byte --> fraction of the time the LED is on
+	load          []float64    // pixel --> total duty of its channels in the frame being limited
+	scales        []float64    // pixel --> how much to dim it in the frame being limited
+	mutex         sync.Mutex
+	amps          float64 // estimate for the last frame, after limiting
+	unlimitedAmps float64 // what the last frame would have drawn without the limiter
+}
+
+// Return a PowerLimiter for a layout of nPixels, or an error if the config names an unknown
+// group or has a supply without a limit.
+func NewPowerLimiter(powerConfig *config.PowerConfig, nPixels int) (*PowerLimiter, error) {
+	pl := &PowerLimiter{
+		pixelAmps: make([]float64, nPixels),
+		load:      make([]float64, nPixels),
+		scales:    make([]float64, nPixels),
+	}
+	for ii := range pl.duty {
+		pl.duty[ii] = math.Pow(float64(ii)/255, GAMMA)
+	}
+
+	defaultMilliamps := powerConfig.MilliampsPerChannel
+	if defaultMilliamps <= 0 {
+		defaultMilliamps = POWER_DEFAULT_MILLIAMPS_PER_CHANNEL
+	}
+	for ii := range pl.pixelAmps {
+		pl.pixelAmps[ii] = defaultMilliamps / 1000
+	}
+
+	pl.allPixels = make([]int, nPixels)
+	for ii := range pl.allPixels {
+		pl.allPixels[ii] = ii
+	}
+	for _, supplyConfig := range powerConfig.Supplies {
+		supply := &powerSupply{maxAmps: supplyConfig.Amps, scale: 1}
+		name := supplyConfig.Group
+		if name == "" {
+			name = "all pixels"
+			supply.pixels = pl.allPixels
+		} else {
+			ranges, ok := config.SHOW.Groups[supplyConfig.Group]
+			if !ok {
+				return nil, fmt.Errorf("power supply: unknown group %q", supplyConfig.Group)
+			}
+			inGroup, err := parsePixelGroup(ranges, nPixels)
+			if err != nil {
+				return nil, fmt.Errorf("power supply %q: %v", name, err)
+			}
+			for ii, in := range inGroup {
+				if in {
+					supply.pixels = append(supply.pixels, ii)
+				}
+			}
+		}
+		if supply.maxAmps <= 0 {
+			return nil, fmt.Errorf("power supply %q: amps must be more than 0", name)
+		}
+		if supplyConfig.MilliampsPerChannel > 0 {
+			for _, ii := range supply.pixels {
+				pl.pixelAmps[ii] = supplyConfig.MilliampsPerChannel / 1000
+			}
+		}
+		pl.supplies = append(pl.supplies, supply)
+	}
+	if powerConfig.MaxAmps > 0 {
+		pl.supplies = append(pl.supplies, &powerSupply{pixels: pl.allPixels, maxAmps: powerConfig.MaxAmps, scale: 1})
+	}
+	return pl, nil
+}
+
+// Return the estimated current of the most recent frame in amps, and what it would
+// have been without the limiter.
+func (pl *PowerLimiter) Amps() (amps, unlimitedAmps float64) {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+	return pl.amps, pl.unlimitedAmps
+}
+
+// Return the estimated current, in amps, of some of the pixels, from pl.load.
+func (pl *PowerLimiter) estimate(pixels []int) float64 {
+	amps := 0.0
+	for _, ii := range pixels {
+		amps += pl.pixelAmps[ii] * pl.load[ii]
+	}
+	return amps
+}
+
+// Fill pl.load from a frame of bytes.
+func (pl *PowerLimiter) loadBytes(bytes []byte) {
+	for ii := range pl.load {
+		pl.load[ii] = 0
+		if ii*3+2 < len(bytes) {
+			pl.load[ii] = pl.duty[bytes[ii*3]] + pl.duty[bytes[ii*3+1]] + pl.duty[bytes[ii*3+2]]
+		}
+	}
+}
+
+// Fill pl.load from a frame of high-precision colors.
+func (pl *PowerLimiter) loadColors(colors []float32) {
+	for ii := range pl.load {
+		pl.load[ii] = 0
+		if ii*3+2 < len(colors) {
+			for channel := 0; channel < 3; channel++ {
+				if c := float64(colors[ii*3+channel]); c > 0 {
+					pl.load[ii] += math.Pow(c, GAMMA)
+				}
+			}
+		}
+	}
+}
+
+// Work out pl.scales for the frame in pl.load, dimming each supply as far as it needs.
+func (pl *PowerLimiter) limit(dt float64) {
+	for ii := range pl.scales {
+		pl.scales[ii] = 1
+	}
+	for _, supply := range pl.supplies {
+		amps := pl.estimate(supply.pixels)
+		// dimming the colors by scale dims the current by scale^GAMMA
+		target := 1.0
+		if amps > supply.maxAmps {
+			target = math.Pow(supply.maxAmps/amps, 1/GAMMA)
+		}
+		// dim at once so the supply is never overloaded, but recover gently
+		if target < supply.scale {
+			supply.scale = target
+		} else if dt > 0 {
+			supply.scale = math.Min(target, supply.scale+dt/POWER_RELEASE)
+		}
+		if supply.scale >= 1 {
+			continue
+		}
+		// later supplies see the frame as this one leaves it
+		for _, ii := range supply.pixels {
+			pl.scales[ii] *= supply.scale
+			pl.load[ii] *= math.Pow(supply.scale, GAMMA)
+		}
+	}
+}
+
+// Remember the estimates for Amps.
+func (pl *PowerLimiter) report(amps, unlimitedAmps float64) {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+	pl.amps = amps
+	pl.unlimitedAmps = unlimitedAmps
+}
+
+func (pl *PowerLimiter) Init(locations []float64) {}
+
+// Dim the frame in place as far as the supplies need.
+func (pl *PowerLimiter) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	pl.loadBytes(bytes)
+	unlimitedAmps := pl.estimate(pl.allPixels)
+	pl.limit(dt)
+	for ii, scale := range pl.scales {
+		if scale >= 1 || ii*3+2 >= len(bytes) {
+			continue
+		}
+		// round down so the result can't go over the limit
+		for channel := 0; channel < 3; channel++ {
+			bytes[ii*3+channel] = byte(float64(bytes[ii*3+channel]) * scale)
+		}
+	}
+	pl.loadBytes(bytes)
+	pl.report(pl.estimate(pl.allPixels), unlimitedAmps)
+}
+
+func (pl *PowerLimiter) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	pl.loadColors(colors)
+	unlimitedAmps := pl.estimate(pl.allPixels)
+	pl.limit(dt)
+	for ii, scale := range pl.scales {
+		if scale >= 1 || ii*3+2 >= len(colors) {
+			continue
+		}
+		for channel := 0; channel < 3; channel++ {
+			colors[ii*3+channel] *= float32(scale)
+		}
+	}
+	pl.report(pl.estimate(pl.allPixels), unlimitedAmps)
+}
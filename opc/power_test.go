@@ -0,0 +1,78 @@
+package opc
+
+import (
+	"testing"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestPowerLimiter(t *testing.T) {
+	savedGroups := config.SHOW.Groups
+	defer func() { config.SHOW.Groups = savedGroups }()
+	config.SHOW.Groups = map[string][]string{"left": {"0-1"}}
+
+	// full white on a 20 mA-per-channel pixel draws 0.06 amps
+	powerConfig := &config.PowerConfig{
+		Supplies: []config.SupplyConfig{{Group: "left", Amps: 0.06}},
+		MaxAmps:  0.15,
+	}
+	pl, err := NewPowerLimiter(powerConfig, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	white := func() []byte {
+		bytes := make([]byte, 12)
+		for ii := range bytes {
+			bytes[ii] = 255
+		}
+		return bytes
+	}
+	midiState := &midi.MidiState{}
+
+	bytes := white()
+	pl.Render(bytes, 1000, 0, midiState)
+	amps, unlimitedAmps := pl.Amps()
+	if unlimitedAmps < 0.2399 || unlimitedAmps > 0.2401 {
+		t.Errorf("unlimited amps = %v, want 0.24", unlimitedAmps)
+	}
+	if amps > 0.15 {
+		t.Errorf("amps = %v, over max_amps", amps)
+	}
+	pl.loadBytes(bytes)
+	if left := pl.estimate([]int{0, 1}); left > 0.06 {
+		t.Errorf("left supply draws %v amps, over its limit", left)
+	}
+	if bytes[0] != bytes[3] || bytes[6] != bytes[9] || bytes[0] >= bytes[6] {
+		t.Errorf("got %v, want the left supply's pixels dimmed more than the others", bytes)
+	}
+
+	// a dim frame fits every limit, but brightness only comes back gradually
+	dim := func() []byte {
+		bytes := make([]byte, 12)
+		for ii := range bytes {
+			bytes[ii] = 128
+		}
+		return bytes
+	}
+	bytes = dim()
+	pl.Render(bytes, 1000.1, 0.1, midiState)
+	if bytes[0] >= 128 {
+		t.Errorf("got %v right after limiting, want the left supply still dimmed", bytes)
+	}
+	for _, tt := range []float64{1000.6, 1001.1} {
+		bytes = dim()
+		pl.Render(bytes, tt, 0.5, midiState)
+	}
+	if string(bytes) != string(dim()) {
+		t.Errorf("got %v after %v seconds, want it back to full brightness", bytes, POWER_RELEASE)
+	}
+
+	// a supply without a limit is a mistake
+	if _, err := NewPowerLimiter(&config.PowerConfig{Supplies: []config.SupplyConfig{{}}}, 4); err == nil {
+		t.Error("no error for a supply without amps")
+	}
+	if _, err := NewPowerLimiter(&config.PowerConfig{Supplies: []config.SupplyConfig{{Group: "right", Amps: 1}}}, 4); err == nil {
+		t.Error("no error for an unknown group")
+	}
+}
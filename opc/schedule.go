@@ -0,0 +1,313 @@
+package opc
+
+// Scheduler
+//   Picks what to show, and how bright, by wall-clock time.  Rules come from the
+//   schedule section of the show config and can be relative to local sunrise and
+//   sunset, which are computed from the configured latitude and longitude.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+	"github.com/longears/pixelslinger/sun"
+)
+
+// kinds of schedule times
+const (
+	SCHEDULE_CLOCK   = "clock"
+	SCHEDULE_SUNRISE = "sunrise"
+	SCHEDULE_SUNSET  = "sunset"
+)
+
+// A parsed time of day like "18:30" or "sunset-0:30".
+type scheduleTime struct {
+	base   string        // one of the SCHEDULE_* constants
+	offset time.Duration // from midnight for clock times, otherwise from sunrise or sunset
+}
+
+// Parse "H:MM" into a duration.  Hours go up to 24 so offsets from sunrise and sunset
+// can be as long as a day.
+func parseHourMinute(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("expected H:MM, got %q", s)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("bad hour in %q", s)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("bad minute in %q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// Parse a schedule time like "18:30", "sunrise", or "sunset-0:30".
+func parseScheduleTime(s string) (scheduleTime, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, base := range []string{SCHEDULE_SUNRISE, SCHEDULE_SUNSET} {
+		if !strings.HasPrefix(s, base) {
+			continue
+		}
+		rest := s[len(base):]
+		if rest == "" {
+			return scheduleTime{base, 0}, nil
+		}
+		if rest[0] != '+' && rest[0] != '-' {
+			return scheduleTime{}, fmt.Errorf("expected + or - after %s, got %q", base, s)
+		}
+		offset, err := parseHourMinute(rest[1:])
+		if err != nil {
+			return scheduleTime{}, err
+		}
+		if rest[0] == '-' {
+			offset = -offset
+		}
+		return scheduleTime{base, offset}, nil
+	}
+	offset, err := parseHourMinute(s)
+	if err != nil {
+		return scheduleTime{}, err
+	}
+	// "24:00" is the end of the day, but a clock time past it would quietly mean tomorrow
+	if offset > 24*time.Hour {
+		return scheduleTime{}, fmt.Errorf("bad hour in %q", s)
+	}
+	return scheduleTime{SCHEDULE_CLOCK, offset}, nil
+}
+
+var DAY_NAMES = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// Parse one day of the week, as a number (0 or 7 is Sunday) or a three-letter name.
+func parseDay(s string) (int, error) {
+	if day, ok := DAY_NAMES[s]; ok {
+		return day, nil
+	}
+	day, err := strconv.Atoi(s)
+	if err != nil || day < 0 || day > 7 {
+		return 0, fmt.Errorf("bad day of the week %q", s)
+	}
+	return day % 7, nil
+}
+
+// Parse a cron-style day-of-week field like "*", "1-5", or "fri,sat".
+// The result is indexed by time.Weekday.
+func parseDays(s string) (days [7]bool, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "*" {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(item), "-", 2)
+		first, err := parseDay(bounds[0])
+		if err != nil {
+			return days, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseDay(bounds[1]); err != nil {
+				return days, err
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+type scheduleRule struct {
+	*config.ScheduleRule
+	days  [7]bool
+	start scheduleTime
+	end   scheduleTime
+}
+
+type Scheduler struct {
+	location config.LocationConfig
+	rules    []*scheduleRule
+}
+
+// Parse and check the rules of a schedule.
+func NewScheduler(scheduleConfig config.ScheduleConfig, location config.LocationConfig) (*Scheduler, error) {
+	s := &Scheduler{location: location}
+	for ii := range scheduleConfig.Rules {
+		rule := &scheduleRule{ScheduleRule: &scheduleConfig.Rules[ii]}
+		var err error
+		if rule.days, err = parseDays(rule.Days); err != nil {
+			return nil, fmt.Errorf("schedule rule %d: %v", ii+1, err)
+		}
+		if rule.start, err = parseScheduleTime(rule.Start); err != nil {
+			return nil, fmt.Errorf("schedule rule %d start: %v", ii+1, err)
+		}
+		if rule.end, err = parseScheduleTime(rule.End); err != nil {
+			return nil, fmt.Errorf("schedule rule %d end: %v", ii+1, err)
+		}
+		if rule.Pattern != "" {
+			if err := checkContained("schedule", rule.Pattern); err != nil {
+				return nil, fmt.Errorf("schedule rule %d: %v", ii+1, err)
+			}
+		}
+		if rule.Playlist != "" {
+			if _, ok := config.SHOW.Playlists[rule.Playlist]; !ok {
+				return nil, fmt.Errorf("schedule rule %d: unknown playlist %q", ii+1, rule.Playlist)
+			}
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return s, nil
+}
+
+// Return midnight at the start of t's day.
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Return the moment a schedule time happens on the day starting at the given midnight.
+// ok is false if it refers to a sunrise or sunset that doesn't happen that day.
+func (s *Scheduler) resolve(day time.Time, st scheduleTime) (result time.Time, ok bool) {
+	if st.base == SCHEDULE_CLOCK {
+		return day.Add(st.offset), true
+	}
+	rise, set, ok := sun.RiseSet(day, s.location.Latitude, s.location.Longitude)
+	if !ok {
+		return time.Time{}, false
+	}
+	if st.base == SCHEDULE_SUNRISE {
+		return rise.Add(st.offset), true
+	}
+	return set.Add(st.offset), true
+}
+
+// Return the index of the first rule whose window includes now, or -1 if none do.
+func (s *Scheduler) ActiveIndex(now time.Time) int {
+	for ii, rule := range s.rules {
+		// a window which started yesterday might still be running past midnight
+		for daysAgo := 0; daysAgo <= 1; daysAgo++ {
+			day := midnight(now).AddDate(0, 0, -daysAgo)
+			if !rule.days[day.Weekday()] {
+				continue
+			}
+			start, ok := s.resolve(day, rule.start)
+			if !ok {
+				continue
+			}
+			end, ok := s.resolve(day, rule.end)
+			if !ok {
+				continue
+			}
+			if !end.After(start) {
+				if end, ok = s.resolve(day.AddDate(0, 0, 1), rule.end); !ok {
+					continue
+				}
+			}
+			if !now.Before(start) && now.Before(end) {
+				return ii
+			}
+		}
+	}
+	return -1
+}
+
+// Return the rule whose window includes now, or nil if none do.
+func (s *Scheduler) Active(now time.Time) *config.ScheduleRule {
+	ii := s.ActiveIndex(now)
+	if ii < 0 {
+		return nil
+	}
+	return s.rules[ii].ScheduleRule
+}
+
+// Is now inside an "off" window?
+func (s *Scheduler) IsOff(now time.Time) bool {
+	rule := s.Active(now)
+	return rule != nil && rule.Off
+}
+
+// Return the active rule's brightness cap from 0 to 1, or 1 if it doesn't have one.
+func (s *Scheduler) Brightness(now time.Time) float64 {
+	rule := s.Active(now)
+	if rule == nil || rule.Brightness <= 0 {
+		return 1
+	}
+	return colorutils.Clamp(rule.Brightness, 0, 1)
+}
+
+// Dims every frame to the schedule's brightness cap, easing from one rule's cap to the
+// next.  It runs at the end of the pipeline, just before the power limiter, so that the
+// effects can't push past it whatever the source is.
+type BrightnessCap struct {
+	scheduler  *Scheduler // nil for no cap
+	brightness float64    // current cap, or -1 before the first frame
+}
+
+// Return a BrightnessCap which follows scheduler, which may be nil.
+func NewBrightnessCap(scheduler *Scheduler) *BrightnessCap {
+	return &BrightnessCap{scheduler: scheduler, brightness: -1}
+}
+
+func (bc *BrightnessCap) Init(locations []float64) {}
+
+// Move the cap towards the schedule's at now and return it.
+func (bc *BrightnessCap) update(now time.Time, dt float64) float64 {
+	target := 1.0
+	if bc.scheduler != nil {
+		target = bc.scheduler.Brightness(now)
+	}
+	if bc.brightness < 0 {
+		// start at the right brightness instead of easing into it
+		bc.brightness = target
+	}
+	step := dt / DEFAULT_PLAYLIST_TRANSITION_TIME
+	bc.brightness = colorutils.Clamp(target, bc.brightness-step, bc.brightness+step)
+	return bc.brightness
+}
+
+func (bc *BrightnessCap) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	brightness := bc.update(time.Now(), dt)
+	if brightness < 1 {
+		for ii, v := range bytes {
+			bytes[ii] = byte(float64(v)*brightness + 0.5)
+		}
+	}
+}
+
+func (bc *BrightnessCap) RenderFloat(colors []float32, t, dt float64, midiState *midi.MidiState) {
+	brightness := bc.update(time.Now(), dt)
+	if brightness < 1 {
+		for ii := range colors {
+			colors[ii] *= float32(brightness)
+		}
+	}
+}
+
+// Return the time of day on the scale the sunset pattern uses, following the real sky:
+// 0.25 is sunrise, 0.75 is sunset, and the day and the night are each stretched to fill half the cycle.
+// Where the sun doesn't rise or set today it falls back to the plain clock.
+func SkyTimeOfDay(now time.Time, location config.LocationConfig) float64 {
+	day := midnight(now)
+	rise, set, ok := sun.RiseSet(day, location.Latitude, location.Longitude)
+	if !ok {
+		return now.Sub(day).Hours() / 24
+	}
+	switch {
+	case now.Before(rise):
+		// after midnight, night started at yesterday's sunset
+		lastSet := set.AddDate(0, 0, -1)
+		return colorutils.PosMod2(0.75+0.5*now.Sub(lastSet).Hours()/rise.Sub(lastSet).Hours(), 1)
+	case now.Before(set):
+		return 0.25 + 0.5*now.Sub(rise).Hours()/set.Sub(rise).Hours()
+	default:
+		nextRise := rise.AddDate(0, 0, 1)
+		return colorutils.PosMod2(0.75+0.5*now.Sub(set).Hours()/nextRise.Sub(set).Hours(), 1)
+	}
+}
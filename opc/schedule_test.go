@@ -0,0 +1,179 @@
+package opc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/longears/pixelslinger/config"
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestParseScheduleTime(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    scheduleTime
+		wantErr bool
+	}{
+		{"18:30", scheduleTime{SCHEDULE_CLOCK, 18*time.Hour + 30*time.Minute}, false},
+		{" 0:05 ", scheduleTime{SCHEDULE_CLOCK, 5 * time.Minute}, false},
+		{"24:00", scheduleTime{SCHEDULE_CLOCK, 24 * time.Hour}, false},
+		{"sunrise", scheduleTime{SCHEDULE_SUNRISE, 0}, false},
+		{"Sunset-0:30", scheduleTime{SCHEDULE_SUNSET, -30 * time.Minute}, false},
+		{"sunrise+1:15", scheduleTime{SCHEDULE_SUNRISE, time.Hour + 15*time.Minute}, false},
+		{"sunset-24:30", scheduleTime{SCHEDULE_SUNSET, -24*time.Hour - 30*time.Minute}, false},
+		{"24:59", scheduleTime{}, true},
+		{"25:00", scheduleTime{}, true},
+		{"12:60", scheduleTime{}, true},
+		{"noon", scheduleTime{}, true},
+		{"18", scheduleTime{}, true},
+		{"sunset 0:30", scheduleTime{}, true},
+		{"sunset+later", scheduleTime{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseScheduleTime(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseScheduleTime(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseScheduleTime(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string // S M T W T F S, x for each day in the field
+		wantErr bool
+	}{
+		{"", "xxxxxxx", false},
+		{"*", "xxxxxxx", false},
+		{"1-5", ".xxxxx.", false},
+		{"fri,sat", ".....xx", false},
+		{"Sat-Mon", "xx....x", false}, // wraps around the weekend
+		{"0", "x......", false},
+		{"7", "x......", false},
+		{"3, 5", "...x.x.", false},
+		{"8", "", true},
+		{"someday", "", true},
+		{"1-funday", "", true},
+	}
+	for _, tt := range tests {
+		days, err := parseDays(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDays(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		got := ""
+		for _, on := range days {
+			if on {
+				got += "x"
+			} else {
+				got += "."
+			}
+		}
+		if got != tt.want {
+			t.Errorf("parseDays(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerActiveIndex(t *testing.T) {
+	scheduler, err := NewScheduler(config.ScheduleConfig{Rules: []config.ScheduleRule{
+		// friday and saturday nights, past midnight
+		{Start: "22:00", End: "2:00", Days: "fri,sat", Pattern: "fire", Brightness: 0.5},
+		// every night, after the first rule
+		{Start: "23:00", End: "6:00", Off: true},
+	}}, config.LocationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-06-21 is a friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		now  time.Time
+		want int
+	}{
+		{at(21, 21, 59), -1},
+		{at(21, 22, 0), 0},
+		{at(21, 23, 30), 0}, // the first rule wins
+		{at(22, 1, 59), 0},  // friday's window runs into saturday
+		{at(22, 2, 0), 1},
+		{at(22, 5, 59), 1},
+		{at(22, 6, 0), -1},
+		{at(22, 22, 30), 0},  // saturday
+		{at(23, 1, 0), 0},    // saturday's window runs into sunday
+		{at(23, 22, 30), -1}, // but not sunday's
+		{at(23, 23, 30), 1},
+		{at(20, 1, 0), 1}, // thursday morning is wednesday night
+	}
+	for _, tt := range tests {
+		if got := scheduler.ActiveIndex(tt.now); got != tt.want {
+			t.Errorf("ActiveIndex(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+
+	if b := scheduler.Brightness(at(21, 23, 0)); b != 0.5 {
+		t.Errorf("brightness = %v during the first rule, want 0.5", b)
+	}
+	if b := scheduler.Brightness(at(21, 12, 0)); b != 1 {
+		t.Errorf("brightness = %v outside the rules, want 1", b)
+	}
+	if !scheduler.IsOff(at(22, 3, 0)) || scheduler.IsOff(at(21, 23, 0)) {
+		t.Error("off at the wrong times")
+	}
+
+	for _, rule := range []config.ScheduleRule{
+		{Start: "later", End: "2:00"},
+		{Start: "1:00", End: "2:00", Days: "8"},
+		{Start: "1:00", End: "2:00", Pattern: "nothing"},
+		{Start: "1:00", End: "2:00", Playlist: "nothing"},
+	} {
+		if _, err := NewScheduler(config.ScheduleConfig{Rules: []config.ScheduleRule{rule}}, config.LocationConfig{}); err == nil {
+			t.Errorf("no error for rule %+v", rule)
+		}
+	}
+}
+
+func TestBrightnessCap(t *testing.T) {
+	scheduler, err := NewScheduler(config.ScheduleConfig{Rules: []config.ScheduleRule{
+		{Start: "20:00", End: "22:00", Brightness: 0.5},
+	}}, config.LocationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour int) time.Time {
+		return time.Date(2024, 6, 21, hour, 0, 0, 0, time.Local)
+	}
+	bc := NewBrightnessCap(scheduler)
+	// starts at the rule's cap, then eases at full brightness per DEFAULT_PLAYLIST_TRANSITION_TIME
+	if b := bc.update(at(21), 0.1); b != 0.5 {
+		t.Errorf("first frame = %v, want 0.5", b)
+	}
+	if b := bc.update(at(23), DEFAULT_PLAYLIST_TRANSITION_TIME/4); b != 0.75 {
+		t.Errorf("a quarter of the time later = %v, want 0.75", b)
+	}
+	if b := bc.update(at(23), DEFAULT_PLAYLIST_TRANSITION_TIME); b != 1 {
+		t.Errorf("after easing = %v, want 1", b)
+	}
+
+	bc = NewBrightnessCap(scheduler)
+	bc.brightness = 0.5
+	bytes := []byte{255, 100, 0}
+	bc.scheduler = nil // no schedule means no cap, but with no time passing it's still easing
+	bc.Render(bytes, 0, 0, &midi.MidiState{})
+	if string(bytes) != string([]byte{128, 50, 0}) {
+		t.Errorf("got %v, want half brightness", bytes)
+	}
+	colors := []float32{1, 0.5, 0}
+	bc.RenderFloat(colors, 0, 0, &midi.MidiState{})
+	if colors[0] != 0.5 || colors[1] != 0.25 {
+		t.Errorf("got %v, want half brightness", colors)
+	}
+}
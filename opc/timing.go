@@ -0,0 +1,241 @@
+package opc
+
+// Stage timing
+//   Each stage of the pipeline records how long it takes with each frame, and so
+//   does each effect in the chain, so that it's clear whether a pattern, an
+//   effect or the output is holding the framerate down.  mainLoop logs the
+//   numbers since the last report, can serve them over HTTP, and prints the
+//   whole run's at exit.
+//
+//   Percentiles come from a fixed-size random sample of the frames so that
+//   memory doesn't grow with the length of the show.
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const TIMING_SAMPLES = 4096 // frames kept per window for the percentiles
+
+// Summary of a stage's timing over some frames, in milliseconds.
+type TimingStats struct {
+	Frames int     `json:"frames"`
+	Min    float64 `json:"min_ms"`
+	Avg    float64 `json:"avg_ms"`
+	P95    float64 `json:"p95_ms"`
+	Max    float64 `json:"max_ms"`
+}
+
+func (s TimingStats) String() string {
+	return fmt.Sprintf("min %6.2f  avg %6.2f  p95 %6.2f  max %6.2f ms over %d frames", s.Min, s.Avg, s.P95, s.Max, s.Frames)
+}
+
+// Durations over some frames, in milliseconds.
+type timingWindow struct {
+	count    int
+	sum      float64
+	min, max float64
+	samples  []float64 // a random sample of up to TIMING_SAMPLES of the durations
+}
+
+func (w *timingWindow) add(ms float64, rng *rand.Rand) {
+	if w.count == 0 || ms < w.min {
+		w.min = ms
+	}
+	if w.count == 0 || ms > w.max {
+		w.max = ms
+	}
+	w.count += 1
+	w.sum += ms
+	// reservoir sampling: every frame so far is equally likely to be in the sample
+	if len(w.samples) < TIMING_SAMPLES {
+		w.samples = append(w.samples, ms)
+	} else if kk := rng.Intn(w.count); kk < TIMING_SAMPLES {
+		w.samples[kk] = ms
+	}
+}
+
+func (w *timingWindow) stats() TimingStats {
+	if w.count == 0 {
+		return TimingStats{}
+	}
+	sorted := append([]float64(nil), w.samples...)
+	sort.Float64s(sorted)
+	p95 := sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	return TimingStats{Frames: w.count, Min: w.min, Avg: w.sum / float64(w.count), P95: p95, Max: w.max}
+}
+
+// How long one stage takes, both recently and over the whole run.  Safe to use from
+// several goroutines.
+type Timing struct {
+	Name   string
+	mutex  sync.Mutex
+	rng    *rand.Rand
+	recent timingWindow // since the last call to Interval
+	total  timingWindow
+	last   TimingStats // what Interval returned last time
+}
+
+// Record one frame's duration.
+func (tm *Timing) Add(d time.Duration) {
+	ms := d.Seconds() * 1000
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.recent.add(ms, tm.rng)
+	tm.total.add(ms, tm.rng)
+}
+
+// Return the stats since the last call to Interval and start a new interval.
+func (tm *Timing) Interval() TimingStats {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.last = tm.recent.stats()
+	tm.recent = timingWindow{samples: tm.recent.samples[:0]}
+	return tm.last
+}
+
+// Return the stats over the whole run.
+func (tm *Timing) Total() TimingStats {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	return tm.total.stats()
+}
+
+// Frames which missed their deadline.  A late frame took longer than its share of the
+// framerate, and a dropped frame is one which was never made because of late ones.
+type FrameCounts struct {
+	Frames  int `json:"frames"`
+	Late    int `json:"late"`
+	Dropped int `json:"dropped"`
+}
+
+// All the timings, in the order they were first asked for.
+type Timings struct {
+	mutex   sync.Mutex
+	timings []*Timing
+	counts  map[string]*FrameCounts
+	order   []string // keys of counts in the order they were first counted
+}
+
+var TIMINGS = &Timings{}
+
+// Return the named Timing, making it if it's new.
+func (ts *Timings) Get(name string) *Timing {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	for _, tm := range ts.timings {
+		if tm.Name == name {
+			return tm
+		}
+	}
+	tm := &Timing{Name: name, rng: rand.New(rand.NewSource(int64(len(ts.timings))))}
+	ts.timings = append(ts.timings, tm)
+	return tm
+}
+
+// Return every Timing in the order they were made.
+func (ts *Timings) All() []*Timing {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	return append([]*Timing(nil), ts.timings...)
+}
+
+// Count a frame of the named kind (e.g. "output"), whether it was late, and how many
+// frames were dropped before it.
+func (ts *Timings) CountFrame(name string, late bool, dropped int) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if ts.counts == nil {
+		ts.counts = map[string]*FrameCounts{}
+	}
+	counts, ok := ts.counts[name]
+	if !ok {
+		counts = &FrameCounts{}
+		ts.counts[name] = counts
+		ts.order = append(ts.order, name)
+	}
+	counts.Frames += 1
+	if late {
+		counts.Late += 1
+	}
+	counts.Dropped += dropped
+}
+
+// Return the frame counts of the named kind over the whole run.
+func (ts *Timings) Counts(name string) FrameCounts {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if counts, ok := ts.counts[name]; ok {
+		return *counts
+	}
+	return FrameCounts{}
+}
+
+// One stage's timing as reported over HTTP.
+type StageReport struct {
+	Name     string      `json:"name"`
+	Interval TimingStats `json:"interval"` // the last interval mainLoop logged
+	Total    TimingStats `json:"total"`
+}
+
+// Everything the Timings know, for expvar.
+type TimingReport struct {
+	Stages []StageReport          `json:"stages"`
+	Frames map[string]FrameCounts `json:"frames"`
+}
+
+// Return a snapshot of every Timing and frame count, without starting a new interval.
+func (ts *Timings) Report() TimingReport {
+	report := TimingReport{Frames: map[string]FrameCounts{}}
+	for _, tm := range ts.All() {
+		tm.mutex.Lock()
+		report.Stages = append(report.Stages, StageReport{Name: tm.Name, Interval: tm.last, Total: tm.total.stats()})
+		tm.mutex.Unlock()
+	}
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	for name, counts := range ts.counts {
+		report.Frames[name] = *counts
+	}
+	return report
+}
+
+// Return the kinds of frames counted so far, in the order they were first counted.
+func (ts *Timings) CountNames() []string {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	return append([]string(nil), ts.order...)
+}
+
+//--------------------------------------------------------------------------------
+// STAGES
+
+type timedStage struct {
+	thread Stage
+	timing *Timing
+}
+
+// Return a Stage which runs thread and records how long it takes with each frame in timing.
+func TimeStage(thread Stage, timing *Timing) Stage {
+	return &timedStage{thread: thread, timing: timing}
+}
+
+func (s *timedStage) runStage(framesIn chan *Frame, framesOut chan *Frame) {
+	innerIn := make(chan *Frame, 0)
+	innerOut := make(chan *Frame, 0)
+	s.thread.runStage(innerIn, innerOut)
+	go func() {
+		defer close(innerIn)
+		for frame := range framesIn {
+			start := time.Now()
+			innerIn <- frame
+			frame = <-innerOut
+			s.timing.Add(time.Since(start))
+			framesOut <- frame
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package opc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+func TestTiming(t *testing.T) {
+	tm := (&Timings{}).Get("test")
+	for ms := 1; ms <= 100; ms++ {
+		tm.Add(time.Duration(ms) * time.Millisecond)
+	}
+	stats := tm.Interval()
+	want := TimingStats{Frames: 100, Min: 1, Avg: 50.5, P95: 95, Max: 100}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+
+	// a new interval starts empty, but the total keeps counting
+	tm.Add(200 * time.Millisecond)
+	if stats := tm.Interval(); stats.Frames != 1 || stats.Min != 200 {
+		t.Errorf("got %+v for the second interval, want just the 200 ms frame", stats)
+	}
+	if stats := tm.Total(); stats.Frames != 101 || stats.Max != 200 || stats.Min != 1 {
+		t.Errorf("got %+v for the total, want all 101 frames", stats)
+	}
+
+	// the sample for the percentiles stays the same size however long the run is
+	for ii := 0; ii < TIMING_SAMPLES*2; ii++ {
+		tm.Add(time.Millisecond)
+	}
+	if len(tm.total.samples) != TIMING_SAMPLES {
+		t.Errorf("kept %v samples, want %v", len(tm.total.samples), TIMING_SAMPLES)
+	}
+	if stats := tm.Total(); stats.P95 != 1 {
+		t.Errorf("p95 = %v, want 1", stats.P95)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	ts := &Timings{}
+	if ts.Get("a") != ts.Get("a") {
+		t.Error("got two Timings with the same name")
+	}
+	ts.Get("b")
+	if all := ts.All(); len(all) != 2 || all[0].Name != "a" || all[1].Name != "b" {
+		t.Errorf("got %v, want a then b", all)
+	}
+
+	ts.CountFrame("output", false, 0)
+	ts.CountFrame("output", true, 2)
+	want := FrameCounts{Frames: 2, Late: 1, Dropped: 2}
+	if counts := ts.Counts("output"); counts != want {
+		t.Errorf("got %+v, want %+v", counts, want)
+	}
+	if report := ts.Report(); len(report.Stages) != 2 || report.Frames["output"] != want {
+		t.Errorf("got %+v", report)
+	}
+}
+
+func TestTimeStage(t *testing.T) {
+	var byteThread ByteThread = func(bytesIn chan []byte, bytesOut chan []byte, midiState *midi.MidiState) {
+		for bytes := range bytesIn {
+			time.Sleep(time.Millisecond)
+			bytesOut <- bytes
+		}
+	}
+	tm := (&Timings{}).Get("stage")
+	framesIn := make(chan *Frame)
+	framesOut := make(chan *Frame)
+	RunStage(TimeStage(byteThread, tm), framesIn, framesOut)
+	defer close(framesIn)
+	for ii := 0; ii < 3; ii++ {
+		framesIn <- &Frame{Bytes: make([]byte, 3), MidiState: &midi.MidiState{}}
+		<-framesOut
+	}
+	if stats := tm.Total(); stats.Frames != 3 || stats.Min < 1 {
+		t.Errorf("got %+v, want 3 frames of at least 1 ms", stats)
+	}
+}
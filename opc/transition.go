@@ -0,0 +1,195 @@
+package opc
+
+// Transitions
+//   Blend from one pattern to another over time instead of cutting between them.
+//   Both patterns keep rendering while the transition is running.
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/midi"
+)
+
+// kinds of transitions
+const (
+	TRANSITION_CUT       = "cut"       // switch immediately
+	TRANSITION_CROSSFADE = "crossfade" // linear crossfade
+	TRANSITION_BLACK     = "black"     // fade out to black, then fade in
+	TRANSITION_WIPE_X    = "wipe-x"    // wipe along the x axis
+	TRANSITION_WIPE_Y    = "wipe-y"    // wipe along the y axis
+	TRANSITION_WIPE_Z    = "wipe-z"    // wipe along the z axis, bottom to top
+	TRANSITION_DISSOLVE  = "dissolve"  // pixels switch over one at a time in random order
+)
+
+// Width of the soft edge of a wipe, relative to the layout's bounding box.
+const WIPE_SOFTNESS = 0.15
+
+// Return an error if kind isn't one of the TRANSITION_* constants.
+func CheckTransitionKind(kind string) error {
+	switch kind {
+	case TRANSITION_CUT, TRANSITION_CROSSFADE, TRANSITION_BLACK,
+		TRANSITION_WIPE_X, TRANSITION_WIPE_Y, TRANSITION_WIPE_Z, TRANSITION_DISSOLVE:
+		return nil
+	}
+	return fmt.Errorf("unknown transition %q", kind)
+}
+
+// Keeps track of a transition from one Pattern to another.
+// The caller owns both patterns; the transitioner only renders them.
+type transitioner struct {
+	axisPos    [3][]float64 // per-pixel position from 0 to 1 along x, y, and z
+	thresholds []float64    // per-pixel random thresholds for dissolving
+	fromBytes  []byte       // scratch buffer for the outgoing pattern
+	fromColors []float32    // the same for high-precision frames
+	scratch    []byte       // for rendering patterns which aren't FloatPatterns into colors
+
+	from     Pattern // nil when no transition is running
+	kind     string
+	progress float64 // 0 at the start of the transition, 1 at the end
+}
+
+func newTransitioner(locations []float64) *transitioner {
+	tr := &transitioner{}
+	n_pixels := len(locations) / 3
+
+	// normalize each axis of the bounding box to the range 0-1 for wipes
+	for axis := 0; axis < 3; axis++ {
+		var minn, maxx float64
+		for ii := 0; ii < n_pixels; ii++ {
+			v := locations[ii*3+axis]
+			if ii == 0 || v < minn {
+				minn = v
+			}
+			if ii == 0 || v > maxx {
+				maxx = v
+			}
+		}
+		tr.axisPos[axis] = make([]float64, n_pixels)
+		for ii := 0; ii < n_pixels; ii++ {
+			tr.axisPos[axis][ii] = colorutils.Remap(locations[ii*3+axis], minn, maxx, 0, 1)
+		}
+	}
+
+	// make persistant random values
+	rng := rand.New(rand.NewSource(17))
+	tr.thresholds = make([]float64, n_pixels)
+	for ii := range tr.thresholds {
+		tr.thresholds[ii] = rng.Float64()
+	}
+	return tr
+}
+
+// Begin a transition away from the given pattern.
+// If a transition was already running, its outgoing pattern is dropped.
+func (tr *transitioner) Start(from Pattern, kind string) {
+	if kind == TRANSITION_CUT {
+		tr.from = nil
+		return
+	}
+	tr.from = from
+	tr.kind = kind
+	tr.progress = 0
+}
+
+// Is a transition running right now?
+func (tr *transitioner) Running() bool {
+	return tr.from != nil
+}
+
+// Render the incoming pattern "to" into bytes, blended with the outgoing pattern
+// if a transition is running.  duration is the length of the whole transition in seconds.
+func (tr *transitioner) Render(to Pattern, bytes []byte, t, dt, duration float64, midiState *midi.MidiState) {
+	to.Render(bytes, t, dt, midiState)
+	if !tr.advance(dt, duration) {
+		return
+	}
+
+	if len(tr.fromBytes) != len(bytes) {
+		tr.fromBytes = make([]byte, len(bytes))
+	}
+	tr.from.Render(tr.fromBytes, t, dt, midiState)
+	tr.blend(bytes, tr.fromBytes, bytes, tr.progress)
+}
+
+// Like Render, but into high-precision colors.
+func (tr *transitioner) RenderFloat(to Pattern, colors []float32, t, dt, duration float64, midiState *midi.MidiState) {
+	renderFloat(to, colors, &tr.scratch, t, dt, midiState)
+	if !tr.advance(dt, duration) {
+		return
+	}
+
+	if len(tr.fromColors) != len(colors) {
+		tr.fromColors = make([]float32, len(colors))
+	}
+	renderFloat(tr.from, tr.fromColors, &tr.scratch, t, dt, midiState)
+	n_pixels := len(colors) / 3
+	for ii := 0; ii < n_pixels; ii++ {
+		fromAmt, toAmt := tr.amounts(ii, tr.progress)
+		for jj := ii * 3; jj < ii*3+3; jj++ {
+			colors[jj] = float32(float64(tr.fromColors[jj])*fromAmt + float64(colors[jj])*toAmt)
+		}
+	}
+}
+
+// Move the transition along by dt and return whether it's still running.
+// duration is the length of the whole transition in seconds.
+func (tr *transitioner) advance(dt, duration float64) bool {
+	if tr.from == nil {
+		return false
+	}
+	if duration <= 0 {
+		tr.progress = 1
+	} else {
+		tr.progress += dt / duration
+	}
+	if tr.progress >= 1 {
+		tr.from = nil
+		return false
+	}
+	return true
+}
+
+// Write the blend of fromBytes and toBytes into out.
+// amount goes from 0 (all fromBytes) to 1 (all toBytes).
+// out may be the same slice as fromBytes or toBytes.
+func (tr *transitioner) blend(out, fromBytes, toBytes []byte, amount float64) {
+	n_pixels := len(out) / 3
+	for ii := 0; ii < n_pixels; ii++ {
+		fromAmt, toAmt := tr.amounts(ii, amount)
+		for jj := ii * 3; jj < ii*3+3; jj++ {
+			v := float64(fromBytes[jj])*fromAmt + float64(toBytes[jj])*toAmt
+			out[jj] = byte(colorutils.Clamp(v+0.5, 0, 255))
+		}
+	}
+}
+
+// Return how much of the outgoing and incoming patterns to show at pixel ii
+// when the transition is amount of the way through.
+func (tr *transitioner) amounts(ii int, amount float64) (fromAmt, toAmt float64) {
+	switch tr.kind {
+	case TRANSITION_BLACK:
+		fromAmt = colorutils.Clamp(1-amount*2, 0, 1)
+		toAmt = colorutils.Clamp(amount*2-1, 0, 1)
+	case TRANSITION_WIPE_X, TRANSITION_WIPE_Y, TRANSITION_WIPE_Z:
+		axis := int(tr.kind[len(tr.kind)-1] - 'x')
+		pos := 0.0
+		if ii < len(tr.axisPos[axis]) {
+			pos = tr.axisPos[axis][ii]
+		}
+		// the soft edge starts just before 0 and ends just after 1
+		edge := amount*(1+WIPE_SOFTNESS*2) - WIPE_SOFTNESS
+		toAmt = colorutils.RemapAndClamp(pos, edge+WIPE_SOFTNESS, edge-WIPE_SOFTNESS, 0, 1)
+		fromAmt = 1 - toAmt
+	case TRANSITION_DISSOLVE:
+		if ii < len(tr.thresholds) && tr.thresholds[ii] < amount {
+			toAmt = 1
+		}
+		fromAmt = 1 - toAmt
+	default: // TRANSITION_CROSSFADE
+		toAmt = amount
+		fromAmt = 1 - amount
+	}
+	return fromAmt, toAmt
+}
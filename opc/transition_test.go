@@ -0,0 +1,122 @@
+package opc
+
+import (
+	"testing"
+
+	"github.com/longears/pixelslinger/midi"
+)
+
+// Fills every pixel with one color.
+type solidPattern struct {
+	r, g, b byte
+}
+
+func (p *solidPattern) Init(locations []float64) {}
+
+func (p *solidPattern) Render(bytes []byte, t, dt float64, midiState *midi.MidiState) {
+	for ii := 0; ii+2 < len(bytes); ii += 3 {
+		bytes[ii], bytes[ii+1], bytes[ii+2] = p.r, p.g, p.b
+	}
+}
+
+var TRANSITION_TEST_TO = []byte{
+	0, 128, 255, 0, 128, 255, 0, 128, 255, 0, 128, 255,
+	0, 128, 255, 0, 128, 255, 0, 128, 255, 0, 128, 255,
+}
+
+func TestTransitionBlend(t *testing.T) {
+	tests := []struct {
+		kind   string
+		amount float64
+		want   []byte
+	}{
+		{TRANSITION_CROSSFADE, 0.25, []byte{
+			191, 32, 64, 0, 223, 64, 0, 32, 255, 191, 223, 255,
+			96, 80, 88, 8, 47, 86, 150, 107, 64, 0, 32, 64}},
+		{TRANSITION_CROSSFADE, 0.5, []byte{
+			128, 64, 128, 0, 192, 128, 0, 64, 255, 128, 192, 255,
+			64, 96, 144, 5, 74, 143, 100, 114, 128, 0, 64, 128}},
+		{TRANSITION_BLACK, 0.25, []byte{
+			128, 0, 0, 0, 128, 0, 0, 0, 128, 128, 128, 128,
+			64, 32, 16, 5, 10, 15, 100, 50, 0, 0, 0, 0}},
+		{TRANSITION_BLACK, 0.5, make([]byte, 24)},
+		{TRANSITION_BLACK, 0.75, []byte{
+			0, 64, 128, 0, 64, 128, 0, 64, 128, 0, 64, 128,
+			0, 64, 128, 0, 64, 128, 0, 64, 128, 0, 64, 128}},
+		{TRANSITION_WIPE_X, 0.5, []byte{
+			255, 0, 0, 0, 255, 0, 0, 50, 255, 0, 128, 255,
+			0, 128, 255, 0, 128, 255, 122, 111, 99, 0, 0, 0}},
+		{TRANSITION_WIPE_Z, 0.5, []byte{
+			212, 21, 43, 0, 255, 0, 0, 0, 255, 255, 255, 255,
+			107, 75, 69, 0, 128, 255, 0, 128, 255, 0, 128, 255}},
+		{TRANSITION_DISSOLVE, 0.5, []byte{
+			255, 0, 0, 0, 128, 255, 0, 128, 255, 0, 128, 255,
+			0, 128, 255, 10, 20, 30, 0, 128, 255, 0, 128, 255}},
+	}
+	for _, tt := range tests {
+		tr := newTransitioner(EFFECT_TEST_LOCATIONS)
+		tr.kind = tt.kind
+		out := make([]byte, 24)
+		tr.blend(out, EFFECT_TEST_INPUT, TRANSITION_TEST_TO, tt.amount)
+		if string(out) != string(tt.want) {
+			t.Errorf("%s at %v:\n got %v\nwant %v", tt.kind, tt.amount, out, tt.want)
+		}
+
+		// every kind starts on the outgoing frame and ends on the incoming one
+		tr.blend(out, EFFECT_TEST_INPUT, TRANSITION_TEST_TO, 0)
+		if string(out) != string(EFFECT_TEST_INPUT) {
+			t.Errorf("%s at 0: got %v, want the outgoing frame", tt.kind, out)
+		}
+		tr.blend(out, EFFECT_TEST_INPUT, TRANSITION_TEST_TO, 1)
+		if string(out) != string(TRANSITION_TEST_TO) {
+			t.Errorf("%s at 1: got %v, want the incoming frame", tt.kind, out)
+		}
+	}
+}
+
+func TestTransitionProgress(t *testing.T) {
+	from := &solidPattern{255, 0, 0}
+	to := &solidPattern{0, 0, 255}
+	midiState := &midi.MidiState{}
+	bytes := make([]byte, 3)
+
+	tr := newTransitioner([]float64{0, 0, 0})
+	tr.Start(from, TRANSITION_CROSSFADE)
+	for ii, want := range []byte{191, 128, 64} {
+		// a 2 second crossfade, half a second at a time
+		tr.Render(to, bytes, 0, 0.5, 2, midiState)
+		if bytes[0] != want || int(bytes[0])+int(bytes[2]) < 255 || int(bytes[0])+int(bytes[2]) > 256 {
+			t.Errorf("step %d: got %v, want red %v and the rest blue", ii, bytes, want)
+		}
+	}
+	if !tr.Running() {
+		t.Error("finished early")
+	}
+	tr.Render(to, bytes, 0, 0.5, 2, midiState)
+	if tr.Running() || bytes[0] != 0 {
+		t.Errorf("got %v and running %v at the end, want just the incoming pattern", bytes, tr.Running())
+	}
+
+	// cuts and zero-length transitions finish straight away
+	tr.Start(from, TRANSITION_CUT)
+	if tr.Running() {
+		t.Error("a cut is running")
+	}
+	tr.Start(from, TRANSITION_CROSSFADE)
+	tr.Render(to, bytes, 0, 0, 0, midiState)
+	if tr.Running() || bytes[0] != 0 {
+		t.Errorf("got %v from a zero-length transition, want the incoming pattern", bytes)
+	}
+
+	// high-precision frames go the same way
+	colors := make([]float32, 3)
+	tr.Start(from, TRANSITION_CROSSFADE)
+	tr.RenderFloat(to, colors, 0, 0.5, 2, midiState)
+	if colors[0] != 0.75 || colors[2] != 0.25 {
+		t.Errorf("got %v a quarter of the way through, want [0.75 0 0.25]", colors)
+	}
+
+	if err := CheckTransitionKind("sideways"); err == nil {
+		t.Error("no error for an unknown transition")
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Package osc decodes and encodes Open Sound Control 1.0 messages and bundles, and
+runs a UDP server which receives them and sends replies to its clients.
+
+Bundles are unpacked into their messages as soon as they arrive; their time tags
+are ignored.
+
+For more details on OSC:
+
+http://opensoundcontrol.org/spec-1_0
+*/
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const BUNDLE_TAG = "#bundle"
+
+// An OSC message.  Args hold int32, float32, string, []byte, int64, float64, bool,
+// or nil values, depending on their type tags.
+type Message struct {
+	Address string
+	Args    []interface{}
+}
+
+func (m *Message) String() string {
+	args := make([]string, len(m.Args))
+	for ii, arg := range m.Args {
+		args[ii] = fmt.Sprint(arg)
+	}
+	return fmt.Sprintf("(%s %s)", m.Address, strings.Join(args, " "))
+}
+
+// Return argument ii as a float64, and whether it's a number or a boolean.
+func (m *Message) Float(ii int) (float64, bool) {
+	if ii >= len(m.Args) {
+		return 0, false
+	}
+	switch arg := m.Args[ii].(type) {
+	case int32:
+		return float64(arg), true
+	case int64:
+		return float64(arg), true
+	case float32:
+		return float64(arg), true
+	case float64:
+		return arg, true
+	case bool:
+		if arg {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		// some clients send numbers as strings
+		v, err := strconv.ParseFloat(arg, 64)
+		return v, err == nil
+	}
+	return 0, false
+}
+
+//================================================================================
+// DECODE
+
+// Return the messages in an OSC packet, which holds either one message or a bundle.
+// Bundles inside bundles are unpacked too.
+func Decode(packet []byte) ([]*Message, error) {
+	if len(packet) == 0 || len(packet)%4 != 0 {
+		return nil, fmt.Errorf("packet length %d isn't a multiple of 4", len(packet))
+	}
+	if packet[0] == '#' {
+		return decodeBundle(packet)
+	}
+	m, err := decodeMessage(packet)
+	if err != nil {
+		return nil, err
+	}
+	return []*Message{m}, nil
+}
+
+func decodeBundle(packet []byte) ([]*Message, error) {
+	r := &reader{data: packet}
+	if tag := r.string(); tag != BUNDLE_TAG {
+		return nil, fmt.Errorf("bad bundle tag %q", tag)
+	}
+	r.int64() // time tag
+	messages := []*Message{}
+	for r.err == nil && r.pos < len(r.data) {
+		size := int(r.int32())
+		element := r.bytes(size)
+		if r.err != nil {
+			break
+		}
+		elementMessages, err := Decode(element)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, elementMessages...)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("bundle: %v", r.err)
+	}
+	return messages, nil
+}
+
+func decodeMessage(packet []byte) (*Message, error) {
+	r := &reader{data: packet}
+	m := &Message{Address: r.string()}
+	if r.err != nil || !strings.HasPrefix(m.Address, "/") {
+		return nil, fmt.Errorf("bad address %q", m.Address)
+	}
+	if r.pos == len(r.data) {
+		// very old clients leave out the type tags when there are no arguments
+		return m, nil
+	}
+	tags := r.string()
+	if !strings.HasPrefix(tags, ",") {
+		return nil, fmt.Errorf("%s: bad type tags %q", m.Address, tags)
+	}
+	for _, tag := range tags[1:] {
+		switch tag {
+		case 'i':
+			m.Args = append(m.Args, r.int32())
+		case 'f':
+			m.Args = append(m.Args, math.Float32frombits(uint32(r.int32())))
+		case 's', 'S':
+			m.Args = append(m.Args, r.string())
+		case 'b':
+			blob := r.bytes(int(r.int32()))
+			m.Args = append(m.Args, append([]byte{}, blob...))
+		case 'h', 't':
+			m.Args = append(m.Args, r.int64())
+		case 'd':
+			m.Args = append(m.Args, math.Float64frombits(uint64(r.int64())))
+		case 'c', 'r', 'm':
+			m.Args = append(m.Args, r.int32())
+		case 'T':
+			m.Args = append(m.Args, true)
+		case 'F':
+			m.Args = append(m.Args, false)
+		case 'N', 'I':
+			m.Args = append(m.Args, nil)
+		default:
+			return nil, fmt.Errorf("%s: unknown type tag %q", m.Address, tag)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%s: %v", m.Address, r.err)
+	}
+	return m, nil
+}
+
+// Reads the big-endian, 4-byte aligned pieces of an OSC packet.
+// After the first error, reads return zero values and err keeps the error.
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// Return the next n bytes and skip the padding after them.
+func (r *reader) bytes(n int) []byte {
+	padded := (n + 3) &^ 3
+	if r.err != nil || n < 0 || r.pos+padded > len(r.data) {
+		if r.err == nil {
+			r.err = fmt.Errorf("ran out of data at byte %d", r.pos)
+		}
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += padded
+	return b
+}
+
+func (r *reader) int32() int32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (r *reader) int64() int64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// Return the next null-terminated string.
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.pos:], 0)
+	if end < 0 {
+		r.err = fmt.Errorf("unterminated string at byte %d", r.pos)
+		return ""
+	}
+	return string(r.bytes(end + 1)[:end])
+}
+
+//================================================================================
+// ENCODE
+
+// Return the OSC packet for a message.
+// Arguments can be int32, int (sent as int32), float32, float64 (sent as float32,
+// which is all most clients understand), string, []byte, int64, bool, or nil.
+func (m *Message) Bytes() ([]byte, error) {
+	tags := []byte{','}
+	args := &bytes.Buffer{}
+	for _, arg := range m.Args {
+		switch arg := arg.(type) {
+		case int32:
+			tags = append(tags, 'i')
+			binary.Write(args, binary.BigEndian, arg)
+		case int:
+			tags = append(tags, 'i')
+			binary.Write(args, binary.BigEndian, int32(arg))
+		case float32:
+			tags = append(tags, 'f')
+			binary.Write(args, binary.BigEndian, arg)
+		case float64:
+			tags = append(tags, 'f')
+			binary.Write(args, binary.BigEndian, float32(arg))
+		case string:
+			tags = append(tags, 's')
+			writeString(args, arg)
+		case []byte:
+			tags = append(tags, 'b')
+			binary.Write(args, binary.BigEndian, int32(len(arg)))
+			args.Write(arg)
+			args.Write(make([]byte, (4-len(arg)%4)%4))
+		case int64:
+			tags = append(tags, 'h')
+			binary.Write(args, binary.BigEndian, arg)
+		case bool:
+			if arg {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		case nil:
+			tags = append(tags, 'N')
+		default:
+			return nil, fmt.Errorf("%s: can't send a %T", m.Address, arg)
+		}
+	}
+	packet := &bytes.Buffer{}
+	writeString(packet, m.Address)
+	writeString(packet, string(tags))
+	packet.Write(args.Bytes())
+	return packet.Bytes(), nil
+}
+
+// Return an OSC bundle packet holding the messages, to be carried out immediately.
+func Bundle(messages []*Message) ([]byte, error) {
+	packet := &bytes.Buffer{}
+	writeString(packet, BUNDLE_TAG)
+	binary.Write(packet, binary.BigEndian, int64(1)) // "immediately"
+	for _, m := range messages {
+		element, err := m.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(packet, binary.BigEndian, int32(len(element)))
+		packet.Write(element)
+	}
+	return packet.Bytes(), nil
+}
+
+// Write a null-terminated string padded to a multiple of 4 bytes.
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.Write(make([]byte, 4-len(s)%4))
+}
@@ -0,0 +1,103 @@
+package osc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	packet := []byte{
+		'/', 'k', 'n', 'o', 'b', '/', 'g', 'a', 'i', 'n', 0, 0,
+		',', 'f', 'i', 's', 'T', 0, 0, 0,
+		0x3f, 0x00, 0x00, 0x00, // 0.5
+		0x00, 0x00, 0x00, 0x07,
+		'h', 'i', 0, 0,
+	}
+	messages, err := Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	m := messages[0]
+	if m.Address != "/knob/gain" || len(m.Args) != 4 {
+		t.Fatalf("got %v", m)
+	}
+	if m.Args[0] != float32(0.5) || m.Args[1] != int32(7) || m.Args[2] != "hi" || m.Args[3] != true {
+		t.Errorf("got args %#v", m.Args)
+	}
+	if v, ok := m.Float(0); !ok || v != 0.5 {
+		t.Errorf("got Float(0) = %v %v, want 0.5", v, ok)
+	}
+
+	if _, err := Decode(packet[:len(packet)-4]); err == nil {
+		t.Errorf("decoding a truncated message should fail")
+	}
+}
+
+func TestRoundTripBundle(t *testing.T) {
+	sent := []*Message{
+		{Address: "/pad/flash", Args: []interface{}{float32(1)}},
+		{Address: "/pattern/select", Args: []interface{}{int32(3), "fire", []byte{1, 2, 3, 4, 5}, int64(-2), false, nil}},
+	}
+	packet, err := Bundle(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := Decode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != len(sent) {
+		t.Fatalf("got %d messages, want %d", len(received), len(sent))
+	}
+	for ii := range sent {
+		if received[ii].String() != sent[ii].String() {
+			t.Errorf("got %v, want %v", received[ii], sent[ii])
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	server, err := Listen("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := net.DialUDP("udp", nil, server.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	packet, _ := (&Message{Address: "/knob/speed", Args: []interface{}{0.25}}).Bytes()
+	if _, err := client.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-server.Messages:
+		if v, _ := m.Float(0); m.Address != "/knob/speed" || v != 0.25 {
+			t.Errorf("got %v, want /knob/speed 0.25", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server didn't receive the message")
+	}
+
+	// replies go back to the client
+	if err := server.Send([]*Message{{Address: "/knob/speed", Args: []interface{}{0.5}}}); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, MAX_PACKET_SIZE)
+	count, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies, err := Decode(buf[:count])
+	if err != nil || len(replies) != 1 || replies[0].Address != "/knob/speed" {
+		t.Errorf("got replies %v (%v)", replies, err)
+	}
+}
@@ -0,0 +1,107 @@
+package osc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Largest packet we can receive.
+const MAX_PACKET_SIZE = 65536
+
+// Listens for OSC packets on a UDP port and keeps track of who sent them, so
+// replies can be sent back to every client.
+type Server struct {
+	Messages chan *Message // messages from every client
+
+	conn      *net.UDPConn
+	replyPort int // port to send replies to, or 0 for the port each client sends from
+
+	mutex   sync.Mutex
+	clients map[string]*net.UDPAddr // where to send replies, by address
+}
+
+// Start listening on a UDP address like ":8000" and launch a thread which
+// decodes incoming packets onto the Messages channel.
+// Replies go to each client's address on replyPort, or the port it sent from if replyPort is 0.
+// (TouchOSC, for example, listens on a different port than it sends from.)
+func Listen(address string, replyPort int) (*Server, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Messages:  make(chan *Message, 500),
+		conn:      conn,
+		replyPort: replyPort,
+		clients:   make(map[string]*net.UDPAddr),
+	}
+	fmt.Println("[osc] listening on", conn.LocalAddr())
+	go s.readThread()
+	return s, nil
+}
+
+// Return the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Stop listening.  The Messages channel is closed once the read thread notices.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Server) readThread() {
+	defer close(s.Messages)
+	buf := make([]byte, MAX_PACKET_SIZE)
+	for {
+		count, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			fmt.Println("[osc] stopped listening:", err)
+			return
+		}
+
+		replyTo := &net.UDPAddr{IP: from.IP, Port: from.Port, Zone: from.Zone}
+		if s.replyPort != 0 {
+			replyTo.Port = s.replyPort
+		}
+		s.mutex.Lock()
+		if _, ok := s.clients[replyTo.String()]; !ok {
+			fmt.Println("[osc] new client", from, "... replying to", replyTo)
+			s.clients[replyTo.String()] = replyTo
+		}
+		s.mutex.Unlock()
+
+		messages, err := Decode(buf[:count])
+		if err != nil {
+			fmt.Println("[osc] bad packet from", from, ":", err)
+			continue
+		}
+		for _, m := range messages {
+			s.Messages <- m
+		}
+	}
+}
+
+// Send the messages to every client that has sent us something, in one bundle.
+func (s *Server) Send(messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	packet, err := Bundle(messages)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, client := range s.clients {
+		if _, err := s.conn.WriteToUDP(packet, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
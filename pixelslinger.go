@@ -4,17 +4,24 @@ package main
 // when we're getting pixels via our OPC server source
 
 import (
+	"expvar"
 	"fmt"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"runtime"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 	"github.com/droundy/goopt"
 	"github.com/austinfromboston/pixelslinger/beaglebone"
-	"github.com/austinfromboston/pixelslinger/config"
+	"github.com/longears/pixelslinger/colorutils"
+	"github.com/longears/pixelslinger/config"
 	"github.com/longears/pixelslinger/midi"
 	"github.com/austinfromboston/pixelslinger/opc"
+	"github.com/austinfromboston/pixelslinger/osc"
 	"github.com/austinfromboston/pixelslinger/potty"
 	"github.com/pkg/profile"
 )
@@ -37,14 +44,43 @@ var LAYOUT_FN = goopt.String([]string{"-l", "--layout"}, "...", "layout file (re
 var SOURCE = goopt.String([]string{"-s", "--source"}, "spatial-stripes", "pixel source (either a pattern name or "+LOCALHOST+"[:port])")
 var DEST = goopt.String([]string{"-d", "--dest"}, "localhost", "destination (one of "+PRINT_MAGIC_WORD+", "+SPI_MAGIC_WORD+", "+DEVNULL_MAGIC_WORD+", or hostname[:port])")
 var FPS = goopt.Int([]string{"-f", "--fps"}, 40, "max frames per second")
+var RENDER_FPS = goopt.Int([]string{"--render-fps"}, 0, "render the patterns at this rate and interpolate between their frames up to --fps, or 0 to render every frame")
 var SECONDS = goopt.Int([]string{"-n", "--seconds"}, 0, "quit after this many seconds")
 var ONCE = goopt.Flag([]string{"-o", "--once"}, []string{}, "quit after one frame", "")
+var CONFIG_FN = goopt.String([]string{"-c", "--config"}, "", "show config file (JSON)")
+var PARAM_FLAGS = goopt.Strings([]string{"-p", "--param"}, "name=value", "set a pattern parameter, e.g. fire.speed=0.7")
+var BIND_FLAGS = goopt.Strings([]string{"-b", "--bind"}, "name=controller", "bind a pattern parameter to a midi controller number, e.g. fire.speed=3")
+var MAPPING_FN = goopt.String([]string{"-m", "--mapping"}, "", "midi mapping file (JSON) which moves knobs and pads to other notes and controllers")
+var MIDI_LEARN = goopt.Flag([]string{"--midi-learn"}, []string{}, "ask for each knob and pad to be wiggled, write them to the --mapping file, and quit", "")
+var MIDI_PATHS = goopt.Strings([]string{"--midi"}, "path", "midi device to use instead of scanning for them, e.g. /dev/snd/midiC1D0 (can be given more than once)")
+var MIDI_NAME = goopt.String([]string{"--midi-name"}, "", "only use midi devices whose name in /proc/asound contains this, e.g. nanoKONTROL2")
+var OSC_PORT = goopt.Int([]string{"--osc-port"}, 0, "listen for OSC control messages (e.g. from TouchOSC) on this UDP port, or 0 for none")
+var OSC_REPLY_PORT = goopt.Int([]string{"--osc-reply-port"}, 0, "send state back to OSC clients on this port, or 0 for the port they send from")
+var MIDI_RECORD_FN = goopt.String([]string{"--midi-record"}, "", "record incoming midi (and OSC) messages with their timing to this file")
+var MIDI_REPLAY_FN = goopt.String([]string{"--midi-replay"}, "", "play back a --midi-record file with its original timing instead of listening to midi devices")
+var MIDI_FILE_FN = goopt.String([]string{"--midi-file"}, "", "play a standard midi file (.mid) instead of listening to midi devices")
+var MIDI_LOOP = goopt.Flag([]string{"--midi-loop"}, []string{}, "loop the --midi-replay or --midi-file forever", "")
+var CONTROLLER = goopt.String([]string{"--controller"}, "", "midi controller profile (one of "+strings.Join(config.ProfileNames(), ", ")+"), or detect it if empty")
+var LIST_CONTROLS = goopt.Flag([]string{"--list-controls"}, []string{}, "print the controller profile's knobs and pads and what they do, and quit", "")
+var HIGH_PRECISION = goopt.Flag([]string{"--high-precision"}, []string{}, "carry colors with more than 8 bits from the patterns to the LEDs, and dither them at the output", "")
+var TIMING = goopt.Flag([]string{"--timing"}, []string{}, "log how long each stage and effect took every second", "")
+var TIMING_HTTP = goopt.String([]string{"--timing-http"}, "", "serve the stage timings at /debug/vars and the profiler at /debug/pprof on this address, e.g. :6060")
+
+// the show config's schedule, or nil if it doesn't have one
+var SCHEDULER *opc.Scheduler
+
+// the --midi-replay recording or --midi-file song, or nil if there isn't one
+var MIDI_REPLAY *midi.Recording
+
+// dims frames which would draw too much current, and estimates the current for the fps log
+var POWER_LIMITER *opc.PowerLimiter
 
 // Parse the command line flags.  If invalid, show help and quit.
 // Add default ports if needed.
 // Read the layout file.
 // Return the number of pixels in the layout, the source and dest thread methods.
-func parseFlags() (nPixels int, sourceThread, effectThread, pottyEffectThread, destThread opc.ByteThread) {
+// With --high-precision, the stages which can handle high-precision colors are FloatThreads.
+func parseFlags() (nPixels int, sourceThread, effectThread, pottyEffectThread, brightnessThread, powerThread, destThread opc.Stage) {
 
 	// get sorted pattern names
 	patternNames := make([]string, len(opc.PATTERN_REGISTRY))
@@ -58,9 +94,54 @@ func parseFlags() (nPixels int, sourceThread, effectThread, pottyEffectThread, d
 	goopt.Summary = "Available source patterns:\n"
 	for _, patternName := range patternNames {
 		goopt.Summary += "          " + patternName + "\n"
+		for _, param := range opc.PATTERN_REGISTRY[patternName].Params {
+			goopt.Summary += "              " + param.String() + "\n"
+		}
+	}
+	goopt.Summary += "Available effects (the default order is " + strings.Join(opc.DEFAULT_EFFECTS, ", ") + "):\n"
+	for _, effectName := range opc.EffectNames() {
+		goopt.Summary += "          " + effectName + "\n"
+		for _, param := range opc.EFFECT_REGISTRY[effectName].Params {
+			goopt.Summary += "              " + param.String() + "\n"
+		}
+	}
+	goopt.Summary += "MIDI controller profiles:\n"
+	for _, profile := range config.PROFILES {
+		goopt.Summary += "          " + profile.Name + " (" + profile.Description + ")\n"
 	}
 	goopt.Parse(nil)
 
+	// choose the controller profile.  if it's not given, use the default until the controller tells us what it is.
+	if *CONTROLLER != "" {
+		if err := config.ApplyProfile(config.LookupProfile(*CONTROLLER)); err != nil {
+			fmt.Println("Error:", err, *CONTROLLER)
+			fmt.Println("--------------------------------------------------------------------------------/")
+			os.Exit(1)
+		}
+	}
+	if *LIST_CONTROLS {
+		config.PrintProfile(config.PROFILE)
+		os.Exit(0)
+	}
+
+	// read the midi mapping, or make one
+	if *MIDI_LEARN {
+		if *MAPPING_FN == "" {
+			fmt.Println("Error: --midi-learn needs a --mapping file to write")
+			fmt.Println("--------------------------------------------------------------------------------/")
+			os.Exit(1)
+		}
+		learnMapping()
+		os.Exit(0)
+	}
+	if *MAPPING_FN != "" {
+		if err := config.ReadMapping(*MAPPING_FN); err != nil {
+			fmt.Println("Error: couldn't read midi mapping:", err)
+			fmt.Println("--------------------------------------------------------------------------------/")
+			os.Exit(1)
+		}
+	}
+
 	// layout is required
 	if *LAYOUT_FN == "..." {
 		fmt.Println(goopt.Usage())
@@ -68,6 +149,54 @@ func parseFlags() (nPixels int, sourceThread, effectThread, pottyEffectThread, d
 		os.Exit(1)
 	}
 
+	// read the show config, then apply parameters from the command line on top of it
+	if *CONFIG_FN != "" {
+		show, err := config.ReadShowConfig(*CONFIG_FN)
+		if err != nil {
+			fmt.Println("Error: couldn't read show config:", err)
+			fmt.Println("--------------------------------------------------------------------------------/")
+			os.Exit(1)
+		}
+		config.SHOW = show
+	}
+	if len(config.SHOW.Schedule.Rules) > 0 {
+		scheduler, err := opc.NewScheduler(config.SHOW.Schedule, config.SHOW.Location)
+		if err != nil {
+			fmt.Println("Error:", err)
+			fmt.Println("--------------------------------------------------------------------------------/")
+			os.Exit(1)
+		}
+		SCHEDULER = scheduler
+	}
+	if *MIDI_REPLAY_FN != "" && *MIDI_FILE_FN != "" {
+		fmt.Println("Error: use either --midi-replay or --midi-file, not both")
+		fmt.Println("--------------------------------------------------------------------------------/")
+		os.Exit(1)
+	}
+	if *MIDI_REPLAY_FN != "" {
+		recording, err := midi.ReadRecording(*MIDI_REPLAY_FN)
+		if err != nil {
+			fmt.Println("Error: couldn't read midi recording:", err)
+			fmt.Println("--------------------------------------------------------------------------------/")
+			os.Exit(1)
+		}
+		MIDI_REPLAY = recording
+	}
+	if *MIDI_FILE_FN != "" {
+		song, err := midi.ReadMidiFile(*MIDI_FILE_FN)
+		if err != nil {
+			fmt.Println("Error: couldn't read midi file:", err)
+			fmt.Println("--------------------------------------------------------------------------------/")
+			os.Exit(1)
+		}
+		MIDI_REPLAY = song
+	}
+	if err := applyParams(); err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println("--------------------------------------------------------------------------------/")
+		os.Exit(1)
+	}
+
 	// read locations
 	locations := opc.ReadLocations(*LAYOUT_FN)
 	nPixels = len(locations) / 3
@@ -86,18 +215,42 @@ func parseFlags() (nPixels int, sourceThread, effectThread, pottyEffectThread, d
 		sourceThread = opc.MakeOpcServerThread(*SOURCE)
 	} else {
 		// source is a pattern name
-		sourceThreadMaker, ok := opc.PATTERN_REGISTRY[*SOURCE]
+		patternEntry, ok := opc.PATTERN_REGISTRY[*SOURCE]
 		if !ok {
 			fmt.Printf("Error: unknown source or pattern \"%s\"\n", *SOURCE)
 			fmt.Println("--------------------------------------------------------------------------------/")
 			os.Exit(1)
 		}
-		sourceThread = sourceThreadMaker(locations)
+		sourceThread = patternEntry.MakeStage(locations, *HIGH_PRECISION)
 	}
+	// each stage records how long it takes, in pipeline order
+	sourceThread = opc.TimeStage(sourceThread, opc.TIMINGS.Get("source"))
 
 	// choose effect thread method
-	effectThread = opc.MakeEffectFader(locations)
-	pottyEffectThread = potty.MakeEffectFaderPattern(locations)
+	effectNames := opc.DEFAULT_EFFECTS
+	if config.SHOW.Effects != nil {
+		effectNames = config.SHOW.Effects
+	}
+	effectTiming := opc.TIMINGS.Get("effects") // before the effects' own timings
+	effectChain, err := opc.NewEffectChain(effectNames)
+	if err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println("--------------------------------------------------------------------------------/")
+		os.Exit(1)
+	}
+	effectThread = opc.TimeStage(opc.PatternToStage(effectChain, locations, *HIGH_PRECISION), effectTiming)
+	pottyEffectThread = opc.TimeStage(opc.ByteThread(potty.MakeEffectFaderPattern(locations)), opc.TIMINGS.Get("potty"))
+
+	// the schedule's brightness cap and the power limiter go last so they see the frame as it will be sent
+	brightnessThread = opc.TimeStage(opc.PatternToStage(opc.NewBrightnessCap(SCHEDULER), locations, *HIGH_PRECISION), opc.TIMINGS.Get("brightness"))
+	powerLimiter, err := opc.NewPowerLimiter(&config.SHOW.Power, nPixels)
+	if err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println("--------------------------------------------------------------------------------/")
+		os.Exit(1)
+	}
+	POWER_LIMITER = powerLimiter
+	powerThread = opc.TimeStage(opc.PatternToStage(powerLimiter, locations, *HIGH_PRECISION), opc.TIMINGS.Get("power"))
 
 	// choose dest thread method
 	switch *DEST {
@@ -106,63 +259,305 @@ func parseFlags() (nPixels int, sourceThread, effectThread, pottyEffectThread, d
 	case PRINT_MAGIC_WORD:
 		destThread = opc.MakeSendToScreenThread()
 	case SPI_MAGIC_WORD:
-		destThread = opc.MakeSendToLPD8806Thread(SPI_FN)
+		if *HIGH_PRECISION {
+			destThread = opc.MakeSendToLPD8806FloatThread(SPI_FN)
+		} else {
+			destThread = opc.MakeSendToLPD8806Thread(SPI_FN)
+		}
 	default:
 		// add default port if needed
 		if !strings.Contains(*DEST, ":") {
 			*DEST += ":7890"
 		}
-		destThread = opc.MakeSendToOpcThread(*DEST)
+		if *HIGH_PRECISION {
+			destThread = opc.MakeSendToOpcFloatThread(*DEST)
+		} else {
+			destThread = opc.MakeSendToOpcThread(*DEST)
+		}
 	}
+	destThread = opc.TimeStage(destThread, opc.TIMINGS.Get("dest"))
 
 	return // returns nPixels, sourceThread, destThread
 }
 
+// Set pattern parameters and midi bindings, first from the show config and then from
+// the command line so that the command line wins.
+func applyParams() error {
+	for name, raw := range config.SHOW.Params {
+		if err := opc.PARAMS.SetFromJSON(name, raw); err != nil {
+			return err
+		}
+	}
+	for name, controller := range config.SHOW.Bindings {
+		if err := opc.PARAMS.Bind(name, controller); err != nil {
+			return err
+		}
+	}
+	for _, assignment := range *PARAM_FLAGS {
+		if err := opc.PARAMS.SetFromString(assignment); err != nil {
+			return err
+		}
+	}
+	for _, binding := range *BIND_FLAGS {
+		if err := opc.PARAMS.BindFromString(binding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start looking for midi devices, or open the ones from the --midi flag.
+func watchMidiDevices() *midi.DeviceWatcher {
+	return midi.WatchDevices(*MIDI_PATHS, *MIDI_NAME)
+}
+
+// OSC problems which have already been printed, so a fader being dragged doesn't flood the log.
+var oscComplaints = make(map[string]bool)
+
+// Return midi messages for the OSC messages which have come in since we last checked.
+// Messages which don't make sense are printed (once) and skipped.
+func getOscMidiMessages(server *osc.Server) []*midi.MidiMessage {
+	result := make([]*midi.MidiMessage, 0)
+	for len(server.Messages) > 0 {
+		m := <-server.Messages
+		value, ok := m.Float(0)
+		if !ok {
+			if !oscComplaints[m.Address] {
+				fmt.Println("[getOscMidiMessages] ignoring", m, "... it needs a number")
+				oscComplaints[m.Address] = true
+			}
+			continue
+		}
+		midiMessage, err := config.RemoteMessage(m.Address, value)
+		if err != nil {
+			if !oscComplaints[m.Address] {
+				fmt.Println("[getOscMidiMessages]", err)
+				oscComplaints[m.Address] = true
+			}
+			continue
+		}
+		if midiMessage != nil {
+			result = append(result, midiMessage)
+		}
+	}
+	return result
+}
+
+// Send the OSC clients whatever has changed.
+func sendOscFeedback(server *osc.Server, changed map[string]float64) {
+	messages := make([]*osc.Message, 0, len(changed))
+	for address, value := range changed {
+		messages = append(messages, &osc.Message{Address: address, Args: []interface{}{float32(value)}})
+	}
+	if err := server.Send(messages); err != nil {
+		fmt.Println("[sendOscFeedback]", err)
+	}
+}
+
+// Switch to the profile for the controller in a SysEx identity reply and put the
+// mapping file's changes back on top of it.  Return true if the profile changed.
+// This rewrites the shared controls, so only call it while no frame is rendering.
+func useDetectedProfile(id *midi.Identity, midiState *midi.MidiState) bool {
+	profile := config.MatchProfile(id)
+	if profile == nil {
+		fmt.Println("[useDetectedProfile] no profile for midi controller", id, "... keeping", config.PROFILE.Name)
+		return false
+	}
+	if profile == config.PROFILE {
+		return false
+	}
+	fmt.Println("[useDetectedProfile] found", profile.Description)
+	if err := config.ApplyProfile(profile); err != nil {
+		fmt.Println("[useDetectedProfile]", err)
+		return false
+	}
+	if *MAPPING_FN != "" {
+		if err := config.ReadMapping(*MAPPING_FN); err != nil {
+			fmt.Println("[useDetectedProfile] couldn't read midi mapping:", err)
+		}
+	}
+	midiState.TapTempoKey = tapTempoKey()
+	config.SetDefaultKnobValues(midiState)
+	return true
+}
+
+// Return the note which taps the tempo, or 0 for none.
+func tapTempoKey() byte {
+	if config.TAP_TEMPO_PAD.Off || config.TAP_TEMPO_PAD.Kind != config.PAD {
+		return 0
+	}
+	return config.TAP_TEMPO_PAD.Number
+}
+
+// Run midi learn and write the result to the mapping file.
+// If the mapping file already exists, start from it so skipped controls keep their settings.
+func learnMapping() {
+	if _, err := os.Stat(*MAPPING_FN); err == nil {
+		if err := config.ReadMapping(*MAPPING_FN); err != nil {
+			fmt.Println("Error: couldn't read midi mapping:", err)
+			os.Exit(1)
+		}
+	}
+
+	// pressing enter skips a control
+	skip := make(chan bool)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			if buf[0] == '\n' {
+				skip <- true
+			}
+		}
+	}()
+
+	config.Learn(watchMidiDevices().Messages, skip)
+	if err := config.WriteMapping(*MAPPING_FN); err != nil {
+		fmt.Println("Error: couldn't write midi mapping:", err)
+		os.Exit(1)
+	}
+	fmt.Println("[learnMapping] wrote", *MAPPING_FN)
+}
+
+// A frame the patterns have rendered, kept to interpolate between.
+type renderedFrame struct {
+	bytes     []byte
+	colors    []float32 // for high-precision frames
+	midiState *midi.MidiState
+	t         float64 // when it was rendered for, in seconds
+}
+
 // Launch the sourceThread and destThread methods and coordinate the transfer of bytes from one to the other.
 // Run until timeToRun seconds have passed and return.  If timeToRun is 0, run forever.
 // Turn on the CPU profiler if timeToRun seconds > 0.
 // Limit the framerate to a max of fps unless fps is 0.
-func mainLoop(nPixels int, sourceThread, effectThread, pottyEffectThread, destThread opc.ByteThread, fps float64, timeToRun float64) {
+// If renderFps is less than fps, render the patterns at renderFps and send blends of their frames
+// in between, so that the output runs at fps even when the patterns can't.
+func mainLoop(nPixels int, sourceThread, effectThread, pottyEffectThread, brightnessThread, powerThread, destThread opc.Stage, fps, renderFps float64, timeToRun float64) {
 	if timeToRun > 0 {
 		fmt.Printf("[mainLoop] Running for %f seconds with profiling turned on, pixels and network\n", timeToRun)
 		defer profile.Start(profile.CPUProfile).Stop()
 	} else {
 		fmt.Println("[mainLoop] Running forever")
 	}
+	// however we stop, say where the time went.  ctrl-C stops the loop instead of the program so this gets to run.
+	// the first one puts the usual handling back, so if the loop is stuck a second one still kills the program.
+	defer printTimingSummary()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	interrupted := make(chan bool)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Println("[mainLoop] interrupted.  stopping after this frame; interrupt again to quit right away.")
+		close(interrupted)
+	}()
+	if *TIMING_HTTP != "" {
+		serveTimings(*TIMING_HTTP)
+	}
 
 	// prepare the byte slices and channels that connect the source and dest threads
 	fillingSlice := make([]byte, nPixels*3)
 	sendingSlice := make([]byte, nPixels*3)
+	// high-precision frames carry their colors as well
+	var fillingColors, sendingColors []float32
+	if *HIGH_PRECISION {
+		fillingColors = make([]float32, nPixels*3)
+		sendingColors = make([]float32, nPixels*3)
+	}
 
-	bytesToFillChan := make(chan []byte, 0)
-	toEffectChan := make(chan []byte, 0)
-	toPottyEffectChan := make(chan []byte, 0)
-	bytesFilledChan := make(chan []byte, 0)
-	bytesToSendChan := make(chan []byte, 0)
-	bytesSentChan := make(chan []byte, 0)
+	bytesToFillChan := make(chan *opc.Frame, 0)
+	toEffectChan := make(chan *opc.Frame, 0)
+	toPottyEffectChan := make(chan *opc.Frame, 0)
+	toBrightnessChan := make(chan *opc.Frame, 0)
+	toPowerChan := make(chan *opc.Frame, 0)
+	bytesFilledChan := make(chan *opc.Frame, 0)
+	bytesToSendChan := make(chan *opc.Frame, 0)
+	bytesSentChan := make(chan *opc.Frame, 0)
 
 	// set up midi
-	midiPath := ""
-	if _, err := os.Stat("/dev/midi1"); err == nil {
-		// path/to/whatever exists
-		midiPath = "/dev/midi1"
-	} else if os.IsNotExist(err) {
-		//path/to/whatever does *not* exist
-		midiPath = "/dev/midi2"
-	}
-	midiMessageChan := midi.GetMidiMessageStream(midiPath) // this launches the midi thread
-	midiState := midi.MidiState{}
+	var midiMessageChan, midiOutChan chan *midi.MidiMessage
+	if MIDI_REPLAY != nil {
+		// replay instead of listening to the controllers.
+		// nothing reads midiOutChan, so the pad lights are dropped.
+		fmt.Println("[mainLoop] replaying midi from", *MIDI_REPLAY_FN+*MIDI_FILE_FN)
+		midiMessageChan = midi.GetMidiReplayStream(MIDI_REPLAY, *MIDI_LOOP) // this launches the replay thread
+		midiOutChan = make(chan *midi.MidiMessage)
+	} else {
+		midiDevices := watchMidiDevices() // this launches the midi threads
+		midiMessageChan = midiDevices.Messages
+		midiOutChan = midiDevices.Out
+	}
+	var midiRecorder *midi.Recorder
+	if *MIDI_RECORD_FN != "" {
+		recorder, err := midi.NewRecorder(*MIDI_RECORD_FN)
+		if err != nil {
+			fmt.Println("[mainLoop] couldn't start recording midi:", err)
+		} else {
+			fmt.Println("[mainLoop] recording midi to", *MIDI_RECORD_FN)
+			midiRecorder = recorder
+			defer midiRecorder.Close()
+		}
+	}
+	midiState := midi.MidiState{TapTempoKey: tapTempoKey()}
+	padLights := config.NewPadLights()
+	var oscServer *osc.Server
+	remoteFeedback := config.NewRemoteFeedback()
+	if *OSC_PORT != 0 {
+		server, err := osc.Listen(fmt.Sprintf(":%d", *OSC_PORT), *OSC_REPLY_PORT)
+		if err != nil {
+			fmt.Println("[mainLoop] couldn't start the OSC server:", err)
+		} else {
+			oscServer = server
+		}
+	}
+	// each controller is asked what it is when it's plugged in.
+	// believe its answer unless the --controller flag already told us.
+	detectController := *CONTROLLER == ""
+	var detectedId *midi.Identity // a controller's answer, waiting for a gap between frames
 	// set initial values for controller knobs
 	//  (because the midi hardware only sends us values when the knobs move)
-	for knob, defaultVal := range config.DEFAULT_KNOB_VALUES {
-		midiState.ControllerValues[knob] = defaultVal
-	}
+	config.SetDefaultKnobValues(&midiState)
+	// smoothing and pickup for the knobs
+	controlLayer := config.NewControlLayer()
+
+	// launch the threads.
+	// midiState belongs to this loop; each frame carries its own snapshot of it to the threads
+	opc.RunStage(sourceThread, bytesToFillChan, toEffectChan)
+	opc.RunStage(effectThread, toEffectChan, toPottyEffectChan)
+	opc.RunStage(pottyEffectThread, toPottyEffectChan, toBrightnessChan)
+	opc.RunStage(brightnessThread, toBrightnessChan, toPowerChan)
+	opc.RunStage(powerThread, toPowerChan, bytesFilledChan)
+	opc.RunStage(destThread, bytesToSendChan, bytesSentChan)
+	// the frame being sent keeps the snapshot it was filled with
+	var sendingState *midi.MidiState
 
-	// launch the threads
-	go sourceThread(bytesToFillChan, toEffectChan, &midiState)
-	go effectThread(toEffectChan, toPottyEffectChan, &midiState)
-	go pottyEffectThread(toPottyEffectChan, bytesFilledChan, &midiState)
-	go destThread(bytesToSendChan, bytesSentChan, &midiState)
+	// when interpolating, the patterns render on their own schedule while the output keeps going:
+	// one frame is rendering while the output blends the two before it.
+	interpolate := renderFps > 0 && (fps <= 0 || renderFps < fps) && !*ONCE
+	render_budget := 0.0
+	var filling, older, newer *renderedFrame
+	if interpolate {
+		fmt.Printf("[mainLoop] rendering at %v fps and interpolating\n", renderFps)
+		render_budget = 1 / renderFps
+		filling = &renderedFrame{bytes: fillingSlice, colors: fillingColors}
+		older = &renderedFrame{bytes: make([]byte, nPixels*3)}
+		newer = &renderedFrame{bytes: make([]byte, nPixels*3)}
+		if *HIGH_PRECISION {
+			older.colors = make([]float32, nPixels*3)
+			newer.colors = make([]float32, nPixels*3)
+		}
+	}
+	rendering := false // is a frame on its way through the patterns?
+	// midi events since the last render began, so the patterns don't miss the ones between renders
+	var recentEvents midi.RecentEvents
+	nRendered := 0     // how many of older and newer have been rendered
+	nextRenderTime := 0.0 // or 0 if the next render isn't on a schedule yet
+	renderStartTime := 0.0
+	renderDropped := 0 // renders skipped before the one rendering now
+	rendersSinceLastPrint := 0
 
 	// main loop
 	frame_budget_ms := 1000.0 / fps
@@ -171,18 +566,42 @@ func mainLoop(nPixels int, sourceThread, effectThread, pottyEffectThread, destTh
 	frameStartTime := startTime
 	frameEndTime := startTime
 	framesSinceLastPrint := 0
+	lateSinceLastPrint, droppedSinceLastPrint := 0, 0
+	overrun := 0.0 // seconds late frames have run over their budget, less the frames dropped for it
 	firstIteration := true
+	isOff := false
 	flipper := 0
 	beaglebone.SetOnboardLED(0, 1)
 	for {
-		// if we have any frame budget left from last time around, sleep to control the framerate
+		select {
+		case <-interrupted:
+			fmt.Println("[mainLoop] interrupted.  quitting now.")
+			return
+		default:
+		}
+
+		// if we have any frame budget left from last time around, sleep to control the framerate.
+		// otherwise the last frame was late, and each whole frame's worth of lateness is a dropped frame.
+		late, dropped := false, 0
 		if fps > 0 {
 			frameEndTime = float64(time.Now().UnixNano()) / 1.0e9
 			timeRemaining := float64(frame_budget_ms)/1000 - (frameEndTime - frameStartTime)
 			if timeRemaining > 0 {
 				time.Sleep(time.Duration(timeRemaining*1000*1000) * time.Microsecond)
+			} else {
+				late = true
+				overrun -= timeRemaining
+				dropped = int(overrun / (frame_budget_ms / 1000))
+				overrun -= float64(dropped) * frame_budget_ms / 1000
 			}
 		}
+		if !isOff {
+			opc.TIMINGS.CountFrame("output", late, dropped)
+			if late {
+				lateSinceLastPrint += 1
+			}
+			droppedSinceLastPrint += dropped
+		}
 
 		// fps reporting and bookkeeping
 		// print framerate occasionally
@@ -190,8 +609,23 @@ func mainLoop(nPixels int, sourceThread, effectThread, pottyEffectThread, destTh
 		framesSinceLastPrint += 1
 		if frameStartTime > lastPrintTime+1 {
 			lastPrintTime = frameStartTime
-			fmt.Printf("[mainLoop] %f ms/frame (%d fps)\n", 1000.0/float64(framesSinceLastPrint), framesSinceLastPrint)
+			rates := fmt.Sprintf("%f ms/frame (%d fps)", 1000.0/float64(framesSinceLastPrint), framesSinceLastPrint)
+			if interpolate {
+				rates = fmt.Sprintf("%f ms/frame (%d fps, rendering %d fps)", 1000.0/float64(framesSinceLastPrint), framesSinceLastPrint, rendersSinceLastPrint)
+			}
+			if lateSinceLastPrint > 0 {
+				rates += fmt.Sprintf(", %d late, %d dropped", lateSinceLastPrint, droppedSinceLastPrint)
+			}
+			rates += ", slowest " + logTimings(*TIMING)
+			amps, unlimitedAmps := POWER_LIMITER.Amps()
+			if amps < unlimitedAmps {
+				fmt.Printf("[mainLoop] %s, %.1f amps (limited from %.1f)\n", rates, amps, unlimitedAmps)
+			} else {
+				fmt.Printf("[mainLoop] %s, %.1f amps\n", rates, amps)
+			}
 			framesSinceLastPrint = 0
+			rendersSinceLastPrint = 0
+			lateSinceLastPrint, droppedSinceLastPrint = 0, 0
 			// toggle LED
 			beaglebone.SetOnboardLED(ONBOARD_LED_HEARTBEAT, flipper)
 			flipper = 1 - flipper
@@ -202,20 +636,146 @@ func mainLoop(nPixels int, sourceThread, effectThread, pottyEffectThread, destTh
 			return
 		}
 
-		// get midi
-		midiState.UpdateStateFromChannel(midiMessageChan)
+		// get midi, and midi messages made from OSC messages, and feed both to the midi state
+		midiMessages := midi.GetAvailableMidiMessages(midiMessageChan)
+		if oscServer != nil {
+			midiMessages = append(midiMessages, getOscMidiMessages(oscServer)...)
+		}
+		if midiRecorder != nil {
+			if err := midiRecorder.Record(midiMessages); err != nil {
+				fmt.Println("[mainLoop] couldn't record midi:", err)
+				midiRecorder = nil
+			}
+		}
+		midiState.UpdateStateFromSlice(midiMessages)
+		if interpolate {
+			recentEvents.Add(&midiState)
+		}
+		if detectController {
+			for _, m := range midiState.RecentMidiMessages {
+				if id := midi.ParseIdentityReply(m); id != nil {
+					detectedId = id
+				}
+			}
+		}
+		// the patterns and effects read the controls while they render, so the profile
+		// only changes them when no frame is on its way through the threads
+		if detectedId != nil && !rendering {
+			if useDetectedProfile(detectedId, &midiState) {
+				controlLayer.Reset()
+			}
+			detectedId = nil
+		}
+		controlLayer.Update(&midiState, time.Now())
+		// light the controller's pads.  if the output thread falls behind, skip lights rather than waiting;
+		// the skipped ones go again next time around
+		selected := opc.SwitcherSelected()
+		for _, m := range padLights.Update(&midiState, selected, time.Now()) {
+			select {
+			case midiOutChan <- m:
+				padLights.Sent(m)
+			default:
+			}
+		}
+		if oscServer != nil {
+			sendOscFeedback(oscServer, remoteFeedback.Update(&midiState, selected, time.Now()))
+		}
 		if len(midiState.RecentMidiMessages) > 0 {
 			beaglebone.SetOnboardLED(ONBOARD_LED_MIDI, 1)
 		} else {
 			beaglebone.SetOnboardLED(ONBOARD_LED_MIDI, 0)
 		}
 
+		// during the schedule's off windows, send one black frame and then leave the LEDs alone
+		if SCHEDULER != nil && SCHEDULER.IsOff(time.Now()) {
+			if !isOff {
+				fmt.Println("[mainLoop] schedule says off.  going dark.")
+				for ii := range sendingSlice {
+					sendingSlice[ii] = 0
+				}
+				bytesToSendChan <- &opc.Frame{Bytes: sendingSlice, MidiState: midiState.Snapshot(time.Now())}
+				<-bytesSentChan
+				isOff = true
+			}
+			// start over with a fresh frame when we come back on
+			firstIteration = true
+			if rendering {
+				<-bytesFilledChan
+				rendering = false
+			}
+			nRendered = 0
+			nextRenderTime = 0
+			recentEvents = midi.RecentEvents{}
+			continue
+		}
+		if isOff {
+			fmt.Println("[mainLoop] schedule says on.  waking up.")
+			isOff = false
+		}
+
+		if interpolate {
+			// start rendering the next frame if the patterns are free and it's time
+			if !rendering && frameStartTime >= nextRenderTime {
+				filling.midiState = recentEvents.Snapshot(&midiState, time.Now())
+				filling.t = frameStartTime
+				for ii := range filling.colors {
+					filling.colors[ii] = 0
+				}
+				bytesToFillChan <- &opc.Frame{Bytes: filling.bytes, Colors: filling.colors, MidiState: filling.midiState}
+				rendering = true
+				renderStartTime = frameStartTime
+				// keep to the schedule, but don't try to catch up after falling behind.
+				// the renders which were due in the meantime are dropped.
+				renderDropped = 0
+				if nextRenderTime > 0 {
+					renderDropped = int((frameStartTime - nextRenderTime) / render_budget)
+				}
+				nextRenderTime += render_budget
+				if nextRenderTime < frameStartTime {
+					nextRenderTime = frameStartTime
+				}
+			}
+
+			// pick up the frame when it's done, without waiting for it
+			select {
+			case <-bytesFilledChan:
+				rendering = false
+				rendersSinceLastPrint += 1
+				renderTime := float64(time.Now().UnixNano())/1.0e9 - renderStartTime
+				opc.TIMINGS.CountFrame("render", renderTime > render_budget, renderDropped)
+				filling, older, newer = older, newer, filling
+				if nRendered < 2 {
+					nRendered += 1
+				}
+			default:
+			}
+
+			// send the blend of the last two frames.  showing them one render behind
+			// means there's nearly always a newer frame to blend towards.
+			if nRendered == 2 {
+				amount := colorutils.Clamp((frameStartTime-render_budget-older.t)/(newer.t-older.t), 0, 1)
+				if *HIGH_PRECISION {
+					opc.InterpolateColors(sendingColors, older.colors, newer.colors, amount)
+				} else {
+					opc.InterpolateBytes(sendingSlice, older.bytes, newer.bytes, amount)
+				}
+				bytesToSendChan <- &opc.Frame{Bytes: sendingSlice, Colors: sendingColors, MidiState: newer.midiState}
+				<-bytesSentChan
+			}
+			continue
+		}
+
 		// start the threads filling and sending slices in parallel.
 		// if this is the first time through the loop we have to skip
 		//  the sending stage or we'll send out a whole bunch of zeros.
-		bytesToFillChan <- fillingSlice
+		fillingState := midiState.Snapshot(time.Now())
+		// start from black so that an 8-bit source's bytes are taken exactly as they are
+		for ii := range fillingColors {
+			fillingColors[ii] = 0
+		}
+		bytesToFillChan <- &opc.Frame{Bytes: fillingSlice, Colors: fillingColors, MidiState: fillingState}
 		if !firstIteration {
-			bytesToSendChan <- sendingSlice
+			bytesToSendChan <- &opc.Frame{Bytes: sendingSlice, Colors: sendingColors, MidiState: sendingState}
 		}
 
 		// if only sending one frame, let's just get it all over with now
@@ -238,15 +798,59 @@ func mainLoop(nPixels int, sourceThread, effectThread, pottyEffectThread, destTh
 
 		// swap the slices
 		sendingSlice, fillingSlice = fillingSlice, sendingSlice
+		sendingColors, fillingColors = fillingColors, sendingColors
+		sendingState = fillingState
 
 		firstIteration = false
 	}
 }
 
+// Serve the stage timings as JSON at /debug/vars, and the profiler at /debug/pprof, on addr.
+func serveTimings(addr string) {
+	expvar.Publish("timings", expvar.Func(func() interface{} { return opc.TIMINGS.Report() }))
+	go func() {
+		fmt.Printf("[serveTimings] serving timings at http://%s/debug/vars\n", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			fmt.Println("[serveTimings] couldn't serve timings:", err)
+		}
+	}()
+}
+
+// Start a new timing interval for every stage and return the slowest stage's name and average.
+// If verbose, log every stage's timing over the interval.
+func logTimings(verbose bool) string {
+	slowest := ""
+	slowestAvg := -1.0
+	for _, tm := range opc.TIMINGS.All() {
+		stats := tm.Interval()
+		if verbose {
+			fmt.Printf("[mainLoop]   %-24s %s\n", tm.Name, stats)
+		}
+		// the effects are timed inside the effects stage, so they don't compete with it
+		if !strings.Contains(tm.Name, ".") && stats.Avg > slowestAvg {
+			slowest = fmt.Sprintf("%s %.2f ms", tm.Name, stats.Avg)
+			slowestAvg = stats.Avg
+		}
+	}
+	return slowest
+}
+
+// Print every stage's timing over the whole run, and how many frames were late or dropped.
+func printTimingSummary() {
+	fmt.Println("[mainLoop] timing summary:")
+	for _, tm := range opc.TIMINGS.All() {
+		fmt.Printf("[mainLoop]   %-24s %s\n", tm.Name, tm.Total())
+	}
+	for _, name := range opc.TIMINGS.CountNames() {
+		counts := opc.TIMINGS.Counts(name)
+		fmt.Printf("[mainLoop]   %d %s frames, %d late, %d dropped\n", counts.Frames, name, counts.Late, counts.Dropped)
+	}
+}
+
 func main() {
 	fmt.Println("--------------------------------------------------------------------------------\\")
 	defer fmt.Println("--------------------------------------------------------------------------------/")
 
-	nPixels, sourceThread, effectThread, pottyEffectThread, destThread := parseFlags()
-	mainLoop(nPixels, sourceThread, effectThread, pottyEffectThread, destThread, float64(*FPS), float64(*SECONDS))
+	nPixels, sourceThread, effectThread, pottyEffectThread, brightnessThread, powerThread, destThread := parseFlags()
+	mainLoop(nPixels, sourceThread, effectThread, pottyEffectThread, brightnessThread, powerThread, destThread, float64(*FPS), float64(*RENDER_FPS), float64(*SECONDS))
 }
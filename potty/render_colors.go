@@ -13,10 +13,10 @@ const (
 	CSpeed    = 0.004 // How fast they go up
 	CSpeedVar = 0.004 // Speed variation each time a circle starts over
 	CLifeSpan = 0.75
-
-	CircleButton = config.BLINK_CIRCLE_PAD
 )
 
+var CircleButton = config.BLINK_CIRCLE_PAD
+
 type ColorDanceEffect struct {
 	space            *PixelSpace
 	circles          map[float64]*Circle
@@ -43,16 +43,16 @@ func (e *ColorDanceEffect) Render(midiState *midi.MidiState, t float64) {
 	/* fake button
 	if t > e.fakeButtonPress+0.5 {
 		e.fakeButtonPress = t
-		midiState.KeyVolumes[CircleButton] = 100
+		midiState.KeyVolumes[CircleButton.Number] = 100
 	} else {
-		midiState.KeyVolumes[CircleButton] = 0
+		midiState.KeyVolumes[CircleButton.Number] = 0
 	}
 	*/
-	if midiState.KeyVolumes[CircleButton] == 0 {
+	if !CircleButton.Down(midiState) {
 		e.buttonPressed = false
 	}
 
-	if !e.buttonPressed && midiState.KeyVolumes[CircleButton] > 0 {
+	if !e.buttonPressed && CircleButton.Down(midiState) {
 		e.buttonPressed = true
 		circle := NewCircle(e.space, t)
 		e.circles[circle.ID()] = circle
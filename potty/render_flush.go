@@ -4,7 +4,7 @@ import (
 	"math"
 
 	"github.com/longears/pixelslinger/colorutils"
-	"github.com/austinfromboston/pixelslinger/config"
+	"github.com/longears/pixelslinger/config"
 	"github.com/longears/pixelslinger/midi"
 )
 
@@ -16,10 +16,10 @@ const (
 
 	// Size of falling water streams when draining
 	FlushStreamerSize = 0.15
-
-	FlushControlPad = config.FLUSH_PAD
 )
 
+var FlushControlPad = config.FLUSH_PAD
+
 type FlushEffect struct {
 	space      *PixelSpace
 	random     []float64
@@ -41,7 +41,7 @@ func NewFlushEffect(space *PixelSpace) *FlushEffect {
 }
 
 func (f *FlushEffect) SetFlushState(midiState *midi.MidiState, t float64) {
-	flushPad := midiState.KeyVolumes[FlushControlPad]
+	flushPad := FlushControlPad.Raw(midiState)
 
 	switch {
 	/* Fake flush
@@ -0,0 +1,104 @@
+/*
+Package sun computes the position of the sun and the times of sunrise and sunset
+for a place on Earth.  Everything is calculated locally so it works offline.
+
+The formulas are the usual low-precision ones from the Astronomical Almanac and are
+good to about a minute, which is plenty for scheduling lights.
+
+For more details:
+
+https://en.wikipedia.org/wiki/Sunrise_equation
+
+https://en.wikipedia.org/wiki/Position_of_the_Sun
+*/
+package sun
+
+import (
+	"math"
+	"time"
+)
+
+// Altitude of the sun's center at sunrise and sunset, in degrees.
+// This accounts for atmospheric refraction and the radius of the sun's disc.
+const HORIZON = -0.833
+
+// Julian date of the J2000 epoch.
+const J2000 = 2451545.0
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// Convert a time to a Julian date.
+func julianDate(t time.Time) float64 {
+	return float64(t.UnixNano())/1.0e9/86400 + 2440587.5
+}
+
+// Convert a Julian date to a time in the given location.
+func fromJulianDate(jd float64, loc *time.Location) time.Time {
+	seconds := (jd - 2440587.5) * 86400
+	return time.Unix(0, int64(seconds*1.0e9)).In(loc)
+}
+
+// Return the sun's elevation above the horizon and its azimuth (clockwise from north),
+// both in degrees, at time t as seen from the given latitude and longitude.
+// Longitude is positive to the east.
+func Position(t time.Time, lat, lon float64) (elevation, azimuth float64) {
+	n := julianDate(t) - J2000
+
+	// ecliptic coordinates
+	meanLongitude := 280.460 + 0.9856474*n
+	meanAnomaly := radians(357.528 + 0.9856003*n)
+	eclipticLongitude := radians(meanLongitude + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly))
+	obliquity := radians(23.439 - 0.0000004*n)
+
+	// equatorial coordinates
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLongitude), math.Cos(eclipticLongitude))
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLongitude))
+
+	// local hour angle from greenwich mean sidereal time
+	gmst := 18.697374558 + 24.06570982441908*n // hours
+	hourAngle := radians(gmst*15+lon) - rightAscension
+
+	// horizontal coordinates
+	phi := radians(lat)
+	elevation = math.Asin(math.Sin(phi)*math.Sin(declination) + math.Cos(phi)*math.Cos(declination)*math.Cos(hourAngle))
+	azimuth = math.Atan2(-math.Sin(hourAngle), math.Tan(declination)*math.Cos(phi)-math.Sin(phi)*math.Cos(hourAngle))
+	return degrees(elevation), math.Mod(degrees(azimuth)+360, 360)
+}
+
+// Return the times of sunrise and sunset on the calendar day of date (in date's time zone)
+// at the given latitude and longitude.  Longitude is positive to the east.
+// ok is false if the sun doesn't rise or set that day (polar day or night).
+func RiseSet(date time.Time, lat, lon float64) (rise, set time.Time, ok bool) {
+	loc := date.Location()
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, loc)
+
+	// mean solar noon
+	n := math.Floor(julianDate(noon) - J2000 + 0.5)
+	jStar := n - lon/360
+
+	// solar mean anomaly, equation of the center, and ecliptic longitude
+	meanAnomaly := math.Mod(357.5291+0.98560028*jStar, 360)
+	m := radians(meanAnomaly)
+	center := 1.9148*math.Sin(m) + 0.0200*math.Sin(2*m) + 0.0003*math.Sin(3*m)
+	eclipticLongitude := radians(math.Mod(meanAnomaly+center+180+102.9372, 360))
+
+	// solar transit, declination, and the hour angle of sunrise and sunset
+	transit := J2000 + jStar + 0.0053*math.Sin(m) - 0.0069*math.Sin(2*eclipticLongitude)
+	declination := math.Asin(math.Sin(eclipticLongitude) * math.Sin(radians(23.4397)))
+	phi := radians(lat)
+	cosHourAngle := (math.Sin(radians(HORIZON)) - math.Sin(phi)*math.Sin(declination)) / (math.Cos(phi) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := degrees(math.Acos(cosHourAngle))
+
+	rise = fromJulianDate(transit-hourAngle/360, loc)
+	set = fromJulianDate(transit+hourAngle/360, loc)
+	return rise, set, true
+}
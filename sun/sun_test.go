@@ -0,0 +1,66 @@
+package sun
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// San Francisco
+const (
+	LAT = 37.7749
+	LON = -122.4194
+)
+
+func near(t *testing.T, what string, got, want time.Time, slop time.Duration) {
+	diff := got.Sub(want)
+	if diff < -slop || diff > slop {
+		t.Errorf("%s: got %v, want %v (off by %v)", what, got, want, diff)
+	}
+}
+
+//================================================================================
+func TestRiseSet(t *testing.T) {
+	pacific := time.FixedZone("PDT", -7*60*60)
+	date := time.Date(2024, 6, 21, 9, 0, 0, 0, pacific)
+	rise, set, ok := RiseSet(date, LAT, LON)
+	if !ok {
+		t.Fatalf("the sun should rise and set in San Francisco")
+	}
+	near(t, "sunrise", rise, time.Date(2024, 6, 21, 5, 48, 0, 0, pacific), 3*time.Minute)
+	near(t, "sunset", set, time.Date(2024, 6, 21, 20, 35, 0, 0, pacific), 3*time.Minute)
+}
+
+func TestRiseSetPolar(t *testing.T) {
+	// midsummer and midwinter in Longyearbyen, Svalbard
+	if _, _, ok := RiseSet(time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), 78.22, 15.65); ok {
+		t.Errorf("the sun should not set at midsummer in Svalbard")
+	}
+	if _, _, ok := RiseSet(time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), 78.22, 15.65); ok {
+		t.Errorf("the sun should not rise at midwinter in Svalbard")
+	}
+}
+
+//================================================================================
+func TestPosition(t *testing.T) {
+	date := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	rise, set, _ := RiseSet(date, LAT, LON)
+
+	// the sun should be right at the horizon at sunrise and sunset
+	for _, when := range []time.Time{rise, set} {
+		elevation, _ := Position(when, LAT, LON)
+		if math.Abs(elevation-HORIZON) > 0.5 {
+			t.Errorf("elevation at %v = %f, want about %f", when, elevation, HORIZON)
+		}
+	}
+
+	// at solar noon on the solstice it should be due south and 90 - (37.77 - 23.44) degrees up
+	noon := rise.Add(set.Sub(rise) / 2)
+	elevation, azimuth := Position(noon, LAT, LON)
+	if math.Abs(elevation-75.67) > 0.5 {
+		t.Errorf("noon elevation = %f, want about 75.67", elevation)
+	}
+	if math.Abs(azimuth-180) > 2 {
+		t.Errorf("noon azimuth = %f, want about 180", azimuth)
+	}
+}